@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"kube-git-backup/internal/collector"
+	"kube-git-backup/internal/config"
+	"kube-git-backup/internal/logging"
+	"kube-git-backup/internal/sanitizer"
+)
+
+// runSummary is the JSON summary of a one-shot backup written by --summary
+type runSummary struct {
+	RunID            string            `json:"runId,omitempty"`
+	Cluster          string            `json:"cluster"`
+	StartedAt        time.Time         `json:"startedAt"`
+	DurationSeconds  float64           `json:"durationSeconds"`
+	Success          bool              `json:"success"`
+	Error            string            `json:"error,omitempty"`
+	Resources        int               `json:"resources"`
+	ResourcesByKind  map[string]int    `json:"resourcesByKind"`
+	CollectionErrors map[string]string `json:"collectionErrors,omitempty"`
+	InvalidResources []invalidSummary  `json:"invalidResources,omitempty"`
+	Commit           string            `json:"commit,omitempty"`
+	Added            int               `json:"added"`
+	Modified         int               `json:"modified"`
+	Deleted          int               `json:"deleted"`
+	Sinks            []sinkSummary     `json:"sinks"`
+}
+
+// invalidSummary describes a resource left out because of an invalid manifest
+// or a path collision
+type invalidSummary struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+	Error      string `json:"error"`
+}
+
+// sinkSummary is the part of the summary describing a single sink
+type sinkSummary struct {
+	Name      string `json:"name"`
+	Reference string `json:"reference,omitempty"`
+	Added     int    `json:"added"`
+	Modified  int    `json:"modified"`
+	Deleted   int    `json:"deleted"`
+}
+
+// runBackupCommand implements the backup subcommand, which runs a single
+// backup and exits, for use in CronJobs and CI
+func runBackupCommand(args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s backup --once [--summary]\n\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+
+	once := flags.Bool("once", false, "Run a single backup and exit, non-zero on any failure")
+	summary := flags.Bool("summary", false, "Write a JSON summary of the run to stdout")
+	flags.Parse(args)
+
+	if !*once {
+		flags.Usage()
+		return fmt.Errorf("--once is required, run without a subcommand to start the daemon")
+	}
+
+	// Load and validate configuration from environment variables
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	if err := logging.Setup(os.Stderr, cfg.LogFormat, cfg.LogLevel); err != nil {
+		return fmt.Errorf("failed to set up logging: %w", err)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	// Back up every cluster concurrently, each failing on its own
+	clusters := cfg.Clusters()
+	summaries := make([]runSummary, len(clusters))
+	errs := make([]error, len(clusters))
+	var wg sync.WaitGroup
+	for i, clusterCfg := range clusters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			clusterCtx := logging.NewContext(ctx, slog.Default().With("cluster", clusterCfg.ClusterName))
+			started := time.Now()
+			stats, err := runBackupOnce(clusterCtx, clusterCfg)
+			if err != nil && len(clusters) > 1 {
+				err = fmt.Errorf("cluster %s: %w", clusterCfg.ClusterName, err)
+			}
+			summaries[i] = newSummary(clusterCfg, started, stats, err)
+			errs[i] = err
+		}()
+	}
+	wg.Wait()
+
+	// Without CLUSTERS_FILE the summary is a single object, with it a list
+	// with one entry per cluster
+	if *summary {
+		var output any = summaries
+		if len(cfg.Targets) == 0 {
+			output = summaries[0]
+		}
+		if err := writeSummary(output); err != nil {
+			slog.Error("Failed to write summary", "error", err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// runBackupOnce runs a single backup, failing if any resource type could not
+// be collected or any resource had an invalid manifest or path
+func runBackupOnce(ctx context.Context, cfg *config.Config) (*backupStats, error) {
+	kubeCollector, err := collector.NewKubernetesCollector(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize Kubernetes collector: %w", err)
+	}
+
+	sinks, err := newSinks(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize sinks: %w", err)
+	}
+	defer closeSinks(sinks)
+
+	yamlSanitizer := sanitizer.NewYAMLSanitizer(cfg.Sanitizer)
+
+	stats, err := runBackup(ctx, cfg, kubeCollector, yamlSanitizer, sinks)
+	if err != nil {
+		return stats, err
+	}
+
+	if len(stats.CollectionErrors) > 0 {
+		return stats, fmt.Errorf("failed to collect %s", strings.Join(failedResourceTypes(stats.CollectionErrors), ", "))
+	}
+	if len(stats.InvalidResources) > 0 {
+		return stats, fmt.Errorf("%d resources have an invalid manifest or path", len(stats.InvalidResources))
+	}
+
+	return stats, nil
+}
+
+// newSummary builds the summary of the run of a cluster
+func newSummary(cfg *config.Config, started time.Time, stats *backupStats, runErr error) runSummary {
+	summary := runSummary{
+		Cluster:         cfg.ClusterName,
+		StartedAt:       started.UTC(),
+		DurationSeconds: time.Since(started).Seconds(),
+		Success:         runErr == nil,
+		ResourcesByKind: map[string]int{},
+		Sinks:           []sinkSummary{},
+	}
+	if runErr != nil {
+		summary.Error = runErr.Error()
+	}
+
+	if stats != nil {
+		summary.RunID = stats.RunID
+		summary.Resources = stats.Resources
+		summary.ResourcesByKind = stats.ResourcesByKind
+
+		if len(stats.CollectionErrors) > 0 {
+			summary.CollectionErrors = make(map[string]string, len(stats.CollectionErrors))
+			for _, typeErr := range stats.CollectionErrors {
+				summary.CollectionErrors[typeErr.Resource] = typeErr.Err.Error()
+			}
+		}
+
+		for _, invalid := range stats.InvalidResources {
+			summary.InvalidResources = append(summary.InvalidResources, invalidSummary{
+				APIVersion: invalid.APIVersion,
+				Kind:       invalid.Kind,
+				Namespace:  invalid.Namespace,
+				Name:       invalid.Name,
+				Error:      invalid.Err.Error(),
+			})
+		}
+
+		// The top-level counts are those of the first sink
+		for i, result := range stats.Results {
+			added, modified, deleted := result.Counts()
+			summary.Sinks = append(summary.Sinks, sinkSummary{
+				Name:      result.Sink,
+				Reference: result.Reference,
+				Added:     added,
+				Modified:  modified,
+				Deleted:   deleted,
+			})
+
+			if i == 0 {
+				summary.Added, summary.Modified, summary.Deleted = added, modified, deleted
+			}
+			if result.Sink == config.SinkGit {
+				summary.Commit = result.Reference
+			}
+		}
+	}
+
+	return summary
+}
+
+// writeSummary writes the JSON summary of a run to stdout
+func writeSummary(summary any) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(summary)
+}
@@ -3,54 +3,89 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
-	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"kube-git-backup/internal/collector"
 	"kube-git-backup/internal/config"
-	"kube-git-backup/internal/git"
+	"kube-git-backup/internal/health"
+	"kube-git-backup/internal/leader"
+	"kube-git-backup/internal/logging"
+	"kube-git-backup/internal/metrics"
 	"kube-git-backup/internal/sanitizer"
+	"kube-git-backup/internal/schedule"
+	"kube-git-backup/internal/sink"
 )
 
 func main() {
-	log.Println("Starting Kube Git Backup daemon...")
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		if err := runRestore(os.Args[2:]); err != nil {
+			fatal("Restore failed", err)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "backup" {
+		if err := runBackupCommand(os.Args[2:]); err != nil {
+			fatal("Backup failed", err)
+		}
+		return
+	}
 
 	// Load configuration from environment variables
 	cfg, err := config.Load()
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		fatal("Failed to load configuration", err)
 	}
 
 	// Validate configuration
 	if err := cfg.Validate(); err != nil {
-		log.Fatalf("Invalid configuration: %v", err)
+		fatal("Invalid configuration", err)
 	}
 
-	log.Printf("Configuration loaded: interval=%s, dump-only=%v", 
-		cfg.BackupInterval, cfg.DumpOnly)
-	
-	if !cfg.DumpOnly {
-		log.Printf("Git repository: %s, branch: %s, auth-method: %s", 
-			cfg.Git.Repository, cfg.Git.Branch, cfg.Git.AuthMethod)
-	}	// Initialize Kubernetes client
-	kubeCollector, err := collector.NewKubernetesCollector(cfg)
+	if err := logging.Setup(os.Stderr, cfg.LogFormat, cfg.LogLevel); err != nil {
+		fatal("Failed to set up logging", err)
+	}
+	slog.Info("Starting Kube Git Backup daemon")
+
+	// Backups run on BACKUP_SCHEDULE, or every BACKUP_INTERVAL without one
+	scheduler, err := schedule.NewScheduler(cfg)
 	if err != nil {
-		log.Fatalf("Failed to initialize Kubernetes collector: %v", err)
+		fatal("Invalid backup schedule", err)
 	}
 
-	// Initialize Git manager (skip if dump-only mode)
-	var gitManager *git.Manager
-	if !cfg.DumpOnly {
-		var err error
-		gitManager, err = git.NewManager(cfg.Git)
+	clusterNames := []string{cfg.ClusterName}
+	if len(cfg.Targets) > 0 {
+		clusterNames = clusterNames[:0]
+		for _, target := range cfg.Targets {
+			clusterNames = append(clusterNames, target.Name)
+		}
+	}
+	slog.Info("Configuration loaded", "clusters", strings.Join(clusterNames, ","), "schedule", scheduler.String(),
+		"sinks", strings.Join(cfg.Sinks, ","))
+	
+	if cfg.HasSink(config.SinkGit) {
+		slog.Info("Using Git repository", "repository", cfg.Git.Repository, "branch", cfg.Git.Branch,
+			"auth_method", cfg.Git.AuthMethod)
+	}
+
+	// Initialize a Kubernetes client per cluster
+	var clusters []cluster
+	for _, clusterCfg := range cfg.Clusters() {
+		kubeCollector, err := collector.NewKubernetesCollector(clusterCfg)
 		if err != nil {
-			log.Fatalf("Failed to initialize Git manager: %v", err)
+			fatal("Failed to initialize Kubernetes collector",
+				fmt.Errorf("cluster %s: %w", clusterCfg.ClusterName, err))
 		}
+		clusters = append(clusters, cluster{cfg: clusterCfg, collector: kubeCollector})
 	}
 
 	// Initialize YAML sanitizer
@@ -60,107 +95,427 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// Liveness fails when the backup loop of a cluster hasn't finished a run
+	// within the threshold, so a wedged loop gets the pod restarted
+	healthChecker := health.NewChecker(cfg.LivenessThreshold(scheduler.Period()), clusterNames)
+
+	// Start the metrics and health server
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/healthz", healthChecker.LivenessHandler())
+	mux.Handle("/readyz", healthChecker.ReadinessHandler())
+	server := &http.Server{Addr: cfg.HTTPAddr, Handler: mux}
+	go func() {
+		slog.Info("Serving metrics and health probes", "addr", cfg.HTTPAddr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("HTTP server failed", "error", err)
+		}
+	}()
+
 	// Setup signal handling for graceful shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
-	// Start backup loop in a goroutine
-	go func() {
-		ticker := time.NewTicker(cfg.BackupInterval)
-		defer ticker.Stop()
-
-		// Run initial backup
-		if err := runBackup(ctx, kubeCollector, yamlSanitizer, gitManager, cfg); err != nil {
-			log.Printf("Initial backup failed: %v", err)
+	// Start backup loop in a goroutine. With leader election only the leader
+	// runs it, so replicas never push competing commits.
+	if cfg.LeaderElection.Enabled {
+		kubeConfig, err := collector.NewRESTConfig(cfg.Kubernetes)
+		if err != nil {
+			fatal("Failed to initialize leader election", err)
 		}
 
-		for {
-			select {
-			case <-ctx.Done():
-				log.Println("Backup loop stopped")
-				return
-			case <-ticker.C:
-				if err := runBackup(ctx, kubeCollector, yamlSanitizer, gitManager, cfg); err != nil {
-					log.Printf("Backup failed: %v", err)
-				}
+		healthChecker.SetStandby(true)
+		go func() {
+			err := leader.Run(ctx, cfg.LeaderElection, kubeConfig,
+				func(leaderCtx context.Context) {
+					slog.Info("Acquired lease, starting backups",
+						"lease", cfg.LeaderElection.Namespace+"/"+cfg.LeaderElection.LeaseName)
+					healthChecker.SetStandby(false)
+					runBackupLoops(leaderCtx, clusters, yamlSanitizer, scheduler, healthChecker)
+				},
+				func() {
+					// Exit instead of campaigning again so that a backup still
+					// running from the lost term can't overlap with a new one
+					if ctx.Err() == nil {
+						slog.Error("Lost lease, exiting",
+							"lease", cfg.LeaderElection.Namespace+"/"+cfg.LeaderElection.LeaseName)
+						os.Exit(1)
+					}
+				})
+			if err != nil {
+				fatal("Leader election failed", err)
 			}
-		}
-	}()
+		}()
+	} else {
+		go runBackupLoops(ctx, clusters, yamlSanitizer, scheduler, healthChecker)
+	}
 
 	// Wait for shutdown signal
 	<-sigChan
-	log.Println("Received shutdown signal, stopping daemon...")
+	slog.Info("Received shutdown signal, stopping daemon")
 	cancel()
 
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Failed to stop HTTP server", "error", err)
+	}
+
 	// Give some time for graceful shutdown
 	time.Sleep(5 * time.Second)
-	log.Println("Kube Git Backup daemon stopped")
+	slog.Info("Kube Git Backup daemon stopped")
+}
+
+// fatal logs an error and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// cluster is a cluster backed up by the daemon, with its own configuration
+// and Kubernetes client
+type cluster struct {
+	cfg       *config.Config
+	collector *collector.KubernetesCollector
+}
+
+// runBackupLoops runs the backup loop of every cluster concurrently until
+// ctx is done. The loops share the schedule but fail independently.
+func runBackupLoops(ctx context.Context, clusters []cluster, yamlSanitizer *sanitizer.YAMLSanitizer,
+	scheduler *schedule.Scheduler, healthChecker *health.Checker) {
+	var wg sync.WaitGroup
+	for _, c := range clusters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			clusterCtx := logging.NewContext(ctx, logging.FromContext(ctx).With("cluster", c.cfg.ClusterName))
+			runBackupLoop(clusterCtx, c.collector, yamlSanitizer, scheduler, healthChecker, c.cfg)
+		}()
+	}
+	wg.Wait()
 }
 
-func runBackup(ctx context.Context, collector *collector.KubernetesCollector, 
-	sanitizer *sanitizer.YAMLSanitizer, gitManager *git.Manager, cfg *config.Config) error {
+// runBackupLoop owns the Git working copy and runs a backup immediately and
+// then on every scheduled run until ctx is done. In watch mode the changes
+// reported by the informers are backed up in between, with the periodic
+// full backup acting as a reconciliation pass.
+func runBackupLoop(ctx context.Context, kubeCollector *collector.KubernetesCollector,
+	yamlSanitizer *sanitizer.YAMLSanitizer, scheduler *schedule.Scheduler, healthChecker *health.Checker,
+	cfg *config.Config) {
+	logger := logging.FromContext(ctx)
+
+	// The output sinks own the Git working copy. They are initialized before
+	// every run until that succeeds, so that e.g. a failed clone fails the
+	// runs of this cluster until the remote is back, while the other
+	// clusters keep being backed up.
+	var sinks []sink.Sink
+	sinksReady := false
+	defer func() { closeSinks(sinks) }()
+
+	fullBackup := func(msg string) {
+		if !sinksReady {
+			var err error
+			if sinks, err = newSinks(cfg); err != nil {
+				metrics.BackupRuns.WithLabelValues(cfg.ClusterName, "failure").Inc()
+				logger.Error(msg, "error", fmt.Errorf("failed to initialize sinks: %w", err))
+				healthChecker.RecordFailure(cfg.ClusterName)
+				return
+			}
+			sinksReady = true
+		}
+
+		if stats, err := runBackup(ctx, cfg, kubeCollector, yamlSanitizer, sinks); err != nil {
+			logger.Error(msg, "run_id", stats.RunID, "error", err)
+			healthChecker.RecordFailure(cfg.ClusterName)
+		} else {
+			healthChecker.RecordSuccess(cfg.ClusterName)
+		}
+	}
+
+	// Run initial backup
+	fullBackup("Initial backup failed")
+
+	// Batches stays nil outside of watch mode, so it never becomes ready
+	var batches chan []collector.Change
+	if cfg.WatchMode {
+		batches = make(chan []collector.Change)
+		go func() {
+			if err := kubeCollector.Watch(ctx, cfg.WatchDebounce, batches); err != nil {
+				logger.Error("Watch failed, continuing with periodic backups only", "error", err)
+			}
+		}()
+	}
+
+	scheduled := time.Now()
+	for {
+		// Schedule the next run, skipping runs missed while the previous
+		// backup was still in progress
+		scheduled = scheduler.Next(scheduled)
+		if now := time.Now(); scheduled.Before(now) {
+			scheduled = scheduler.Next(now)
+		}
+		runAt := scheduled.Add(scheduler.Jitter())
+		metrics.NextRunTimestamp.WithLabelValues(cfg.ClusterName).Set(float64(runAt.Unix()))
+		logger.Info("Next backup scheduled", "at", runAt.Format(time.RFC3339))
+
+		timer := time.NewTimer(time.Until(runAt))
+		due := waitForScheduledRun(ctx, timer, batches, func(changes []collector.Change) {
+			if !sinksReady {
+				logger.Warn("Sinks are not initialized, leaving the changes to the next full backup",
+					"count", len(changes))
+				return
+			}
+			runCtx, _ := logging.WithRunID(ctx)
+			if err := runIncrementalBackup(runCtx, cfg, changes, yamlSanitizer, sinks); err != nil {
+				logging.FromContext(runCtx).Error("Incremental backup failed", "error", err)
+				healthChecker.RecordFailure(cfg.ClusterName)
+			} else {
+				healthChecker.RecordSuccess(cfg.ClusterName)
+			}
+		})
+		timer.Stop()
+		if !due {
+			logger.Info("Backup loop stopped")
+			return
+		}
+
+		fullBackup("Backup failed")
+	}
+}
+
+// waitForScheduledRun waits for timer while handing watched changes to
+// onChanges. It returns false if ctx is done first.
+func waitForScheduledRun(ctx context.Context, timer *time.Timer, batches <-chan []collector.Change,
+	onChanges func([]collector.Change)) bool {
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+			return true
+		case changes := <-batches:
+			onChanges(changes)
+		}
+	}
+}
+
+// backupStats describes what a backup run collected and changed
+type backupStats struct {
+	RunID            string
+	Resources        int
+	ResourcesByKind  map[string]int
+	CollectionErrors []collector.TypeError
+	InvalidResources []sanitizer.InvalidResource // Left out because of an invalid manifest or a path collision
+	Results          []sinkResult // Per sink, in the order of SINKS
+}
+
+// runBackup runs a backup of a cluster under a new run ID and records its result
+func runBackup(ctx context.Context, cfg *config.Config, collector *collector.KubernetesCollector,
+	sanitizer *sanitizer.YAMLSanitizer, sinks []sink.Sink) (*backupStats, error) {
+	ctx, runID := logging.WithRunID(ctx)
+	stats, err := backup(ctx, cfg, collector, sanitizer, sinks)
+	stats.RunID = runID
+	if err != nil {
+		metrics.BackupRuns.WithLabelValues(cfg.ClusterName, "failure").Inc()
+		return stats, err
+	}
+
+	metrics.BackupRuns.WithLabelValues(cfg.ClusterName, "success").Inc()
+	metrics.LastSuccessTimestamp.WithLabelValues(cfg.ClusterName).SetToCurrentTime()
+	return stats, nil
+}
+
+// backup runs a single backup and records the duration of each phase
+func backup(ctx context.Context, cfg *config.Config, kubeCollector *collector.KubernetesCollector,
+	yamlSanitizer *sanitizer.YAMLSanitizer, sinks []sink.Sink) (*backupStats, error) {
+	stats := &backupStats{ResourcesByKind: make(map[string]int)}
+	logger := logging.FromContext(ctx)
 	
-	log.Println("Starting backup process...")
+	logger.Info("Starting backup")
 	
-	// Collect resources from Kubernetes
-	resources, err := collector.CollectResources(ctx)
+	// Collect resources from Kubernetes, sanitizing every list page as it
+	// arrives so that the API objects of the whole cluster are never held in
+	// memory at once. The collectors' workers sanitize concurrently, so the
+	// sanitize phase is their total time spent sanitizing.
+	var sanitizeNanos atomic.Int64
+	var invalidMu sync.Mutex
+	collectStart := time.Now()
+	sanitizedResources, failed, err := collector.CollectPages(ctx, kubeCollector,
+		func(page []collector.Resource) ([]sanitizer.SanitizedResource, error) {
+			sanitizeStart := time.Now()
+			defer func() { sanitizeNanos.Add(int64(time.Since(sanitizeStart))) }()
+
+			sanitized, invalid, err := yamlSanitizer.SanitizeResources(ctx, page)
+			if err != nil {
+				return nil, fmt.Errorf("failed to sanitize resources: %w", err)
+			}
+			if len(invalid) > 0 {
+				invalidMu.Lock()
+				stats.InvalidResources = append(stats.InvalidResources, invalid...)
+				invalidMu.Unlock()
+			}
+			return sanitized, nil
+		})
 	if err != nil {
-		return fmt.Errorf("failed to collect resources: %w", err)
+		return stats, fmt.Errorf("failed to collect resources: %w", err)
 	}
+	collectDuration := time.Since(collectStart)
+	metrics.ObservePhase(cfg.ClusterName, metrics.PhaseCollect, collectStart)
+	metrics.PhaseDuration.WithLabelValues(cfg.ClusterName, metrics.PhaseSanitize).Observe(time.Duration(sanitizeNanos.Load()).Seconds())
+	stats.CollectionErrors = failed
+	countInvalidResources(cfg.ClusterName, stats.InvalidResources)
 
-	log.Printf("Collected %d resources", len(resources))
-
-	// Sanitize YAML content
-	sanitizedResources, err := sanitizer.SanitizeResources(resources)
+	sanitizedResources, collisions, err := skipCollisions(ctx, cfg, sanitizedResources)
 	if err != nil {
-		return fmt.Errorf("failed to sanitize resources: %w", err)
+		return stats, err
 	}
+	stats.InvalidResources = append(stats.InvalidResources, collisions...)
+	sortInvalidResources(stats.InvalidResources)
 
-	if cfg.DumpOnly {
-		// Dump only mode - save to local directory
-		if err := dumpResourcesLocally(sanitizedResources, cfg.WorkDir); err != nil {
-			return fmt.Errorf("failed to dump resources locally: %w", err)
-		}
-		log.Printf("Resources dumped to local directory: %s", cfg.WorkDir)
-	} else {
-		// Normal mode - backup to Git repository
-		if err := gitManager.BackupResources(ctx, sanitizedResources); err != nil {
-			return fmt.Errorf("failed to backup resources to Git: %w", err)
+	logger.Info("Collected resources from cluster", "count", len(sanitizedResources),
+		"failed_types", len(stats.CollectionErrors))
+
+	stats.Resources = len(sanitizedResources)
+	for _, resource := range sanitizedResources {
+		stats.ResourcesByKind[resource.Kind]++
+	}
+
+	// A partial snapshot must not look like the failed types were deleted, so
+	// either nothing is written or the sinks keep their last-known files
+	if len(failed) > 0 && cfg.ErrorPolicy == config.ErrorPolicyAbort {
+		return stats, fmt.Errorf("failed to collect %s, not writing a partial snapshot",
+			strings.Join(failedResourceTypes(failed), ", "))
+	}
+	if len(stats.InvalidResources) > 0 && cfg.ErrorPolicy == config.ErrorPolicyAbort {
+		return stats, fmt.Errorf("%d resources have an invalid manifest or path, not writing a partial snapshot",
+			len(stats.InvalidResources))
+	}
+	metrics.SetResourceCounts(cfg.ClusterName, stats.ResourcesByKind)
+
+	// Record how the snapshot was collected in the sinks' manifests
+	collection := sink.Collection{
+		RunID:           logging.RunID(ctx),
+		StartedAt:       collectStart.UTC(),
+		DurationSeconds: collectDuration.Seconds(),
+	}
+	for _, typeErr := range failed {
+		if collection.Errors == nil {
+			collection.Errors = make(map[string]string)
 		}
-		log.Println("Resources backed up to Git repository")
+		collection.Errors[typeErr.Resource] = typeErr.Err.Error()
+		collection.Kept = append(collection.Kept, sink.KeptKind{Group: typeErr.Group, Kind: typeErr.Kind})
 	}
+	if len(failed) > 0 {
+		logger.Warn("Keeping the last-known files of the failed resource types",
+			"resources", failedResourceTypes(failed))
+	}
+	for _, invalid := range stats.InvalidResources {
+		collection.KeptResources = append(collection.KeptResources, sink.KeptResource{
+			APIVersion: invalid.APIVersion,
+			Kind:       invalid.Kind,
+			Namespace:  invalid.Namespace,
+			Name:       invalid.Name,
+		})
+	}
+	ctx = sink.WithCollection(ctx, collection)
 
-	log.Println("Backup process completed successfully")
-	return nil
+	// Write the snapshot to every sink
+	results, err := writeSnapshot(ctx, cfg, sinks, sanitizedResources)
+	stats.Results = results
+	if err != nil {
+		return stats, err
+	}
+
+	logger.Info("Backup completed")
+	return stats, nil
 }
 
-// dumpResourcesLocally saves sanitized resources to local directory structure
-func dumpResourcesLocally(resources []sanitizer.SanitizedResource, workDir string) error {
-	// Create directory structure: namespace/kind/name.yaml
-	for _, resource := range resources {
-		var resourcePath string
-		
-		if resource.Namespace == "" {
-			// Cluster-scoped resource
-			resourcePath = filepath.Join(workDir, "cluster-scoped", 
-				strings.ToLower(resource.Kind), fmt.Sprintf("%s.yaml", resource.Name))
-		} else {
-			// Namespaced resource
-			resourcePath = filepath.Join(workDir, "namespaces", resource.Namespace,
-				strings.ToLower(resource.Kind), fmt.Sprintf("%s.yaml", resource.Name))
-		}
+// failedResourceTypes returns the resource types of failed
+func failedResourceTypes(failed []collector.TypeError) []string {
+	resourceTypes := make([]string, 0, len(failed))
+	for _, typeErr := range failed {
+		resourceTypes = append(resourceTypes, typeErr.Resource)
+	}
+	return resourceTypes
+}
+
+// countInvalidResources counts the resources of cluster left out because of an invalid manifest
+func countInvalidResources(cluster string, invalid []sanitizer.InvalidResource) {
+	for _, resource := range invalid {
+		metrics.InvalidResources.WithLabelValues(cluster, resource.Kind).Inc()
+	}
+}
+
+// skipCollisions leaves out the resources whose path is taken by an earlier
+// resource, logging and counting them, so that the sinks never fail on them
+func skipCollisions(ctx context.Context, cfg *config.Config,
+	resources []sanitizer.SanitizedResource) ([]sanitizer.SanitizedResource, []sanitizer.InvalidResource, error) {
+	layout, err := sink.LayoutFromConfig(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kept, collisions, err := layout.SkipCollisions(resources)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, collision := range collisions {
+		logging.FromContext(ctx).Warn("Skipping resource with a colliding path", "apiVersion", collision.APIVersion,
+			"kind", collision.Kind, "namespace", collision.Namespace, "name", collision.Name, "error", collision.Err)
+		metrics.PathCollisions.WithLabelValues(cfg.ClusterName, collision.Kind).Inc()
+	}
+	return kept, collisions, nil
+}
 
-		// Create directory if it doesn't exist
-		dir := filepath.Dir(resourcePath)
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			return fmt.Errorf("failed to create directory %s: %w", dir, err)
+// sortInvalidResources sorts resources reported by concurrent workers by kind,
+// namespace and name
+func sortInvalidResources(invalid []sanitizer.InvalidResource) {
+	sort.Slice(invalid, func(i, j int) bool {
+		a, b := invalid[i], invalid[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
 		}
+		return a.Name < b.Name
+	})
+}
+
+// runIncrementalBackup backs up only the resources changed since the last
+// batch. Resources with an invalid manifest or a colliding path are left out,
+// so their last-known files stay as they are.
+func runIncrementalBackup(ctx context.Context, cfg *config.Config, changes []collector.Change,
+	yamlSanitizer *sanitizer.YAMLSanitizer, sinks []sink.Sink) error {
+	logging.FromContext(ctx).Info("Backing up changed resources", "count", len(changes))
 
-		// Write YAML content
-		if err := os.WriteFile(resourcePath, resource.YAML, 0644); err != nil {
-			return fmt.Errorf("failed to write file %s: %w", resourcePath, err)
+	var updatedResources []collector.Resource
+	var deleted []sanitizer.SanitizedResource
+	for _, change := range changes {
+		if change.Deleted {
+			deleted = append(deleted, sanitizer.SanitizedResource{
+				APIVersion: change.Resource.APIVersion,
+				Kind:       change.Resource.Kind,
+				Namespace:  change.Resource.Namespace,
+				Name:       change.Resource.Name,
+			})
+			continue
 		}
+		updatedResources = append(updatedResources, change.Resource)
+	}
+
+	sanitizeStart := time.Now()
+	updated, invalid, err := yamlSanitizer.SanitizeResources(ctx, updatedResources)
+	if err != nil {
+		return fmt.Errorf("failed to sanitize resources: %w", err)
 	}
+	countInvalidResources(cfg.ClusterName, invalid)
+	metrics.ObservePhase(cfg.ClusterName, metrics.PhaseSanitize, sanitizeStart)
 
-	return nil
-}
\ No newline at end of file
+	updated, _, err = skipCollisions(ctx, cfg, updated)
+	if err != nil {
+		return err
+	}
+
+	return writeChanges(ctx, cfg, sinks, updated, deleted)
+}
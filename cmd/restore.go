@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"kube-git-backup/internal/collector"
+	"kube-git-backup/internal/config"
+	"kube-git-backup/internal/git"
+	"kube-git-backup/internal/logging"
+	"kube-git-backup/internal/restore"
+)
+
+// runRestore implements the restore subcommand, which applies a backup
+// snapshot from a Git revision or a local directory back to the cluster
+func runRestore(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s restore (--revision <commit|tag|branch> | --dir <path>) [options]\n\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+
+	revision := flags.String("revision", "", "Git commit, tag or branch of the backup repository to restore")
+	dir := flags.String("dir", "", "Local backup directory to restore (e.g. a DUMP_ONLY output)")
+	namespaces := flags.String("namespace", "", "Only restore these namespaces (comma-separated)")
+	kinds := flags.String("kind", "", "Only restore these kinds (comma-separated, e.g. Deployment,ConfigMap)")
+	dryRun := flags.String("dry-run", "none", "Must be \"none\" or \"server\"")
+	namespaceMap := flags.String("namespace-map", "", "Remap namespaces (comma-separated old=new pairs)")
+	clusterName := flags.String("cluster", "", "Cluster of CLUSTERS_FILE to restore, required when it is set")
+	flags.Parse(args)
+
+	if (*revision == "") == (*dir == "") {
+		flags.Usage()
+		return fmt.Errorf("exactly one of --revision or --dir is required")
+	}
+
+	if *dryRun != "none" && *dryRun != "server" {
+		return fmt.Errorf("--dry-run must be \"none\" or \"server\"")
+	}
+
+	opts := restore.Options{
+		Namespaces: splitList(*namespaces),
+		Kinds:      splitList(*kinds),
+		DryRun:     *dryRun == "server",
+	}
+
+	mapping, err := parseNamespaceMap(*namespaceMap)
+	if err != nil {
+		return err
+	}
+	opts.NamespaceMap = mapping
+
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	if err := logging.Setup(os.Stderr, cfg.LogFormat, cfg.LogLevel); err != nil {
+		return fmt.Errorf("failed to set up logging: %w", err)
+	}
+
+	// With cluster targets, restore the backup of one of them to that cluster
+	if len(cfg.Targets) > 0 {
+		if *clusterName == "" {
+			return fmt.Errorf("--cluster is required when CLUSTERS_FILE is set")
+		}
+		cfg, err = cfg.Cluster(*clusterName)
+		if err != nil {
+			return err
+		}
+	} else if *clusterName != "" {
+		return fmt.Errorf("--cluster requires CLUSTERS_FILE")
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	// Read the snapshot
+	var files map[string][]byte
+	if *dir != "" {
+		files, err = restore.LoadDirectory(*dir)
+		if err != nil {
+			return fmt.Errorf("failed to read backup directory: %w", err)
+		}
+	} else {
+		if cfg.Git.Repository == "" {
+			return fmt.Errorf("GIT_REPOSITORY is required to restore from a revision")
+		}
+
+		files, err = git.ReadRevision(ctx, cfg, *revision)
+		if err != nil {
+			return err
+		}
+	}
+
+	objects, err := restore.PrepareObjects(files, opts)
+	if err != nil {
+		return err
+	}
+	slog.Info("Restoring objects", "count", len(objects))
+
+	kubeConfig, err := collector.NewRESTConfig(cfg.Kubernetes)
+	if err != nil {
+		return err
+	}
+
+	restorer, err := restore.NewRestorer(kubeConfig, opts)
+	if err != nil {
+		return err
+	}
+
+	result, err := restorer.Restore(ctx, objects)
+	slog.Info("Restore finished", "applied", result.Applied, "failed", result.Failed)
+	return err
+}
+
+// splitList splits a comma-separated flag value
+func splitList(s string) []string {
+	var result []string
+	for _, part := range strings.Split(s, ",") {
+		if trimmed := strings.TrimSpace(part); trimmed != "" {
+			result = append(result, trimmed)
+		}
+	}
+	return result
+}
+
+// parseNamespaceMap parses "old=new,old2=new2" into a map
+func parseNamespaceMap(s string) (map[string]string, error) {
+	mapping := make(map[string]string)
+	for _, pair := range splitList(s) {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid --namespace-map entry '%s', expected old=new", pair)
+		}
+		mapping[parts[0]] = parts[1]
+	}
+	return mapping, nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+
+	"kube-git-backup/internal/config"
+	"kube-git-backup/internal/git"
+	"kube-git-backup/internal/logging"
+	"kube-git-backup/internal/sanitizer"
+	"kube-git-backup/internal/sink"
+)
+
+// sinkResult is what a single sink persisted during a backup run
+type sinkResult struct {
+	Sink string
+	sink.Result
+}
+
+// branchLocks serializes the writes of the clusters backed up by the daemon
+// whose working copies track the same Git branch. Every write pulls the branch
+// first and builds on the commits of the others, resetting to the remote
+// branch if unpushed commits diverged from it. The other sinks write below a
+// directory of their own per cluster, so clusters on different branches or
+// without the git sink never wait for each other.
+var branchLocks sync.Map // Lock key to *sync.Mutex
+
+// sinkLock returns the lock serializing the writes of cfg's sinks
+func sinkLock(cfg *config.Config) *sync.Mutex {
+	key := "cluster:" + cfg.ClusterName
+	if slices.Contains(cfg.Sinks, config.SinkGit) {
+		key = "git:" + cfg.Git.Repository + "#" + cfg.Git.Branch
+	}
+	lock, _ := branchLocks.LoadOrStore(key, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
+
+// newSinks creates the output sinks enabled in SINKS, in order
+func newSinks(cfg *config.Config) ([]sink.Sink, error) {
+	var sinks []sink.Sink
+
+	layout, err := sink.LayoutFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range cfg.Sinks {
+		switch name {
+		case config.SinkGit:
+			gitManager, err := git.NewManager(cfg)
+			if err != nil {
+				closeSinks(sinks)
+				return nil, fmt.Errorf("failed to initialize Git manager: %w", err)
+			}
+			sinks = append(sinks, gitManager)
+		case config.SinkLocal:
+			sinks = append(sinks, sink.NewDirectory(cfg.LocalDir, layout))
+		case config.SinkS3:
+			s3, err := sink.NewS3(cfg.S3, cfg.ClusterName, layout)
+			if err != nil {
+				closeSinks(sinks)
+				return nil, fmt.Errorf("failed to initialize S3 sink: %w", err)
+			}
+			sinks = append(sinks, s3)
+		case config.SinkArchive:
+			archive, err := sink.NewArchive(cfg.Archive, cfg.ClusterName, layout)
+			if err != nil {
+				closeSinks(sinks)
+				return nil, fmt.Errorf("failed to initialize archive sink: %w", err)
+			}
+			sinks = append(sinks, archive)
+		case config.SinkOCI:
+			oci, err := sink.NewOCI(cfg.OCI, cfg.ClusterName, layout)
+			if err != nil {
+				closeSinks(sinks)
+				return nil, fmt.Errorf("failed to initialize OCI sink: %w", err)
+			}
+			sinks = append(sinks, oci)
+		default:
+			closeSinks(sinks)
+			return nil, fmt.Errorf("unknown sink '%s'", name)
+		}
+	}
+
+	return sinks, nil
+}
+
+// closeSinks closes every sink, logging failures
+func closeSinks(sinks []sink.Sink) {
+	for _, s := range sinks {
+		if err := s.Close(); err != nil {
+			slog.Warn("Failed to close sink", "sink", s.Name(), "error", err)
+		}
+	}
+}
+
+// writeSnapshot writes a snapshot to every sink and finalizes it. A failing
+// sink doesn't stop the others, so e.g. a local dump is still written when
+// the Git push fails.
+func writeSnapshot(ctx context.Context, cfg *config.Config, sinks []sink.Sink,
+	resources []sanitizer.SanitizedResource) ([]sinkResult, error) {
+	var results []sinkResult
+	var errs []error
+
+	lock := sinkLock(cfg)
+	lock.Lock()
+	defer lock.Unlock()
+
+	for _, s := range sinks {
+		sinkCtx := logging.NewContext(ctx, logging.FromContext(ctx).With("sink", s.Name()))
+
+		if err := s.WriteSnapshot(sinkCtx, resources); err != nil {
+			errs = append(errs, fmt.Errorf("failed to write snapshot to %s sink: %w", s.Name(), err))
+			continue
+		}
+
+		result, err := s.Finalize(sinkCtx)
+		results = append(results, sinkResult{Sink: s.Name(), Result: result})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to finalize %s sink: %w", s.Name(), err))
+		}
+	}
+
+	return results, errors.Join(errs...)
+}
+
+// writeChanges writes the updated and deleted resources to every sink that
+// supports incremental changes and finalizes them. The other sinks catch up
+// with the next full backup.
+func writeChanges(ctx context.Context, cfg *config.Config, sinks []sink.Sink,
+	updated, deleted []sanitizer.SanitizedResource) error {
+	var errs []error
+
+	lock := sinkLock(cfg)
+	lock.Lock()
+	defer lock.Unlock()
+
+	for _, s := range sinks {
+		sinkCtx := logging.NewContext(ctx, logging.FromContext(ctx).With("sink", s.Name()))
+
+		incremental, ok := s.(sink.IncrementalSink)
+		if !ok {
+			logging.FromContext(sinkCtx).Debug("Sink does not support incremental changes, skipping")
+			continue
+		}
+
+		if err := incremental.WriteChanges(sinkCtx, updated, deleted); err != nil {
+			errs = append(errs, fmt.Errorf("failed to write changes to %s sink: %w", s.Name(), err))
+			continue
+		}
+
+		if _, err := incremental.Finalize(sinkCtx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to finalize %s sink: %w", s.Name(), err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -3,9 +3,10 @@ package collector
 import (
 	"context"
 	"fmt"
-	"log"
+	"path/filepath"
 
 	"kube-git-backup/internal/config"
+	"kube-git-backup/internal/metrics"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -24,6 +25,25 @@ type Resource struct {
 	Object     runtime.Object
 }
 
+// TypeError reports a resource type that could not be collected, while the
+// other resource types were collected regardless
+type TypeError struct {
+	Resource string // As in INCLUDE_RESOURCES, e.g. "secrets" or "cert-manager.io/certificates"
+	Group    string
+	Kind     string
+	Err      error
+}
+
+// Error returns the resource type and the cause of its failure
+func (e TypeError) Error() string {
+	return fmt.Sprintf("failed to collect %s: %v", e.Resource, e.Err)
+}
+
+// Unwrap returns the cause of the failure
+func (e TypeError) Unwrap() error {
+	return e.Err
+}
+
 // KubernetesCollector collects resources from Kubernetes cluster
 type KubernetesCollector struct {
 	clientset     kubernetes.Interface
@@ -33,17 +53,9 @@ type KubernetesCollector struct {
 
 // NewKubernetesCollector creates a new KubernetesCollector
 func NewKubernetesCollector(cfg *config.Config) (*KubernetesCollector, error) {
-	// Try in-cluster config first, then fall back to kubeconfig
-	var kubeConfig *rest.Config
-	var err error
-
-	kubeConfig, err = rest.InClusterConfig()
+	kubeConfig, err := NewRESTConfig(cfg.Kubernetes)
 	if err != nil {
-		// Fall back to kubeconfig file
-		kubeConfig, err = clientcmd.BuildConfigFromFlags("", clientcmd.RecommendedHomeFile)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create Kubernetes config: %w", err)
-		}
+		return nil, err
 	}
 
 	clientset, err := kubernetes.NewForConfig(kubeConfig)
@@ -63,53 +75,133 @@ func NewKubernetesCollector(cfg *config.Config) (*KubernetesCollector, error) {
 	}, nil
 }
 
-// CollectResources collects all specified resources from the cluster
-func (kc *KubernetesCollector) CollectResources(ctx context.Context) ([]Resource, error) {
-	var resources []Resource
+// NewRESTConfig returns the client configuration for the target cluster.
+// Without a kubeconfig or context in cfg the in-cluster config is used when
+// available, otherwise the kubeconfig is loaded through the clientcmd loading
+// rules, i.e. the given files, $KUBECONFIG or ~/.kube/config. Impersonation
+// and rate limits of cfg apply either way.
+func NewRESTConfig(cfg config.KubernetesConfig) (*rest.Config, error) {
+	var kubeConfig *rest.Config
+	if cfg.Kubeconfig == "" && cfg.Context == "" {
+		if inCluster, err := rest.InClusterConfig(); err == nil {
+			kubeConfig = inCluster
+		}
+	}
 
-	// Define resource types to collect
-	resourceTypes := map[string]func(context.Context) ([]Resource, error){
-		"namespaces":             kc.collectNamespaces,
-		"deployments":            kc.collectDeployments,
-		"daemonsets":             kc.collectDaemonSets,
-		"statefulsets":           kc.collectStatefulSets,
-		"services":               kc.collectServices,
-		"configmaps":             kc.collectConfigMaps,
-		"secrets":                kc.collectSecrets,
-		"ingresses":              kc.collectIngresses,
-		"persistentvolumes":      kc.collectPersistentVolumes,
-		"persistentvolumeclaims": kc.collectPersistentVolumeClaims,
-		"storageclasses":         kc.collectStorageClasses,
-		"serviceaccounts":        kc.collectServiceAccounts,
-		"roles":                  kc.collectRoles,
-		"rolebindings":           kc.collectRoleBindings,
-		"clusterroles":           kc.collectClusterRoles,
-		"clusterrolebindings":    kc.collectClusterRoleBindings,
-		"networkpolicies":        kc.collectNetworkPolicies,
-	}
-
-	// Collect included resources
-	for resourceType, collectFunc := range resourceTypes {
-		if kc.shouldIncludeResource(resourceType) {
-			log.Printf("Collecting %s...", resourceType)
-			collected, err := collectFunc(ctx)
-			if err != nil {
-				log.Printf("Failed to collect %s: %v", resourceType, err)
-				continue
-			}
-			resources = append(resources, collected...)
-			log.Printf("Collected %d %s", len(collected), resourceType)
+	if kubeConfig == nil {
+		loaded, err := loadKubeconfig(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Kubernetes config: %w", err)
 		}
+		kubeConfig = loaded
+	}
+
+	if cfg.ImpersonateUser != "" {
+		kubeConfig.Impersonate = rest.ImpersonationConfig{
+			UserName: cfg.ImpersonateUser,
+			Groups:   cfg.ImpersonateGroups,
+		}
+	}
+	if cfg.QPS > 0 {
+		kubeConfig.QPS = cfg.QPS
+	}
+	if cfg.Burst > 0 {
+		kubeConfig.Burst = cfg.Burst
+	}
+
+	return kubeConfig, nil
+}
+
+// loadKubeconfig loads the context of cfg, or the current context, from the
+// kubeconfig files of cfg. A single file must exist, while missing files of
+// a list are skipped, as kubectl does.
+func loadKubeconfig(cfg config.KubernetesConfig) (*rest.Config, error) {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if files := filepath.SplitList(cfg.Kubeconfig); len(files) == 1 {
+		loadingRules.ExplicitPath = files[0]
+	} else if len(files) > 1 {
+		loadingRules.Precedence = files
+	}
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: cfg.Context}
+
+	kubeConfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides).ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	return kubeConfig, nil
+}
+
+// CollectResources collects all specified resources from the cluster. The
+// resource types that fail are skipped and returned as TypeErrors, in the
+// order they are collected in.
+func (kc *KubernetesCollector) CollectResources(ctx context.Context) ([]Resource, []TypeError, error) {
+	return CollectPages(ctx, kc, func(page []Resource) ([]Resource, error) {
+		return page, nil
+	})
+}
+
+// CollectPages collects the resources like CollectResources, but converts
+// every list page with convert as soon as it arrives, e.g. to sanitize it, so
+// that no more than a page of API objects per worker is held in memory. The
+// pages of different resource types and namespaces are converted
+// concurrently, while the results keep the order of the resource types. An
+// error of convert aborts the collection.
+func CollectPages[T any](ctx context.Context, kc *KubernetesCollector,
+	convert func([]Resource) ([]T, error)) ([]T, []TypeError, error) {
+	units, undiscovered, err := kc.collectionUnits(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, typeErr := range undiscovered {
+		metrics.CollectionErrors.WithLabelValues(kc.config.ClusterName, typeErr.Resource).Inc()
 	}
 
-	return resources, nil
+	collected, failed, err := collectConcurrently(ctx, kc, units, convert)
+	if err != nil {
+		return nil, nil, err
+	}
+	return collected, append(undiscovered, failed...), nil
 }
 
-// shouldIncludeResource checks if a resource type should be included
-func (kc *KubernetesCollector) shouldIncludeResource(resourceType string) bool {
+// builtinResource is a resource type collected through the typed clientset
+type builtinResource struct {
+	group      string
+	name       string
+	kind       string
+	namespaced bool
+	list       listFunc
+}
+
+// builtinResourceTypes returns the resource types collected outside of discovery mode
+func (kc *KubernetesCollector) builtinResourceTypes() []builtinResource {
+	return []builtinResource{
+		{"", "namespaces", "Namespace", false, kc.collectNamespaces},
+		{"apps", "deployments", "Deployment", true, kc.collectDeployments},
+		{"apps", "daemonsets", "DaemonSet", true, kc.collectDaemonSets},
+		{"apps", "statefulsets", "StatefulSet", true, kc.collectStatefulSets},
+		{"", "services", "Service", true, kc.collectServices},
+		{"", "configmaps", "ConfigMap", true, kc.collectConfigMaps},
+		{"", "secrets", "Secret", true, kc.collectSecrets},
+		{"networking.k8s.io", "ingresses", "Ingress", true, kc.collectIngresses},
+		{"", "persistentvolumes", "PersistentVolume", false, kc.collectPersistentVolumes},
+		{"", "persistentvolumeclaims", "PersistentVolumeClaim", true, kc.collectPersistentVolumeClaims},
+		{"storage.k8s.io", "storageclasses", "StorageClass", false, kc.collectStorageClasses},
+		{"", "serviceaccounts", "ServiceAccount", true, kc.collectServiceAccounts},
+		{"rbac.authorization.k8s.io", "roles", "Role", true, kc.collectRoles},
+		{"rbac.authorization.k8s.io", "rolebindings", "RoleBinding", true, kc.collectRoleBindings},
+		{"rbac.authorization.k8s.io", "clusterroles", "ClusterRole", false, kc.collectClusterRoles},
+		{"rbac.authorization.k8s.io", "clusterrolebindings", "ClusterRoleBinding", false, kc.collectClusterRoleBindings},
+		{"networking.k8s.io", "networkpolicies", "NetworkPolicy", true, kc.collectNetworkPolicies},
+	}
+}
+
+// shouldIncludeResource checks if a resource type should be included.
+// Filter entries match either the plural resource name ("deployments"),
+// the group-qualified name ("apps/deployments") or everything ("*").
+func (kc *KubernetesCollector) shouldIncludeResource(group, resource string) bool {
 	// Check exclude list first
 	for _, excluded := range kc.config.Kubernetes.ExcludeResources {
-		if excluded == resourceType {
+		if matchesResourceFilter(excluded, group, resource) {
 			return false
 		}
 	}
@@ -121,7 +213,7 @@ func (kc *KubernetesCollector) shouldIncludeResource(resourceType string) bool {
 
 	// Check include list
 	for _, included := range kc.config.Kubernetes.IncludeResources {
-		if included == resourceType {
+		if matchesResourceFilter(included, group, resource) {
 			return true
 		}
 	}
@@ -129,6 +221,15 @@ func (kc *KubernetesCollector) shouldIncludeResource(resourceType string) bool {
 	return false
 }
 
+// matchesResourceFilter checks a single INCLUDE_RESOURCES/EXCLUDE_RESOURCES
+// entry: a resource, a group/resource or all resources of a group as group/*
+func matchesResourceFilter(filter, group, resource string) bool {
+	if filter == "*" || filter == resource {
+		return true
+	}
+	return filter == group+"/"+resource || filter == group+"/*"
+}
+
 // shouldIncludeNamespace checks if a namespace should be included
 func (kc *KubernetesCollector) shouldIncludeNamespace(namespace string) bool {
 	// Check exclude list first (explicit exclusions)
@@ -153,12 +254,12 @@ func (kc *KubernetesCollector) shouldIncludeNamespace(namespace string) bool {
 }
 
 // Namespace collection
-func (kc *KubernetesCollector) collectNamespaces(ctx context.Context) ([]Resource, error) {
+func (kc *KubernetesCollector) collectNamespaces(ctx context.Context, namespace string, opts metav1.ListOptions) ([]Resource, string, error) {
 	var resources []Resource
 
-	namespaces, err := kc.clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	namespaces, err := kc.clientset.CoreV1().Namespaces().List(ctx, opts)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	for _, ns := range namespaces.Items {
@@ -173,16 +274,16 @@ func (kc *KubernetesCollector) collectNamespaces(ctx context.Context) ([]Resourc
 		}
 	}
 
-	return resources, nil
+	return resources, namespaces.Continue, nil
 }
 
 // Deployment collection
-func (kc *KubernetesCollector) collectDeployments(ctx context.Context) ([]Resource, error) {
+func (kc *KubernetesCollector) collectDeployments(ctx context.Context, namespace string, opts metav1.ListOptions) ([]Resource, string, error) {
 	var resources []Resource
 
-	deployments, err := kc.clientset.AppsV1().Deployments("").List(ctx, metav1.ListOptions{})
+	deployments, err := kc.clientset.AppsV1().Deployments(namespace).List(ctx, opts)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	for _, dep := range deployments.Items {
@@ -197,16 +298,16 @@ func (kc *KubernetesCollector) collectDeployments(ctx context.Context) ([]Resour
 		}
 	}
 
-	return resources, nil
+	return resources, deployments.Continue, nil
 }
 
 // DaemonSet collection
-func (kc *KubernetesCollector) collectDaemonSets(ctx context.Context) ([]Resource, error) {
+func (kc *KubernetesCollector) collectDaemonSets(ctx context.Context, namespace string, opts metav1.ListOptions) ([]Resource, string, error) {
 	var resources []Resource
 
-	daemonsets, err := kc.clientset.AppsV1().DaemonSets("").List(ctx, metav1.ListOptions{})
+	daemonsets, err := kc.clientset.AppsV1().DaemonSets(namespace).List(ctx, opts)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	
 	for _, ds := range daemonsets.Items {
@@ -221,16 +322,16 @@ func (kc *KubernetesCollector) collectDaemonSets(ctx context.Context) ([]Resourc
 		}
 	}
 
-	return resources, nil
+	return resources, daemonsets.Continue, nil
 }
 
 // StatefulSet collection
-func (kc *KubernetesCollector) collectStatefulSets(ctx context.Context) ([]Resource, error) {
+func (kc *KubernetesCollector) collectStatefulSets(ctx context.Context, namespace string, opts metav1.ListOptions) ([]Resource, string, error) {
 	var resources []Resource
 
-	statefulsets, err := kc.clientset.AppsV1().StatefulSets("").List(ctx, metav1.ListOptions{})
+	statefulsets, err := kc.clientset.AppsV1().StatefulSets(namespace).List(ctx, opts)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	
 	for _, sts := range statefulsets.Items {
@@ -245,16 +346,16 @@ func (kc *KubernetesCollector) collectStatefulSets(ctx context.Context) ([]Resou
 		}
 	}
 
-	return resources, nil
+	return resources, statefulsets.Continue, nil
 }
 
 // Service collection
-func (kc *KubernetesCollector) collectServices(ctx context.Context) ([]Resource, error) {
+func (kc *KubernetesCollector) collectServices(ctx context.Context, namespace string, opts metav1.ListOptions) ([]Resource, string, error) {
 	var resources []Resource
 
-	services, err := kc.clientset.CoreV1().Services("").List(ctx, metav1.ListOptions{})
+	services, err := kc.clientset.CoreV1().Services(namespace).List(ctx, opts)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	
 	for _, svc := range services.Items {
@@ -269,16 +370,16 @@ func (kc *KubernetesCollector) collectServices(ctx context.Context) ([]Resource,
 		}
 	}
 
-	return resources, nil
+	return resources, services.Continue, nil
 }
 
 // ConfigMap collection
-func (kc *KubernetesCollector) collectConfigMaps(ctx context.Context) ([]Resource, error) {
+func (kc *KubernetesCollector) collectConfigMaps(ctx context.Context, namespace string, opts metav1.ListOptions) ([]Resource, string, error) {
 	var resources []Resource
 
-	configmaps, err := kc.clientset.CoreV1().ConfigMaps("").List(ctx, metav1.ListOptions{})
+	configmaps, err := kc.clientset.CoreV1().ConfigMaps(namespace).List(ctx, opts)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	
 	for _, cm := range configmaps.Items {
@@ -293,16 +394,16 @@ func (kc *KubernetesCollector) collectConfigMaps(ctx context.Context) ([]Resourc
 		}
 	}
 
-	return resources, nil
+	return resources, configmaps.Continue, nil
 }
 
 // Secret collection
-func (kc *KubernetesCollector) collectSecrets(ctx context.Context) ([]Resource, error) {
+func (kc *KubernetesCollector) collectSecrets(ctx context.Context, namespace string, opts metav1.ListOptions) ([]Resource, string, error) {
 	var resources []Resource
 
-	secrets, err := kc.clientset.CoreV1().Secrets("").List(ctx, metav1.ListOptions{})
+	secrets, err := kc.clientset.CoreV1().Secrets(namespace).List(ctx, opts)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	
 	for _, secret := range secrets.Items {
@@ -323,16 +424,16 @@ func (kc *KubernetesCollector) collectSecrets(ctx context.Context) ([]Resource,
 		}
 	}
 
-	return resources, nil
+	return resources, secrets.Continue, nil
 }
 
 // Ingress collection
-func (kc *KubernetesCollector) collectIngresses(ctx context.Context) ([]Resource, error) {
+func (kc *KubernetesCollector) collectIngresses(ctx context.Context, namespace string, opts metav1.ListOptions) ([]Resource, string, error) {
 	var resources []Resource
 
-	ingresses, err := kc.clientset.NetworkingV1().Ingresses("").List(ctx, metav1.ListOptions{})
+	ingresses, err := kc.clientset.NetworkingV1().Ingresses(namespace).List(ctx, opts)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	
 	for _, ing := range ingresses.Items {
@@ -347,16 +448,16 @@ func (kc *KubernetesCollector) collectIngresses(ctx context.Context) ([]Resource
 		}
 	}
 
-	return resources, nil
+	return resources, ingresses.Continue, nil
 }
 
 // PersistentVolume collection
-func (kc *KubernetesCollector) collectPersistentVolumes(ctx context.Context) ([]Resource, error) {
+func (kc *KubernetesCollector) collectPersistentVolumes(ctx context.Context, namespace string, opts metav1.ListOptions) ([]Resource, string, error) {
 	var resources []Resource
 
-	pvs, err := kc.clientset.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	pvs, err := kc.clientset.CoreV1().PersistentVolumes().List(ctx, opts)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	for _, pv := range pvs.Items {
@@ -369,16 +470,16 @@ func (kc *KubernetesCollector) collectPersistentVolumes(ctx context.Context) ([]
 		})
 	}
 
-	return resources, nil
+	return resources, pvs.Continue, nil
 }
 
 // PersistentVolumeClaim collection
-func (kc *KubernetesCollector) collectPersistentVolumeClaims(ctx context.Context) ([]Resource, error) {
+func (kc *KubernetesCollector) collectPersistentVolumeClaims(ctx context.Context, namespace string, opts metav1.ListOptions) ([]Resource, string, error) {
 	var resources []Resource
 
-	pvcs, err := kc.clientset.CoreV1().PersistentVolumeClaims("").List(ctx, metav1.ListOptions{})
+	pvcs, err := kc.clientset.CoreV1().PersistentVolumeClaims(namespace).List(ctx, opts)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	
 	for _, pvc := range pvcs.Items {
@@ -393,16 +494,16 @@ func (kc *KubernetesCollector) collectPersistentVolumeClaims(ctx context.Context
 		}
 	}
 
-	return resources, nil
+	return resources, pvcs.Continue, nil
 }
 
 // StorageClass collection
-func (kc *KubernetesCollector) collectStorageClasses(ctx context.Context) ([]Resource, error) {
+func (kc *KubernetesCollector) collectStorageClasses(ctx context.Context, namespace string, opts metav1.ListOptions) ([]Resource, string, error) {
 	var resources []Resource
 
-	storageClasses, err := kc.clientset.StorageV1().StorageClasses().List(ctx, metav1.ListOptions{})
+	storageClasses, err := kc.clientset.StorageV1().StorageClasses().List(ctx, opts)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	for _, sc := range storageClasses.Items {
@@ -415,16 +516,16 @@ func (kc *KubernetesCollector) collectStorageClasses(ctx context.Context) ([]Res
 		})
 	}
 
-	return resources, nil
+	return resources, storageClasses.Continue, nil
 }
 
 // ServiceAccount collection
-func (kc *KubernetesCollector) collectServiceAccounts(ctx context.Context) ([]Resource, error) {
+func (kc *KubernetesCollector) collectServiceAccounts(ctx context.Context, namespace string, opts metav1.ListOptions) ([]Resource, string, error) {
 	var resources []Resource
 
-	serviceAccounts, err := kc.clientset.CoreV1().ServiceAccounts("").List(ctx, metav1.ListOptions{})
+	serviceAccounts, err := kc.clientset.CoreV1().ServiceAccounts(namespace).List(ctx, opts)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	
 	for _, sa := range serviceAccounts.Items {
@@ -439,16 +540,16 @@ func (kc *KubernetesCollector) collectServiceAccounts(ctx context.Context) ([]Re
 		}
 	}
 
-	return resources, nil
+	return resources, serviceAccounts.Continue, nil
 }
 
 // Role collection
-func (kc *KubernetesCollector) collectRoles(ctx context.Context) ([]Resource, error) {
+func (kc *KubernetesCollector) collectRoles(ctx context.Context, namespace string, opts metav1.ListOptions) ([]Resource, string, error) {
 	var resources []Resource
 
-	roles, err := kc.clientset.RbacV1().Roles("").List(ctx, metav1.ListOptions{})
+	roles, err := kc.clientset.RbacV1().Roles(namespace).List(ctx, opts)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	
 	for _, role := range roles.Items {
@@ -463,16 +564,16 @@ func (kc *KubernetesCollector) collectRoles(ctx context.Context) ([]Resource, er
 		}
 	}
 
-	return resources, nil
+	return resources, roles.Continue, nil
 }
 
 // RoleBinding collection
-func (kc *KubernetesCollector) collectRoleBindings(ctx context.Context) ([]Resource, error) {
+func (kc *KubernetesCollector) collectRoleBindings(ctx context.Context, namespace string, opts metav1.ListOptions) ([]Resource, string, error) {
 	var resources []Resource
 
-	roleBindings, err := kc.clientset.RbacV1().RoleBindings("").List(ctx, metav1.ListOptions{})
+	roleBindings, err := kc.clientset.RbacV1().RoleBindings(namespace).List(ctx, opts)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	
 	for _, rb := range roleBindings.Items {
@@ -487,16 +588,16 @@ func (kc *KubernetesCollector) collectRoleBindings(ctx context.Context) ([]Resou
 		}
 	}
 
-	return resources, nil
+	return resources, roleBindings.Continue, nil
 }
 
 // ClusterRole collection
-func (kc *KubernetesCollector) collectClusterRoles(ctx context.Context) ([]Resource, error) {
+func (kc *KubernetesCollector) collectClusterRoles(ctx context.Context, namespace string, opts metav1.ListOptions) ([]Resource, string, error) {
 	var resources []Resource
 
-	clusterRoles, err := kc.clientset.RbacV1().ClusterRoles().List(ctx, metav1.ListOptions{})
+	clusterRoles, err := kc.clientset.RbacV1().ClusterRoles().List(ctx, opts)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	for _, cr := range clusterRoles.Items {
@@ -512,16 +613,16 @@ func (kc *KubernetesCollector) collectClusterRoles(ctx context.Context) ([]Resou
 		}
 	}
 
-	return resources, nil
+	return resources, clusterRoles.Continue, nil
 }
 
 // ClusterRoleBinding collection
-func (kc *KubernetesCollector) collectClusterRoleBindings(ctx context.Context) ([]Resource, error) {
+func (kc *KubernetesCollector) collectClusterRoleBindings(ctx context.Context, namespace string, opts metav1.ListOptions) ([]Resource, string, error) {
 	var resources []Resource
 
-	clusterRoleBindings, err := kc.clientset.RbacV1().ClusterRoleBindings().List(ctx, metav1.ListOptions{})
+	clusterRoleBindings, err := kc.clientset.RbacV1().ClusterRoleBindings().List(ctx, opts)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	for _, crb := range clusterRoleBindings.Items {
@@ -537,16 +638,16 @@ func (kc *KubernetesCollector) collectClusterRoleBindings(ctx context.Context) (
 		}
 	}
 
-	return resources, nil
+	return resources, clusterRoleBindings.Continue, nil
 }
 
 // NetworkPolicy collection
-func (kc *KubernetesCollector) collectNetworkPolicies(ctx context.Context) ([]Resource, error) {
+func (kc *KubernetesCollector) collectNetworkPolicies(ctx context.Context, namespace string, opts metav1.ListOptions) ([]Resource, string, error) {
 	var resources []Resource
 
-	networkPolicies, err := kc.clientset.NetworkingV1().NetworkPolicies("").List(ctx, metav1.ListOptions{})
+	networkPolicies, err := kc.clientset.NetworkingV1().NetworkPolicies(namespace).List(ctx, opts)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	
 	for _, np := range networkPolicies.Items {
@@ -561,7 +662,7 @@ func (kc *KubernetesCollector) collectNetworkPolicies(ctx context.Context) ([]Re
 		}
 	}
 
-	return resources, nil
+	return resources, networkPolicies.Continue, nil
 }
 
 // Helper functions
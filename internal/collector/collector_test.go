@@ -0,0 +1,175 @@
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"kube-git-backup/internal/config"
+)
+
+func TestShouldIncludeResource(t *testing.T) {
+	tests := []struct {
+		name     string
+		include  []string
+		exclude  []string
+		group    string
+		resource string
+		expected bool
+	}{
+		{
+			name:     "plural name matches core resource",
+			include:  []string{"configmaps"},
+			resource: "configmaps",
+			expected: true,
+		},
+		{
+			name:     "plural name matches grouped resource",
+			include:  []string{"certificates"},
+			group:    "cert-manager.io",
+			resource: "certificates",
+			expected: true,
+		},
+		{
+			name:     "group/resource matches only that group",
+			include:  []string{"cert-manager.io/certificates"},
+			group:    "example.com",
+			resource: "certificates",
+			expected: false,
+		},
+		{
+			name:     "wildcard includes everything",
+			include:  []string{"*"},
+			group:    "argoproj.io",
+			resource: "applications",
+			expected: true,
+		},
+		{
+			name:     "exclude takes precedence over wildcard",
+			include:  []string{"*"},
+			exclude:  []string{"events"},
+			group:    "events.k8s.io",
+			resource: "events",
+			expected: false,
+		},
+		{
+			name:     "group/resource exclude",
+			include:  []string{"*"},
+			exclude:  []string{"monitoring.coreos.com/prometheusrules"},
+			group:    "monitoring.coreos.com",
+			resource: "prometheusrules",
+			expected: false,
+		},
+		{
+			name:     "not in include list",
+			include:  []string{"deployments"},
+			group:    "apps",
+			resource: "statefulsets",
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kc := &KubernetesCollector{
+				config: &config.Config{
+					Kubernetes: config.KubernetesConfig{
+						IncludeResources: tt.include,
+						ExcludeResources: tt.exclude,
+					},
+				},
+			}
+
+			if got := kc.shouldIncludeResource(tt.group, tt.resource); got != tt.expected {
+				t.Errorf("Expected %v for %s/%s, got %v", tt.expected, tt.group, tt.resource, got)
+			}
+		})
+	}
+}
+
+func TestNewRESTConfigContext(t *testing.T) {
+	kubeconfig := writeKubeconfig(t)
+
+	restConfig, err := NewRESTConfig(config.KubernetesConfig{Kubeconfig: kubeconfig, Context: "production"})
+	if err != nil {
+		t.Fatalf("Failed to load kubeconfig: %v", err)
+	}
+	if restConfig.Host != "https://production.example.com:6443" {
+		t.Errorf("Expected the server of the production context, got %s", restConfig.Host)
+	}
+
+	restConfig, err = NewRESTConfig(config.KubernetesConfig{Kubeconfig: kubeconfig})
+	if err != nil {
+		t.Fatalf("Failed to load kubeconfig: %v", err)
+	}
+	if restConfig.Host != "https://staging.example.com:6443" {
+		t.Errorf("Expected the server of the current context, got %s", restConfig.Host)
+	}
+
+	if _, err := NewRESTConfig(config.KubernetesConfig{Kubeconfig: kubeconfig, Context: "development"}); err == nil {
+		t.Error("Expected an error for an unknown context")
+	}
+}
+
+func TestNewRESTConfigOptions(t *testing.T) {
+	kubeconfig := writeKubeconfig(t)
+
+	// Missing files of a list are skipped, a single missing file is an error
+	missing := filepath.Join(t.TempDir(), "missing")
+	restConfig, err := NewRESTConfig(config.KubernetesConfig{
+		Kubeconfig:        missing + string(filepath.ListSeparator) + kubeconfig,
+		ImpersonateUser:   "backup-reader",
+		ImpersonateGroups: []string{"auditors"},
+		QPS:               50,
+		Burst:             100,
+	})
+	if err != nil {
+		t.Fatalf("Failed to load kubeconfig: %v", err)
+	}
+	if restConfig.Host != "https://staging.example.com:6443" {
+		t.Errorf("Expected the server of the current context, got %s", restConfig.Host)
+	}
+	if restConfig.Impersonate.UserName != "backup-reader" || len(restConfig.Impersonate.Groups) != 1 ||
+		restConfig.Impersonate.Groups[0] != "auditors" {
+		t.Errorf("Unexpected impersonation: %+v", restConfig.Impersonate)
+	}
+	if restConfig.QPS != 50 || restConfig.Burst != 100 {
+		t.Errorf("Expected QPS 50 and burst 100, got %v and %d", restConfig.QPS, restConfig.Burst)
+	}
+
+	if _, err := NewRESTConfig(config.KubernetesConfig{Kubeconfig: missing}); err == nil {
+		t.Error("Expected an error for a missing kubeconfig")
+	}
+}
+
+// writeKubeconfig writes a kubeconfig with a production and a staging
+// context, the current one
+func writeKubeconfig(t *testing.T) string {
+	t.Helper()
+
+	kubeconfig := filepath.Join(t.TempDir(), "config")
+	content := `apiVersion: v1
+kind: Config
+current-context: staging
+clusters:
+- name: production
+  cluster:
+    server: https://production.example.com:6443
+- name: staging
+  cluster:
+    server: https://staging.example.com:6443
+users:
+- name: admin
+  user:
+    token: secret
+contexts:
+- name: production
+  context: {cluster: production, user: admin}
+- name: staging
+  context: {cluster: staging, user: admin}
+`
+	if err := os.WriteFile(kubeconfig, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return kubeconfig
+}
@@ -0,0 +1,197 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"kube-git-backup/internal/logging"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+)
+
+// discoveredResource is a listable resource type advertised by the API server
+type discoveredResource struct {
+	gvr         schema.GroupVersionResource
+	apiResource metav1.APIResource
+}
+
+// String returns the resource type as used in logs and metrics
+func (dr discoveredResource) String() string {
+	if dr.gvr.Group == "" {
+		return dr.gvr.Resource
+	}
+	return dr.gvr.Group + "/" + dr.gvr.Resource
+}
+
+// discoverResourceTypes returns the included resource types that support all
+// of the given verbs, at their preferred version. API groups that failed to be
+// discovered are returned as TypeErrors without a kind, as long as they may
+// contain included types, so that their files are kept like those of any
+// other failed type instead of looking deleted.
+func (kc *KubernetesCollector) discoverResourceTypes(ctx context.Context, verbs ...string) ([]discoveredResource, []TypeError, error) {
+	var resourceTypes []discoveredResource
+	var failed []TypeError
+
+	apiResourceLists, err := discovery.ServerPreferredResources(kc.clientset.Discovery())
+	if err != nil {
+		// Discovery returns partial results when some API groups are unavailable
+		// (e.g. a broken aggregated API), so only fail if nothing came back
+		var groupErr *discovery.ErrGroupDiscoveryFailed
+		if len(apiResourceLists) == 0 || !errors.As(err, &groupErr) {
+			return nil, nil, fmt.Errorf("failed to discover API resources: %w", err)
+		}
+		logging.FromContext(ctx).Warn("Partial API discovery failure, continuing with available groups", "error", err)
+
+		for gv, groupErr := range groupErr.Groups {
+			if kc.mayIncludeGroup(gv.Group) {
+				failed = append(failed, TypeError{
+					Resource: gv.String(),
+					Group:    gv.Group,
+					Err:      fmt.Errorf("failed to discover API group: %w", groupErr),
+				})
+			}
+		}
+		sort.Slice(failed, func(i, j int) bool {
+			return failed[i].Resource < failed[j].Resource
+		})
+	}
+
+	for _, apiResourceList := range apiResourceLists {
+		gv, err := schema.ParseGroupVersion(apiResourceList.GroupVersion)
+		if err != nil {
+			logging.FromContext(ctx).Warn("Skipping invalid group version",
+				"group_version", apiResourceList.GroupVersion, "error", err)
+			continue
+		}
+
+		for _, apiResource := range apiResourceList.APIResources {
+			// Skip subresources such as deployments/scale
+			if strings.Contains(apiResource.Name, "/") {
+				continue
+			}
+
+			if !hasVerbs(apiResource.Verbs, verbs...) {
+				continue
+			}
+
+			if !kc.shouldIncludeResource(gv.Group, apiResource.Name) {
+				continue
+			}
+
+			resourceTypes = append(resourceTypes, discoveredResource{
+				gvr:         gv.WithResource(apiResource.Name),
+				apiResource: apiResource,
+			})
+		}
+	}
+
+	return resourceTypes, failed, nil
+}
+
+// mayIncludeGroup reports whether the filters may select types of group.
+// Only group/* exclusions rule out a whole group.
+func (kc *KubernetesCollector) mayIncludeGroup(group string) bool {
+	for _, excluded := range kc.config.Kubernetes.ExcludeResources {
+		if excluded == "*" || excluded == group+"/*" {
+			return false
+		}
+	}
+	if len(kc.config.Kubernetes.IncludeResources) == 0 {
+		return true
+	}
+	for _, included := range kc.config.Kubernetes.IncludeResources {
+		filterGroup, _, qualified := strings.Cut(included, "/")
+		if included == "*" || !qualified || filterGroup == group {
+			return true
+		}
+	}
+	return false
+}
+
+// dynamicLister lists the pages of a single resource type through the dynamic client
+func (kc *KubernetesCollector) dynamicLister(gvr schema.GroupVersionResource, apiResource metav1.APIResource) listFunc {
+	return func(ctx context.Context, namespace string, opts metav1.ListOptions) ([]Resource, string, error) {
+		var resources []Resource
+
+		list, err := kc.dynamicClient.Resource(gvr).Namespace(namespace).List(ctx, opts)
+		if err != nil {
+			return nil, "", err
+		}
+
+		for i := range list.Items {
+			if resource, ok := kc.dynamicResource(gvr, apiResource, &list.Items[i]); ok {
+				resources = append(resources, resource)
+			}
+		}
+
+		return resources, list.GetContinue(), nil
+	}
+}
+
+// dynamicResource wraps an object returned by the dynamic client, reporting
+// false if it is filtered out
+func (kc *KubernetesCollector) dynamicResource(gvr schema.GroupVersionResource, apiResource metav1.APIResource,
+	item *unstructured.Unstructured) (Resource, bool) {
+	if apiResource.Namespaced && !kc.shouldIncludeNamespace(item.GetNamespace()) {
+		return Resource{}, false
+	}
+
+	if !kc.shouldIncludeObject(apiResource.Kind, item) {
+		return Resource{}, false
+	}
+
+	return Resource{
+		APIVersion: gvr.GroupVersion().String(),
+		Kind:       apiResource.Kind,
+		Namespace:  item.GetNamespace(),
+		Name:       item.GetName(),
+		Object:     item,
+	}, true
+}
+
+// shouldIncludeObject applies the same per-object skips as the built-in
+// collectors to objects returned by the dynamic client
+func (kc *KubernetesCollector) shouldIncludeObject(kind string, obj *unstructured.Unstructured) bool {
+	switch kind {
+	case "Namespace":
+		return kc.shouldIncludeNamespace(obj.GetName())
+	case "ConfigMap":
+		return obj.GetName() != "kube-root-ca.crt"
+	case "ServiceAccount":
+		return obj.GetName() != "default"
+	case "Secret":
+		secretType, _, _ := unstructured.NestedString(obj.Object, "type")
+		return secretType != "kubernetes.io/service-account-token" &&
+			secretType != "helm.sh/release.v1"
+	case "ClusterRole":
+		name := obj.GetName()
+		return name != "admin" && name != "edit" && name != "view" && !isSystemClusterRole(name)
+	case "ClusterRoleBinding":
+		return !isSystemClusterRoleBinding(obj.GetName())
+	}
+
+	return true
+}
+
+// hasVerbs checks if an API resource supports all of the given verbs
+func hasVerbs(supported metav1.Verbs, verbs ...string) bool {
+	for _, verb := range verbs {
+		found := false
+		for _, v := range supported {
+			if v == verb {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,293 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"kube-git-backup/internal/config"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// discoveryClientset is a fake clientset whose discovery fails for some
+// group versions, like an unavailable aggregated API
+type discoveryClientset struct {
+	*fake.Clientset
+	failing map[string]bool
+}
+
+func (c discoveryClientset) Discovery() discovery.DiscoveryInterface {
+	return failingDiscovery{FakeDiscovery: c.Clientset.Discovery().(*fakediscovery.FakeDiscovery), failing: c.failing}
+}
+
+type failingDiscovery struct {
+	*fakediscovery.FakeDiscovery
+	failing map[string]bool
+}
+
+func (d failingDiscovery) ServerResourcesForGroupVersion(groupVersion string) (*metav1.APIResourceList, error) {
+	if d.failing[groupVersion] {
+		return nil, errors.New("the server is currently unable to handle the request")
+	}
+	return d.FakeDiscovery.ServerResourcesForGroupVersion(groupVersion)
+}
+
+// newDiscoveryCollector returns a discovery mode collector whose API server
+// advertises namespaces, configmaps, deployments and the metrics.k8s.io API,
+// with the given group versions failing discovery
+func newDiscoveryCollector(kubernetes config.KubernetesConfig, failing ...string) *KubernetesCollector {
+	clientset := fake.NewClientset()
+	clientset.Resources = []*metav1.APIResourceList{
+		{
+			GroupVersion: "v1",
+			APIResources: []metav1.APIResource{
+				{Name: "namespaces", Kind: "Namespace", Verbs: metav1.Verbs{"list", "watch"}},
+				{Name: "configmaps", Kind: "ConfigMap", Namespaced: true, Verbs: metav1.Verbs{"list", "watch"}},
+				{Name: "pods/log", Kind: "Pod", Namespaced: true, Verbs: metav1.Verbs{"get"}},
+			},
+		},
+		{
+			GroupVersion: "apps/v1",
+			APIResources: []metav1.APIResource{
+				{Name: "deployments", Kind: "Deployment", Namespaced: true, Verbs: metav1.Verbs{"list", "watch"}},
+				{Name: "deployments/scale", Kind: "Scale", Namespaced: true, Verbs: metav1.Verbs{"get", "list"}},
+			},
+		},
+		{
+			GroupVersion: "metrics.k8s.io/v1beta1",
+			APIResources: []metav1.APIResource{
+				{Name: "pods", Kind: "PodMetrics", Namespaced: true, Verbs: metav1.Verbs{"list"}},
+			},
+		},
+	}
+
+	failingGroups := make(map[string]bool)
+	for _, groupVersion := range failing {
+		failingGroups[groupVersion] = true
+	}
+
+	kubernetes.DiscoveryMode = true
+	return &KubernetesCollector{
+		clientset: discoveryClientset{Clientset: clientset, failing: failingGroups},
+		dynamicClient: dynamicfake.NewSimpleDynamicClientWithCustomListKinds(scheme.Scheme,
+			map[schema.GroupVersionResource]string{
+				{Version: "v1", Resource: "namespaces"}:                         "NamespaceList",
+				{Version: "v1", Resource: "configmaps"}:                         "ConfigMapList",
+				{Group: "apps", Version: "v1", Resource: "deployments"}:         "DeploymentList",
+				{Group: "metrics.k8s.io", Version: "v1beta1", Resource: "pods"}: "PodMetricsList",
+			},
+			&corev1.ConfigMap{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+				ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: "settings"},
+			},
+			&corev1.ConfigMap{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+				ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: "kube-root-ca.crt"},
+			},
+			&corev1.ConfigMap{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+				ObjectMeta: metav1.ObjectMeta{Namespace: "staging", Name: "settings"},
+			}),
+		config: &config.Config{Kubernetes: kubernetes},
+	}
+}
+
+func TestCollectPagesReportsUndiscoveredGroups(t *testing.T) {
+	tests := []struct {
+		name     string
+		include  []string
+		expected []string
+	}{
+		{"all types", nil, []string{"metrics.k8s.io/v1beta1"}},
+		{"plain names may be in any group", []string{"configmaps"}, []string{"metrics.k8s.io/v1beta1"}},
+		{"other groups only", []string{"apps/deployments"}, nil},
+		{"all types of other groups", []string{"apps/*"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kc := newDiscoveryCollector(config.KubernetesConfig{IncludeResources: tt.include}, "metrics.k8s.io/v1beta1")
+
+			resources, failed, err := kc.CollectResources(t.Context())
+			if err != nil {
+				t.Fatalf("Expected partial discovery to succeed, got %v", err)
+			}
+
+			var failedTypes []string
+			for _, typeErr := range failed {
+				failedTypes = append(failedTypes, typeErr.Resource)
+				if typeErr.Group != "metrics.k8s.io" || typeErr.Kind != "" {
+					t.Errorf("Expected a failed group without kind, got %+v", typeErr)
+				}
+			}
+			if len(failedTypes) != len(tt.expected) || (len(failedTypes) > 0 && failedTypes[0] != tt.expected[0]) {
+				t.Errorf("Expected failed types %v, got %v", tt.expected, failedTypes)
+			}
+
+			// The available groups are still collected
+			if tt.include == nil && len(resources) != 2 {
+				t.Errorf("Expected the configmaps to be collected, got %+v", resources)
+			}
+		})
+	}
+}
+
+func TestCollectPagesSkipsExcludedGroups(t *testing.T) {
+	kc := newDiscoveryCollector(config.KubernetesConfig{ExcludeResources: []string{"metrics.k8s.io/*"}}, "metrics.k8s.io/v1beta1")
+
+	_, failed, err := kc.CollectResources(t.Context())
+	if err != nil {
+		t.Fatalf("Expected partial discovery to succeed, got %v", err)
+	}
+	if len(failed) != 0 {
+		t.Errorf("Expected no failures for an excluded group, got %+v", failed)
+	}
+}
+
+func TestDiscoverResourceTypes(t *testing.T) {
+	tests := []struct {
+		name     string
+		include  []string
+		exclude  []string
+		verbs    []string
+		expected []string
+	}{
+		{"listable", nil, nil, []string{"list"},
+			[]string{"apps/deployments", "configmaps", "metrics.k8s.io/pods", "namespaces"}},
+		{"watchable", nil, nil, []string{"list", "watch"},
+			[]string{"apps/deployments", "configmaps", "namespaces"}},
+		{"included by plural name or group", []string{"configmaps", "metrics.k8s.io/*"}, nil, []string{"list"},
+			[]string{"configmaps", "metrics.k8s.io/pods"}},
+		{"excluded by plural name or group", nil, []string{"namespaces", "metrics.k8s.io/*"}, []string{"list"},
+			[]string{"apps/deployments", "configmaps"}},
+		{"excluded by group/resource", []string{"*"}, []string{"apps/deployments"}, []string{"list"},
+			[]string{"configmaps", "metrics.k8s.io/pods", "namespaces"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kc := newDiscoveryCollector(config.KubernetesConfig{IncludeResources: tt.include, ExcludeResources: tt.exclude})
+
+			resourceTypes, failed, err := kc.discoverResourceTypes(t.Context(), tt.verbs...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(failed) != 0 {
+				t.Errorf("Unexpected failures: %+v", failed)
+			}
+
+			var got []string
+			for _, resourceType := range resourceTypes {
+				got = append(got, resourceType.String())
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestDiscoverResourceTypesFails(t *testing.T) {
+	// Without any group, discovery fails as a whole
+	kc := newDiscoveryCollector(config.KubernetesConfig{}, "v1", "apps/v1", "metrics.k8s.io/v1beta1")
+	if _, _, err := kc.discoverResourceTypes(t.Context(), "list"); err == nil {
+		t.Error("Expected an error when no group could be discovered")
+	}
+}
+
+func TestDynamicLister(t *testing.T) {
+	gvr := schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	apiResource := metav1.APIResource{Name: "configmaps", Kind: "ConfigMap", Namespaced: true}
+
+	tests := []struct {
+		name      string
+		namespace string
+		exclude   []string
+		expected  []string
+	}{
+		{"all namespaces", "", nil, []string{"prod/settings", "staging/settings"}},
+		{"single namespace", "staging", nil, []string{"staging/settings"}},
+		{"excluded namespace", "", []string{"staging"}, []string{"prod/settings"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kc := newDiscoveryCollector(config.KubernetesConfig{ExcludeNamespaces: tt.exclude})
+
+			resources, _, err := kc.dynamicLister(gvr, apiResource)(t.Context(), tt.namespace, metav1.ListOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// kube-root-ca.crt is skipped like by the built-in collector
+			var got []string
+			for _, resource := range resources {
+				if resource.APIVersion != "v1" || resource.Kind != "ConfigMap" || resource.Object == nil {
+					t.Errorf("Unexpected resource %+v", resource)
+				}
+				got = append(got, resource.Namespace+"/"+resource.Name)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestDiscoveryCollectionUnits(t *testing.T) {
+	kc := newDiscoveryCollector(config.KubernetesConfig{
+		IncludeResources:  []string{"namespaces", "configmaps", "deployments"},
+		IncludeNamespaces: []string{"prod", "staging"},
+	})
+
+	units, failed, err := kc.collectionUnits(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 0 {
+		t.Errorf("Unexpected failures: %+v", failed)
+	}
+
+	// Namespaced types are listed per included namespace, cluster-scoped ones once
+	var got []string
+	for _, unit := range units {
+		got = append(got, fmt.Sprintf("%s/%s/%s/%s", unit.group, unit.kind, unit.resourceType, unit.namespace))
+	}
+	sort.Strings(got)
+	expected := []string{
+		"/ConfigMap/configmaps/prod",
+		"/ConfigMap/configmaps/staging",
+		"/Namespace/namespaces/",
+		"apps/Deployment/apps/deployments/prod",
+		"apps/Deployment/apps/deployments/staging",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected units %v, got %v", expected, got)
+	}
+
+	// The units list through the dynamic client
+	for _, unit := range units {
+		if unit.resourceType != "configmaps" || unit.namespace != "staging" {
+			continue
+		}
+		resources, _, err := unit.list(t.Context(), unit.namespace, metav1.ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resources) != 1 || resources[0].Name != "settings" || resources[0].Namespace != "staging" {
+			t.Errorf("Expected the configmap of staging, got %+v", resources)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package collector
+
+import (
+	"context"
+
+	"kube-git-backup/internal/logging"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// maxListRestarts bounds how often the list of a resource type restarts after
+// its continue token expired
+const maxListRestarts = 3
+
+// listFunc lists a page of a resource type in a namespace, or in all
+// namespaces if it is empty, returning the included resources of the page
+// and the continue token of the next page
+type listFunc func(ctx context.Context, namespace string, opts metav1.ListOptions) ([]Resource, string, error)
+
+// listPages lists a resource type in pages of KUBE_LIST_PAGE_SIZE objects and
+// hands each page to onPage. When a continue token expires because paging
+// took longer than the API server keeps its snapshot, the list restarts from
+// the beginning after onRestart has dropped the pages handed over so far.
+func (kc *KubernetesCollector) listPages(ctx context.Context, list listFunc, namespace string,
+	onPage func([]Resource) error, onRestart func()) error {
+	opts := metav1.ListOptions{Limit: kc.config.Kubernetes.ListPageSize}
+	restarts := 0
+
+	for {
+		page, continueToken, err := list(ctx, namespace, opts)
+		if err != nil {
+			if !apierrors.IsResourceExpired(err) || opts.Continue == "" || restarts == maxListRestarts {
+				return err
+			}
+			restarts++
+			logging.FromContext(ctx).Warn("List continue token expired, restarting list", "restart", restarts)
+			onRestart()
+			opts.Continue = ""
+			continue
+		}
+
+		if err := onPage(page); err != nil {
+			return err
+		}
+
+		if continueToken == "" {
+			return nil
+		}
+		opts.Continue = continueToken
+	}
+}
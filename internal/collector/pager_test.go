@@ -0,0 +1,158 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"kube-git-backup/internal/config"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestCollectPages(t *testing.T) {
+	var requests []metav1.ListOptions
+	kc := newPagedCollector(5, 2, &requests, func(metav1.ListOptions) bool { return false })
+
+	var pages [][]string
+	names, failed, err := CollectPages(t.Context(), kc, func(page []Resource) ([]string, error) {
+		var converted []string
+		for _, resource := range page {
+			converted = append(converted, resource.Name)
+		}
+		pages = append(pages, converted)
+		return converted, nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to collect: %v", err)
+	}
+
+	expected := "[cm-0 cm-1 cm-2 cm-3 cm-4]"
+	if fmt.Sprint(names) != expected {
+		t.Errorf("Expected %s, got %v", expected, names)
+	}
+	if len(pages) != 3 {
+		t.Errorf("Expected 3 pages, got %v", pages)
+	}
+	for i, opts := range requests {
+		if opts.Limit != 2 {
+			t.Errorf("Expected request %d to be limited to 2 objects, got %d", i, opts.Limit)
+		}
+	}
+	if len(failed) != 0 {
+		t.Errorf("Expected no collection errors, got %v", failed)
+	}
+}
+
+func TestCollectPagesExpiredContinue(t *testing.T) {
+	// The token of the third page expires once, so the list restarts and
+	// the pages collected before are dropped
+	var requests []metav1.ListOptions
+	expired := false
+	kc := newPagedCollector(5, 2, &requests, func(opts metav1.ListOptions) bool {
+		if opts.Continue == "4" && !expired {
+			expired = true
+			return true
+		}
+		return false
+	})
+
+	resources, failed, err := kc.CollectResources(t.Context())
+	if err != nil {
+		t.Fatalf("Failed to collect: %v", err)
+	}
+	if len(resources) != 5 {
+		t.Errorf("Expected 5 resources without duplicates, got %d", len(resources))
+	}
+	if len(requests) != 6 {
+		t.Errorf("Expected the list to restart after 3 requests, got %d requests", len(requests))
+	}
+	if len(failed) != 0 {
+		t.Errorf("Expected no collection errors, got %v", failed)
+	}
+
+	// Tokens that keep expiring fail the resource type after maxListRestarts
+	requests = nil
+	kc = newPagedCollector(5, 2, &requests, func(opts metav1.ListOptions) bool { return opts.Continue != "" })
+	resources, failed, err = kc.CollectResources(t.Context())
+	if err != nil {
+		t.Fatalf("Failed to collect: %v", err)
+	}
+	if len(resources) != 0 {
+		t.Errorf("Expected no resources, got %d", len(resources))
+	}
+	if len(failed) != 1 || failed[0].Resource != "configmaps" || !apierrors.IsResourceExpired(failed[0].Err) {
+		t.Errorf("Expected configmaps to fail with an expired token, got %v", failed)
+	}
+	if len(requests) != 2*(maxListRestarts+1) {
+		t.Errorf("Expected %d requests, got %d", 2*(maxListRestarts+1), len(requests))
+	}
+}
+
+func TestCollectPagesConvertError(t *testing.T) {
+	var requests []metav1.ListOptions
+	kc := newPagedCollector(5, 2, &requests, func(metav1.ListOptions) bool { return false })
+
+	convertErr := errors.New("broken")
+	_, _, err := CollectPages(t.Context(), kc, func(page []Resource) ([]Resource, error) {
+		return nil, convertErr
+	})
+	if !errors.Is(err, convertErr) {
+		t.Errorf("Expected the convert error, got %v", err)
+	}
+	if len(requests) != 1 {
+		t.Errorf("Expected the collection to stop after the first page, got %d requests", len(requests))
+	}
+}
+
+// newPagedCollector returns a collector of ConfigMaps whose API serves count
+// ConfigMaps in pages of at most pageSize, using the index of the next
+// ConfigMap as continue token. Requests are recorded in requests, and those
+// for which expire returns true fail with an expired continue token.
+func newPagedCollector(count int, pageSize int64, requests *[]metav1.ListOptions,
+	expire func(metav1.ListOptions) bool) *KubernetesCollector {
+	clientset := fake.NewClientset()
+	clientset.PrependReactor("list", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		opts := action.(k8stesting.ListActionImpl).ListOptions
+		*requests = append(*requests, opts)
+		if expire(opts) {
+			return true, nil, apierrors.NewResourceExpired("continue token expired")
+		}
+
+		start := 0
+		if opts.Continue != "" {
+			start, _ = strconv.Atoi(opts.Continue)
+		}
+		end := count
+		if opts.Limit > 0 && start+int(opts.Limit) < count {
+			end = start + int(opts.Limit)
+		}
+
+		list := &corev1.ConfigMapList{}
+		for i := start; i < end; i++ {
+			list.Items = append(list.Items, corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("cm-%d", i), Namespace: "shop"},
+			})
+		}
+		if end < count {
+			list.Continue = strconv.Itoa(end)
+		}
+		return true, list, nil
+	})
+
+	return &KubernetesCollector{
+		clientset: clientset,
+		config: &config.Config{
+			Kubernetes: config.KubernetesConfig{
+				IncludeResources: []string{"configmaps"},
+				ListPageSize:     pageSize,
+			},
+		},
+	}
+}
@@ -0,0 +1,257 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"kube-git-backup/internal/logging"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Change is a resource that was created, updated or deleted in the cluster
+type Change struct {
+	Resource Resource
+	Deleted  bool
+}
+
+// Watch starts shared informers for the selected resource types and sends
+// the changes observed over each debounce window to batches. Objects that
+// already exist when the informers start are not reported. It blocks until
+// ctx is done.
+func (kc *KubernetesCollector) Watch(ctx context.Context, debounce time.Duration, batches chan<- []Change) error {
+	logger := logging.FromContext(ctx)
+
+	resourceTypes, err := kc.watchedResourceTypes(ctx)
+	if err != nil {
+		return err
+	}
+
+	buffer := newChangeBuffer()
+	factories := kc.newInformerFactories()
+
+	for _, resourceType := range resourceTypes {
+		handler := cache.ResourceEventHandlerDetailedFuncs{
+			AddFunc: func(obj interface{}, isInInitialList bool) {
+				// The initial list is covered by the full backup
+				if !isInInitialList {
+					kc.recordChange(buffer, resourceType, obj, false)
+				}
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				kc.recordChange(buffer, resourceType, newObj, false)
+			},
+			DeleteFunc: func(obj interface{}) {
+				if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+					obj = tombstone.Obj
+				}
+				kc.recordChange(buffer, resourceType, obj, true)
+			},
+		}
+
+		for _, factory := range factories.forResource(resourceType) {
+			if _, err := factory.ForResource(resourceType.gvr).Informer().AddEventHandler(handler); err != nil {
+				return fmt.Errorf("failed to watch %s: %w", resourceType, err)
+			}
+		}
+	}
+
+	for _, factory := range factories.all() {
+		factory.Start(ctx.Done())
+		defer factory.Shutdown()
+	}
+
+	for _, factory := range factories.all() {
+		for gvr, synced := range factory.WaitForCacheSync(ctx.Done()) {
+			if !synced && ctx.Err() == nil {
+				logger.Warn("Failed to sync informer", "resource", gvr.String())
+			}
+		}
+	}
+	logger.Info("Watching resource types", "count", len(resourceTypes), "namespaces", len(factories.namespaced))
+
+	buffer.run(ctx, debounce, batches)
+	return nil
+}
+
+// informerFactories are the shared informer factories of a watch. Informers
+// keep every watched object in memory, so namespaced types are only watched
+// where they are backed up.
+type informerFactories struct {
+	cluster    dynamicinformer.DynamicSharedInformerFactory   // Cluster-scoped types
+	namespaced []dynamicinformer.DynamicSharedInformerFactory // Namespaced types, one per watched namespace
+}
+
+// newInformerFactories creates a factory per included namespace with
+// INCLUDE_NAMESPACES, or one for all namespaces that leaves out the excluded
+// namespaces with a field selector
+func (kc *KubernetesCollector) newInformerFactories() informerFactories {
+	factories := informerFactories{
+		cluster: dynamicinformer.NewDynamicSharedInformerFactory(kc.dynamicClient, 0),
+	}
+
+	if len(kc.config.Kubernetes.IncludeNamespaces) == 0 {
+		var excluded []fields.Selector
+		for _, namespace := range kc.config.Kubernetes.ExcludeNamespaces {
+			excluded = append(excluded, fields.OneTermNotEqualSelector("metadata.namespace", namespace))
+		}
+		fieldSelector := fields.AndSelectors(excluded...).String()
+
+		factories.namespaced = append(factories.namespaced, dynamicinformer.NewFilteredDynamicSharedInformerFactory(
+			kc.dynamicClient, 0, metav1.NamespaceAll, func(options *metav1.ListOptions) {
+				options.FieldSelector = fieldSelector
+			}))
+		return factories
+	}
+
+	for _, namespace := range kc.config.Kubernetes.IncludeNamespaces {
+		if kc.shouldIncludeNamespace(namespace) {
+			factories.namespaced = append(factories.namespaced,
+				dynamicinformer.NewFilteredDynamicSharedInformerFactory(kc.dynamicClient, 0, namespace, nil))
+		}
+	}
+	return factories
+}
+
+// forResource returns the factories that watch resourceType
+func (f informerFactories) forResource(resourceType discoveredResource) []dynamicinformer.DynamicSharedInformerFactory {
+	if resourceType.apiResource.Namespaced {
+		return f.namespaced
+	}
+	return []dynamicinformer.DynamicSharedInformerFactory{f.cluster}
+}
+
+// all returns every factory
+func (f informerFactories) all() []dynamicinformer.DynamicSharedInformerFactory {
+	return append([]dynamicinformer.DynamicSharedInformerFactory{f.cluster}, f.namespaced...)
+}
+
+// watchedResourceTypes returns the included resource types that can be
+// watched. Outside of discovery mode only the built-in types are watched.
+func (kc *KubernetesCollector) watchedResourceTypes(ctx context.Context) ([]discoveredResource, error) {
+	// Types of groups that failed to be discovered are caught by the periodic
+	// full backup, which keeps their files
+	resourceTypes, _, err := kc.discoverResourceTypes(ctx, "list", "watch")
+	if err != nil {
+		return nil, err
+	}
+
+	if kc.config.Kubernetes.DiscoveryMode {
+		return resourceTypes, nil
+	}
+
+	builtin := make(map[string]bool)
+	for _, resourceType := range kc.builtinResourceTypes() {
+		builtin[resourceType.group+"/"+resourceType.name] = true
+	}
+
+	var watched []discoveredResource
+	for _, resourceType := range resourceTypes {
+		if builtin[resourceType.gvr.Group+"/"+resourceType.gvr.Resource] {
+			watched = append(watched, resourceType)
+		}
+	}
+
+	return watched, nil
+}
+
+// recordChange adds an informer event to the buffer if the object passes the filters
+func (kc *KubernetesCollector) recordChange(buffer *changeBuffer, resourceType discoveredResource,
+	obj interface{}, deleted bool) {
+	item, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+
+	resource, ok := kc.dynamicResource(resourceType.gvr, resourceType.apiResource, item.DeepCopy())
+	if !ok {
+		return
+	}
+
+	buffer.add(Change{Resource: resource, Deleted: deleted})
+}
+
+// changeBuffer collects changes, keeping only the latest change per object
+type changeBuffer struct {
+	mu      sync.Mutex
+	pending map[string]Change
+	notify  chan struct{}
+}
+
+func newChangeBuffer() *changeBuffer {
+	return &changeBuffer{
+		pending: make(map[string]Change),
+		notify:  make(chan struct{}, 1),
+	}
+}
+
+// add records a change, replacing any earlier change of the same object
+func (cb *changeBuffer) add(change Change) {
+	r := change.Resource
+	key := fmt.Sprintf("%s/%s/%s/%s", r.APIVersion, r.Kind, r.Namespace, r.Name)
+
+	cb.mu.Lock()
+	cb.pending[key] = change
+	cb.mu.Unlock()
+
+	select {
+	case cb.notify <- struct{}{}:
+	default:
+	}
+}
+
+// flush returns the pending changes in a deterministic order and clears them
+func (cb *changeBuffer) flush() []Change {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	keys := make([]string, 0, len(cb.pending))
+	for key := range cb.pending {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	changes := make([]Change, 0, len(keys))
+	for _, key := range keys {
+		changes = append(changes, cb.pending[key])
+	}
+	cb.pending = make(map[string]Change)
+
+	return changes
+}
+
+// run waits for a change, lets further changes accumulate for the debounce
+// window and then sends them as one batch, until ctx is done
+func (cb *changeBuffer) run(ctx context.Context, debounce time.Duration, batches chan<- []Change) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-cb.notify:
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(debounce):
+		}
+
+		changes := cb.flush()
+		if len(changes) == 0 {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case batches <- changes:
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package collector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"kube-git-backup/internal/config"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestChangeBuffer(t *testing.T) {
+	buffer := newChangeBuffer()
+	batches := make(chan []Change, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go buffer.run(ctx, 20*time.Millisecond, batches)
+
+	web := Resource{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "prod", Name: "web"}
+	settings := Resource{APIVersion: "v1", Kind: "ConfigMap", Namespace: "prod", Name: "settings"}
+
+	buffer.add(Change{Resource: web})
+	buffer.add(Change{Resource: settings})
+	buffer.add(Change{Resource: web, Deleted: true})
+
+	select {
+	case changes := <-batches:
+		if len(changes) != 2 {
+			t.Fatalf("Expected 2 changes, got %d", len(changes))
+		}
+		// Sorted by key, the latest change of each object wins
+		if changes[0].Resource.Name != "web" || !changes[0].Deleted {
+			t.Errorf("Expected deleted web first, got %+v", changes[0])
+		}
+		if changes[1].Resource.Name != "settings" || changes[1].Deleted {
+			t.Errorf("Expected updated settings second, got %+v", changes[1])
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected a batch after the debounce window")
+	}
+}
+
+func TestRecordChangeAppliesFilters(t *testing.T) {
+	kc := &KubernetesCollector{
+		config: &config.Config{
+			Kubernetes: config.KubernetesConfig{
+				ExcludeNamespaces: []string{"kube-system"},
+			},
+		},
+	}
+	resourceType := discoveredResource{
+		gvr:         schema.GroupVersionResource{Version: "v1", Resource: "configmaps"},
+		apiResource: metav1.APIResource{Name: "configmaps", Kind: "ConfigMap", Namespaced: true},
+	}
+
+	configMap := func(namespace, name string) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{}
+		obj.SetNamespace(namespace)
+		obj.SetName(name)
+		return obj
+	}
+
+	buffer := newChangeBuffer()
+	kc.recordChange(buffer, resourceType, configMap("kube-system", "settings"), false)
+	kc.recordChange(buffer, resourceType, configMap("prod", "kube-root-ca.crt"), false)
+	kc.recordChange(buffer, resourceType, configMap("prod", "settings"), true)
+
+	changes := buffer.flush()
+	if len(changes) != 1 {
+		t.Fatalf("Expected 1 change, got %d", len(changes))
+	}
+	if r := changes[0].Resource; r.Kind != "ConfigMap" || r.APIVersion != "v1" || r.Namespace != "prod" || !changes[0].Deleted {
+		t.Errorf("Unexpected change %+v", changes[0])
+	}
+}
+
+func TestInformerFactoriesWatchIncludedNamespaces(t *testing.T) {
+	configMaps := schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	namespaces := schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+
+	var objects []runtime.Object
+	for _, namespace := range []string{"a", "b", "c"} {
+		objects = append(objects, &corev1.Namespace{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"},
+			ObjectMeta: metav1.ObjectMeta{Name: namespace},
+		}, &corev1.ConfigMap{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "settings"},
+		})
+	}
+
+	kc := &KubernetesCollector{
+		config: &config.Config{
+			Kubernetes: config.KubernetesConfig{
+				IncludeNamespaces: []string{"a", "b"},
+				ExcludeNamespaces: []string{"b"},
+			},
+		},
+		dynamicClient: dynamicfake.NewSimpleDynamicClientWithCustomListKinds(scheme.Scheme,
+			map[schema.GroupVersionResource]string{
+				configMaps: "ConfigMapList",
+				namespaces: "NamespaceList",
+			}, objects...),
+	}
+
+	factories := kc.newInformerFactories()
+	if len(factories.namespaced) != 1 {
+		t.Fatalf("Expected a factory for namespace a only, got %d", len(factories.namespaced))
+	}
+
+	var stores []cache.Store
+	for _, resourceType := range []discoveredResource{
+		{gvr: configMaps, apiResource: metav1.APIResource{Name: "configmaps", Kind: "ConfigMap", Namespaced: true}},
+		{gvr: namespaces, apiResource: metav1.APIResource{Name: "namespaces", Kind: "Namespace"}},
+	} {
+		for _, factory := range factories.forResource(resourceType) {
+			stores = append(stores, factory.ForResource(resourceType.gvr).Informer().GetStore())
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	for _, factory := range factories.all() {
+		factory.Start(ctx.Done())
+		factory.WaitForCacheSync(ctx.Done())
+	}
+	defer func() {
+		cancel()
+		for _, factory := range factories.all() {
+			factory.Shutdown()
+		}
+	}()
+
+	// Only the configmaps of the included namespace are cached, while
+	// cluster-scoped types are watched as a whole
+	if keys := stores[0].ListKeys(); len(keys) != 1 || keys[0] != "a/settings" {
+		t.Errorf("Expected only a/settings in the configmap cache, got %v", keys)
+	}
+	if keys := stores[1].ListKeys(); len(keys) != 3 {
+		t.Errorf("Expected all 3 namespaces in the namespace cache, got %v", keys)
+	}
+}
@@ -0,0 +1,184 @@
+package collector
+
+import (
+	"context"
+	"sync"
+
+	"kube-git-backup/internal/logging"
+	"kube-git-backup/internal/metrics"
+)
+
+// collectionUnit is a resource type, or its part in a single namespace,
+// listed by one worker
+type collectionUnit struct {
+	resourceType string
+	group        string
+	kind         string
+	namespace    string // Empty for cluster-scoped types and lists across all namespaces
+	list         listFunc
+}
+
+// unitResult is the outcome of collecting a unit
+type unitResult[T any] struct {
+	items   []T
+	listErr error
+}
+
+// collectionUnits returns the units of every included resource type. With
+// INCLUDE_NAMESPACES, namespaced types are listed per included namespace,
+// which spreads them across workers and only needs RBAC in those namespaces.
+// API groups that failed to be discovered are returned as TypeErrors.
+func (kc *KubernetesCollector) collectionUnits(ctx context.Context) ([]collectionUnit, []TypeError, error) {
+	var units []collectionUnit
+
+	addType := func(unit collectionUnit, namespaced bool) {
+		if !namespaced || len(kc.config.Kubernetes.IncludeNamespaces) == 0 {
+			units = append(units, unit)
+			return
+		}
+		for _, namespace := range kc.config.Kubernetes.IncludeNamespaces {
+			if kc.shouldIncludeNamespace(namespace) {
+				unit.namespace = namespace
+				units = append(units, unit)
+			}
+		}
+	}
+
+	// Discovery mode enumerates every listable API resource instead of the
+	// built-in resource types
+	if kc.config.Kubernetes.DiscoveryMode {
+		resourceTypes, failed, err := kc.discoverResourceTypes(ctx, "list")
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, resourceType := range resourceTypes {
+			addType(collectionUnit{
+				resourceType: resourceType.String(),
+				group:        resourceType.gvr.Group,
+				kind:         resourceType.apiResource.Kind,
+				list:         kc.dynamicLister(resourceType.gvr, resourceType.apiResource),
+			}, resourceType.apiResource.Namespaced)
+		}
+		return units, failed, nil
+	}
+
+	for _, resourceType := range kc.builtinResourceTypes() {
+		if kc.shouldIncludeResource(resourceType.group, resourceType.name) {
+			addType(collectionUnit{
+				resourceType: resourceType.name,
+				group:        resourceType.group,
+				kind:         resourceType.kind,
+				list:         resourceType.list,
+			}, resourceType.namespaced)
+		}
+	}
+
+	return units, nil, nil
+}
+
+// collectConcurrently collects units on up to COLLECT_WORKERS workers. The
+// types that failed in any unit are left out and returned as TypeErrors, the
+// rest is returned in the order of units, however the workers interleave. An
+// error of convert or the cancellation of ctx aborts the collection.
+func collectConcurrently[T any](ctx context.Context, kc *KubernetesCollector, units []collectionUnit,
+	convert func([]Resource) ([]T, error)) ([]T, []TypeError, error) {
+	workerCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	results := make([]unitResult[T], len(units))
+	next := make(chan int)
+	var wg sync.WaitGroup
+
+	workers := min(max(kc.config.Kubernetes.CollectWorkers, 1), len(units))
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if workerCtx.Err() != nil {
+					continue
+				}
+				items, listErr, err := collectUnit(workerCtx, kc, units[i], convert)
+				if err != nil {
+					cancel(err)
+					continue
+				}
+				results[i] = unitResult[T]{items: items, listErr: listErr}
+			}
+		}()
+	}
+
+feed:
+	for i := range units {
+		select {
+		case next <- i:
+		case <-workerCtx.Done():
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	if err := context.Cause(workerCtx); err != nil {
+		return nil, nil, err
+	}
+
+	// A type fails as a whole when any of its namespaces failed, so that it
+	// is never backed up partially
+	var failed []TypeError
+	failedTypes := make(map[string]bool)
+	for i, unit := range units {
+		if err := results[i].listErr; err != nil && !failedTypes[unit.resourceType] {
+			metrics.CollectionErrors.WithLabelValues(kc.config.ClusterName, unit.resourceType).Inc()
+			failedTypes[unit.resourceType] = true
+			failed = append(failed, TypeError{
+				Resource: unit.resourceType,
+				Group:    unit.group,
+				Kind:     unit.kind,
+				Err:      err,
+			})
+		}
+	}
+
+	var collected []T
+	for i, unit := range units {
+		if !failedTypes[unit.resourceType] {
+			collected = append(collected, results[i].items...)
+		}
+	}
+
+	return collected, failed, nil
+}
+
+// collectUnit lists a unit page by page, converting each page with convert.
+// A failed list is returned as listErr, while err is an error of convert.
+func collectUnit[T any](ctx context.Context, kc *KubernetesCollector, unit collectionUnit,
+	convert func([]Resource) ([]T, error)) (items []T, listErr error, err error) {
+	logger := logging.FromContext(ctx).With("resource", unit.resourceType)
+	if unit.namespace != "" {
+		logger = logger.With("namespace", unit.namespace)
+	}
+	logger.Debug("Collecting resources")
+
+	listErr = kc.listPages(logging.NewContext(ctx, logger), unit.list, unit.namespace, func(page []Resource) error {
+		converted, convertErr := convert(page)
+		if convertErr != nil {
+			err = convertErr
+			return convertErr
+		}
+		items = append(items, converted...)
+		return nil
+	}, func() {
+		items = nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if listErr != nil {
+		logger.Error("Failed to collect resources", "error", listErr)
+		return nil, listErr, nil
+	}
+
+	logger.Info("Collected resources", "count", len(items))
+	return items, nil, nil
+}
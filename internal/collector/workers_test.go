@@ -0,0 +1,179 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"kube-git-backup/internal/config"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestCollectConcurrently(t *testing.T) {
+	kc := &KubernetesCollector{
+		config: &config.Config{Kubernetes: config.KubernetesConfig{CollectWorkers: 3}},
+	}
+
+	// Earlier units answer slower, so the workers finish out of order
+	var inFlight, peak atomic.Int32
+	var units []collectionUnit
+	for i := range 9 {
+		units = append(units, collectionUnit{
+			resourceType: fmt.Sprintf("type-%d", i/3),
+			namespace:    fmt.Sprintf("ns-%d", i%3),
+			list: func(ctx context.Context, namespace string, opts metav1.ListOptions) ([]Resource, string, error) {
+				current := inFlight.Add(1)
+				defer inFlight.Add(-1)
+				for {
+					previous := peak.Load()
+					if current <= previous || peak.CompareAndSwap(previous, current) {
+						break
+					}
+				}
+				time.Sleep(time.Duration(9-i) * 3 * time.Millisecond)
+				return []Resource{{Namespace: namespace, Name: fmt.Sprint(i)}}, "", nil
+			},
+		})
+	}
+
+	names, _, err := collectConcurrently(t.Context(), kc, units, func(page []Resource) ([]string, error) {
+		var converted []string
+		for _, resource := range page {
+			converted = append(converted, resource.Name)
+		}
+		return converted, nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to collect: %v", err)
+	}
+
+	expected := "0,1,2,3,4,5,6,7,8"
+	if strings.Join(names, ",") != expected {
+		t.Errorf("Expected %s, got %v", expected, names)
+	}
+	if peak.Load() > 3 {
+		t.Errorf("Expected at most 3 concurrent lists, got %d", peak.Load())
+	}
+	if peak.Load() < 2 {
+		t.Errorf("Expected concurrent lists, got %d", peak.Load())
+	}
+}
+
+func TestCollectConcurrentlyFailedNamespace(t *testing.T) {
+	kc, clientset := newNamespacedCollector(2)
+
+	// A type forbidden in one namespace fails as a whole
+	clientset.PrependReactor("list", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetNamespace() == "b" {
+			return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: "secrets"}, "", errors.New("denied"))
+		}
+		return false, nil, nil
+	})
+
+	resources, failed, err := kc.CollectResources(t.Context())
+	if err != nil {
+		t.Fatalf("Failed to collect: %v", err)
+	}
+	kinds := make(map[string]int)
+	for _, resource := range resources {
+		kinds[resource.Kind]++
+	}
+	if kinds["Secret"] != 0 || kinds["ConfigMap"] != 3 || kinds["Service"] != 3 {
+		t.Errorf("Unexpected resources by kind: %v", kinds)
+	}
+	if len(failed) != 1 || !apierrors.IsForbidden(failed[0].Err) {
+		t.Fatalf("Expected only secrets to fail, got %v", failed)
+	}
+	if failed[0].Resource != "secrets" || failed[0].Group != "" || failed[0].Kind != "Secret" {
+		t.Errorf("Unexpected failed type %+v", failed[0])
+	}
+}
+
+func TestCollectConcurrentlyCancel(t *testing.T) {
+	kc, clientset := newNamespacedCollector(2)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	var mu sync.Mutex
+	var listed []string
+	clientset.PrependReactor("list", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		mu.Lock()
+		listed = append(listed, action.GetResource().Resource+"/"+action.GetNamespace())
+		mu.Unlock()
+		cancel()
+		return true, nil, ctx.Err()
+	})
+
+	_, _, err := kc.CollectResources(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the collection to be cancelled, got %v", err)
+	}
+	if len(listed) > 2 {
+		t.Errorf("Expected no lists to start after the cancellation, got %v", listed)
+	}
+}
+
+func TestCollectionUnits(t *testing.T) {
+	kc := &KubernetesCollector{
+		config: &config.Config{
+			Kubernetes: config.KubernetesConfig{
+				IncludeResources:  []string{"namespaces", "configmaps"},
+				IncludeNamespaces: []string{"a", "b"},
+				ExcludeNamespaces: []string{"b"},
+			},
+		},
+	}
+
+	units, _, err := kc.collectionUnits(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, unit := range units {
+		got = append(got, fmt.Sprintf("%s/%s", unit.resourceType, unit.namespace))
+	}
+	expected := "namespaces/,configmaps/a"
+	if strings.Join(got, ",") != expected {
+		t.Errorf("Expected units %s, got %v", expected, got)
+	}
+}
+
+// newNamespacedCollector returns a collector of the Services, ConfigMaps and
+// Secrets of namespaces a, b and c with the given number of workers, backed
+// by a fake clientset that also holds objects of the excluded namespace d
+func newNamespacedCollector(workers int) (*KubernetesCollector, *fake.Clientset) {
+	var objects []runtime.Object
+	for _, namespace := range []string{"a", "b", "c", "d"} {
+		objects = append(objects,
+			&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: namespace}},
+			&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: namespace}},
+			&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "credentials", Namespace: namespace}},
+		)
+	}
+	clientset := fake.NewClientset(objects...)
+
+	kc := &KubernetesCollector{
+		clientset: clientset,
+		config: &config.Config{
+			Kubernetes: config.KubernetesConfig{
+				IncludeResources:  []string{"services", "configmaps", "secrets"},
+				IncludeNamespaces: []string{"a", "b", "c"},
+				CollectWorkers:    workers,
+			},
+		},
+	}
+	return kc, clientset
+}
@@ -3,21 +3,73 @@ package config
 import (
 	"bufio"
 	"fmt"
+	"log/slog"
 	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
+
+	"filippo.io/age"
+	"github.com/robfig/cron/v3"
+	"sigs.k8s.io/yaml"
 )
 
 // Config holds all configuration for the kube-git-backup daemon
 type Config struct {
-	BackupInterval time.Duration
+	ClusterName    string
+	BackupInterval time.Duration // Fallback when BackupSchedule is empty
+	BackupSchedule string        // Standard 5-field cron expression
+	BackupTimezone string        // IANA timezone of BackupSchedule, defaults to UTC
+	BackupJitter   time.Duration // Upper bound of the random delay added to each scheduled run
 	WorkDir        string
-	DumpOnly       bool // If true, only dump locally without Git operations
+	DumpOnly       bool          // If true, only dump locally without Git operations
+	Sinks          []string      // Outputs every snapshot is written to, see Sink*
+	LocalDir       string        // Output directory of the local sink
+	PathTemplate   string        // text/template of the resource file paths, empty for the default layout
+	PathLayout     string        // Built-in layout used without PathTemplate, see PathLayout*
+	WatchMode      bool          // If true, also back up changes as they happen using informers
+	WatchDebounce  time.Duration // Window over which watched changes are batched into one commit
+	ErrorPolicy    string        // What a run does when resource types fail to be collected, see ErrorPolicy*
+	HTTPAddr       string        // Listen address of the metrics and health server
+	LivenessFactor float64       // Liveness fails after this many backup intervals without a finished backup run
+	LogFormat      string        // "text" or "json"
+	LogLevel       string        // "debug", "info", "warn" or "error"
 	Git            GitConfig
+	S3             S3Config
+	Archive        ArchiveConfig
+	OCI            OCIConfig
 	Kubernetes     KubernetesConfig
 	Sanitizer      SanitizerConfig
+	LeaderElection LeaderElectionConfig
+	Targets        []ClusterTarget // Clusters of CLUSTERS_FILE, empty to back up the local cluster only
+}
+
+// ClusterTarget is a cluster backed up by a multi-cluster daemon. Fields
+// left empty fall back to the settings of the environment.
+type ClusterTarget struct {
+	Name              string   `json:"name"`
+	Kubeconfig        string   `json:"kubeconfig,omitempty"` // Defaults to KUBECONFIG
+	Context           string   `json:"context,omitempty"`    // Defaults to KUBE_CONTEXT
+	Directory         string   `json:"directory,omitempty"`  // Output directory of the cluster, defaults to its name
+	Branch            string   `json:"branch,omitempty"`     // Git branch of the cluster, the tree is then at its root
+	IncludeResources  []string `json:"includeResources,omitempty"`
+	ExcludeResources  []string `json:"excludeResources,omitempty"`
+	IncludeNamespaces []string `json:"includeNamespaces,omitempty"`
+	ExcludeNamespaces []string `json:"excludeNamespaces,omitempty"`
+}
+
+// clustersFile is the format of CLUSTERS_FILE
+type clustersFile struct {
+	Clusters []ClusterTarget `json:"clusters"`
 }
 
+// clusterNamePattern restricts target names to lowercase DNS labels, as they
+// are used in directory and OCI repository names
+var clusterNamePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
 // GitConfig holds Git-related configuration
 type GitConfig struct {
 	Repository  string
@@ -27,21 +79,139 @@ type GitConfig struct {
 	AuthMethod  string // "ssh" or "token"
 	SSHKeyPath  string
 	Token       string
+	CacheDir    string        // Git storage directory, defaults to <WORK_DIR>/.git
+	Directory   string        // Slash-separated directory of the repository holding the backup, empty for the root
+	Timeout     time.Duration // Deadline of every clone, fetch, pull and push, zero for none
+}
+
+// S3Config holds the configuration of the S3 sink
+type S3Config struct {
+	Endpoint        string // host[:port] of the S3-compatible service
+	Bucket          string
+	Region          string
+	AccessKeyID     string // Falls back to the AWS_* variables and instance credentials when empty
+	SecretAccessKey string
+	Prefix          string // Key prefix of the cluster's snapshots, defaults to the cluster name
+	Format          string // "objects" or "tarball"
+	Insecure        bool   // Use plain HTTP, e.g. for a local MinIO
 }
 
+// S3 snapshot formats
+const (
+	S3FormatObjects = "objects"
+	S3FormatTarball = "tarball"
+)
+
+// ArchiveConfig holds the configuration of the archive sink
+type ArchiveConfig struct {
+	Dir            string        // Directory the archives are written to, defaults to WORK_DIR
+	Compression    string        // "gzip" or "zstd"
+	RetentionCount int           // Number of archives to keep, 0 keeps all
+	RetentionAge   time.Duration // Maximum age of archives, 0 keeps all
+}
+
+// OCIConfig holds the configuration of the OCI registry sink
+type OCIConfig struct {
+	Repository string // e.g. registry.example.com/backups/production, without tag
+	Username   string // Anonymous access when empty
+	Password   string
+	Insecure   bool // Use plain HTTP, e.g. for a local registry
+}
+
+// Archive compressions
+const (
+	ArchiveCompressionGzip = "gzip"
+	ArchiveCompressionZstd = "zstd"
+)
+
 // KubernetesConfig holds Kubernetes-related configuration
 type KubernetesConfig struct {
 	IncludeResources    []string
 	ExcludeResources    []string
 	IncludeNamespaces   []string // Empty means all namespaces
 	ExcludeNamespaces   []string // Namespaces to exclude
+	DiscoveryMode       bool     // If true, collect every listable API resource via discovery
+	Kubeconfig          string   // Kubeconfig file or list of files, empty for the in-cluster config or ~/.kube/config
+	Context             string   // Kubeconfig context, empty for the current context
+	ImpersonateUser     string   // User to impersonate, empty to act as the configured user
+	ImpersonateGroups   []string // Groups to impersonate along with ImpersonateUser
+	QPS                 float32  // Client request rate limit, 0 for the client-go default
+	Burst               int      // Client request burst, 0 for the client-go default
+	ListPageSize        int64    // Objects per list request, 0 to list each resource type at once
+	CollectWorkers      int      // Resource types and namespaces listed concurrently, 0 behaves like 1
+}
+
+// LeaderElectionConfig holds Lease-based leader election configuration
+type LeaderElectionConfig struct {
+	Enabled       bool
+	Namespace     string
+	LeaseName     string
+	Identity      string // Holder identity, defaults to the pod name
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
 }
 
 // SanitizerConfig holds YAML sanitization configuration
 type SanitizerConfig struct {
-	// Static configuration - no configurable fields
+	StripFields   []StripField
+	SecretMode    string   // "plain", "sops" or "redact"
+	AgeRecipients []string // age recipients for SOPS encryption
+
+	// FingerprintKey keys the fingerprints of SOPS encrypted Secrets, so
+	// files encrypted before a restart are kept while the Secret is unchanged
+	FingerprintKey string
+
+	// RedactKey keys the HMACs that replace Secret values in redact mode, so
+	// that they can't be checked against guessed values without it
+	RedactKey string
 }
 
+// Output sinks
+const (
+	SinkGit     = "git"
+	SinkLocal   = "local"
+	SinkS3      = "s3"
+	SinkArchive = "archive"
+	SinkOCI     = "oci"
+)
+
+// Built-in path layouts
+const (
+	PathLayoutDefault = "default" // <kind>/<name>.yaml
+	PathLayoutGrouped = "grouped" // <kind>.<group>/<name>.yaml, with core kinds bare
+)
+
+// Policies for resource types that fail to be collected
+const (
+	ErrorPolicyKeep  = "keep"  // Back up the other types and keep the files of the failed ones
+	ErrorPolicyAbort = "abort" // Fail the run without writing to any sink
+)
+
+// Secret protection modes
+const (
+	SecretModePlain  = "plain"
+	SecretModeSOPS   = "sops"
+	SecretModeRedact = "redact"
+)
+
+// StripField is a field path to remove from resources, optionally scoped to a single kind
+type StripField struct {
+	Kind string // Empty means all kinds
+	Path string
+}
+
+// defaultExcludeResources is used when EXCLUDE_RESOURCES is not set
+const defaultExcludeResources = "pods,events,endpoints,replicasets"
+
+// defaultDiscoveryExcludeResources is used instead in discovery mode, where
+// every type is listable, leaving out the types that change all the time
+// and the metrics API, which only reports current usage
+const defaultDiscoveryExcludeResources = defaultExcludeResources + ",leases,endpointslices,controllerrevisions,metrics.k8s.io/*"
+
+// defaultStripFields is used when STRIP_FIELDS is not set
+const defaultStripFields = "metadata.uid,metadata.selfLink,metadata.resourceVersion,metadata.generation,metadata.creationTimestamp,metadata.annotations[kubectl.kubernetes.io/last-applied-configuration],metadata.annotations[deployment.kubernetes.io/revision],status,spec.clusterIP,spec.clusterIPs,spec.ports[].nodePort"
+
 // Load loads configuration from environment variables
 func Load() (*Config, error) {
 	// Try to load .env file if it exists
@@ -49,6 +219,9 @@ func Load() (*Config, error) {
 	
 	cfg := &Config{}
 
+	// Cluster name used in commit messages (default: default)
+	cfg.ClusterName = getEnvOrDefault("CLUSTER_NAME", "default")
+
 	// Backup interval (default: 1 hour)
 	intervalStr := getEnvOrDefault("BACKUP_INTERVAL", "1h")
 	interval, err := time.ParseDuration(intervalStr)
@@ -57,12 +230,70 @@ func Load() (*Config, error) {
 	}
 	cfg.BackupInterval = interval
 
+	// Cron schedule (default: none, run every BACKUP_INTERVAL), its timezone
+	// and random jitter
+	cfg.BackupSchedule = os.Getenv("BACKUP_SCHEDULE")
+	cfg.BackupTimezone = os.Getenv("BACKUP_TIMEZONE")
+	jitter, err := time.ParseDuration(getEnvOrDefault("BACKUP_JITTER", "0s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid BACKUP_JITTER: %w", err)
+	}
+	cfg.BackupJitter = jitter
+
+	// Watch mode (default: false) and its debounce window (default: 30s)
+	cfg.WatchMode = getEnvOrDefault("WATCH_MODE", "false") == "true"
+	debounce, err := time.ParseDuration(getEnvOrDefault("WATCH_DEBOUNCE", "30s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid WATCH_DEBOUNCE: %w", err)
+	}
+	cfg.WatchDebounce = debounce
+
 	// Working directory (default: /tmp/kube-backup)
 	cfg.WorkDir = getEnvOrDefault("WORK_DIR", "/tmp/kube-backup")
 
+	// Metrics server listen address (default: :8080)
+	cfg.HTTPAddr = getEnvOrDefault("HTTP_ADDR", ":8080")
+
+	// Liveness threshold as a multiple of the backup interval (default: 3)
+	livenessFactor, err := strconv.ParseFloat(getEnvOrDefault("LIVENESS_INTERVAL_FACTOR", "3"), 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LIVENESS_INTERVAL_FACTOR: %w", err)
+	}
+	cfg.LivenessFactor = livenessFactor
+
+	// Log output format (default: text) and minimum level (default: info)
+	cfg.LogFormat = getEnvOrDefault("LOG_FORMAT", "text")
+	cfg.LogLevel = getEnvOrDefault("LOG_LEVEL", "info")
+
 	// Dump only mode (default: false)
 	cfg.DumpOnly = getEnvOrDefault("DUMP_ONLY", "false") == "true"
 
+	// Output sinks (default: git, or local in dump-only mode) and the local
+	// sink's directory (default: WORK_DIR)
+	cfg.Sinks = parseCommaSeparated(os.Getenv("SINKS"))
+	if len(cfg.Sinks) == 0 {
+		if cfg.DumpOnly {
+			cfg.Sinks = []string{SinkLocal}
+		} else {
+			cfg.Sinks = []string{SinkGit}
+		}
+	}
+	cfg.LocalDir = getEnvOrDefault("LOCAL_DIR", cfg.WorkDir)
+
+	// Partial collection failures (default: keep the last-known files, or
+	// abort with snapshot sinks, whose snapshots can't keep them)
+	cfg.ErrorPolicy = os.Getenv("COLLECTION_ERROR_POLICY")
+	if cfg.ErrorPolicy == "" {
+		cfg.ErrorPolicy = ErrorPolicyKeep
+		if cfg.hasSnapshotSink() {
+			cfg.ErrorPolicy = ErrorPolicyAbort
+		}
+	}
+
+	// Path template of the resource files (default: the built-in layout)
+	cfg.PathTemplate = os.Getenv("PATH_TEMPLATE")
+	cfg.PathLayout = os.Getenv("PATH_LAYOUT")
+
 	// Git configuration
 	gitRepo := os.Getenv("GIT_REPOSITORY")
 	
@@ -79,6 +310,11 @@ func Load() (*Config, error) {
 		authMethod = envAuthMethod
 	}
 	
+	gitTimeout, err := time.ParseDuration(getEnvOrDefault("GIT_TIMEOUT", "5m"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid GIT_TIMEOUT: %w", err)
+	}
+
 	cfg.Git = GitConfig{
 		Repository:  gitRepo,
 		Branch:      getEnvOrDefault("GIT_BRANCH", "main"),
@@ -87,31 +323,342 @@ func Load() (*Config, error) {
 		AuthMethod:  authMethod,
 		SSHKeyPath:  getEnvOrDefault("GIT_SSH_KEY_PATH", "/root/.ssh/id_rsa"),
 		Token:       os.Getenv("GIT_TOKEN"),
+		CacheDir:    os.Getenv("GIT_CACHE_DIR"),
+		Timeout:     gitTimeout,
+	}
+
+	// S3 sink configuration
+	cfg.S3 = S3Config{
+		Endpoint:        getEnvOrDefault("S3_ENDPOINT", "s3.amazonaws.com"),
+		Bucket:          os.Getenv("S3_BUCKET"),
+		Region:          os.Getenv("S3_REGION"),
+		AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+		Prefix:          getEnvOrDefault("S3_PREFIX", cfg.ClusterName),
+		Format:          getEnvOrDefault("S3_FORMAT", S3FormatObjects),
+		Insecure:        getEnvOrDefault("S3_INSECURE", "false") == "true",
+	}
+
+	// Archive sink configuration
+	retentionCount, err := strconv.Atoi(getEnvOrDefault("ARCHIVE_RETENTION_COUNT", "0"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ARCHIVE_RETENTION_COUNT: %w", err)
+	}
+	retentionAge, err := time.ParseDuration(getEnvOrDefault("ARCHIVE_RETENTION_AGE", "0s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ARCHIVE_RETENTION_AGE: %w", err)
+	}
+	cfg.Archive = ArchiveConfig{
+		Dir:            getEnvOrDefault("ARCHIVE_DIR", cfg.WorkDir),
+		Compression:    getEnvOrDefault("ARCHIVE_COMPRESSION", ArchiveCompressionGzip),
+		RetentionCount: retentionCount,
+		RetentionAge:   retentionAge,
+	}
+
+	// OCI registry sink configuration
+	cfg.OCI = OCIConfig{
+		Repository: os.Getenv("OCI_REPOSITORY"),
+		Username:   os.Getenv("OCI_USERNAME"),
+		Password:   os.Getenv("OCI_PASSWORD"),
+		Insecure:   getEnvOrDefault("OCI_INSECURE", "false") == "true",
 	}
 
 	// Kubernetes configuration
 	includeStr := getEnvOrDefault("INCLUDE_RESOURCES", "deployments,daemonsets,statefulsets,services,configmaps,secrets,ingresses,namespaces,roles,rolebindings,clusterroles,clusterrolebindings,serviceaccounts,persistentvolumes,persistentvolumeclaims,storageclasses,networkpolicies")
-	excludeStr := getEnvOrDefault("EXCLUDE_RESOURCES", "pods,events,endpoints,replicasets")
+	discoveryMode := getEnvOrDefault("DISCOVERY_MODE", "false") == "true"
+	excludeStr := getEnvOrDefault("EXCLUDE_RESOURCES", defaultExcludeResources)
+	if discoveryMode {
+		excludeStr = getEnvOrDefault("EXCLUDE_RESOURCES", defaultDiscoveryExcludeResources)
+	}
 	includeNamespacesStr := os.Getenv("INCLUDE_NAMESPACES")
 	excludeNamespacesStr := getEnvOrDefault("EXCLUDE_NAMESPACES", "kube-system,default,kube-node-lease")
 
+	// Client settings (default: the in-cluster config or ~/.kube/config, no
+	// impersonation and the client-go rate limits)
+	qps, err := strconv.ParseFloat(getEnvOrDefault("KUBE_QPS", "0"), 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid KUBE_QPS: %w", err)
+	}
+	burst, err := strconv.Atoi(getEnvOrDefault("KUBE_BURST", "0"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid KUBE_BURST: %w", err)
+	}
+	listPageSize, err := strconv.ParseInt(getEnvOrDefault("KUBE_LIST_PAGE_SIZE", "500"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid KUBE_LIST_PAGE_SIZE: %w", err)
+	}
+	collectWorkers, err := strconv.Atoi(getEnvOrDefault("COLLECT_WORKERS", "4"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid COLLECT_WORKERS: %w", err)
+	}
+
 	cfg.Kubernetes = KubernetesConfig{
 		IncludeResources:  parseCommaSeparated(includeStr),
 		ExcludeResources:  parseCommaSeparated(excludeStr),
 		IncludeNamespaces: parseCommaSeparated(includeNamespacesStr),
 		ExcludeNamespaces: parseCommaSeparated(excludeNamespacesStr),
+		DiscoveryMode:     discoveryMode,
+		Kubeconfig:        os.Getenv("KUBECONFIG"),
+		Context:           os.Getenv("KUBE_CONTEXT"),
+		ImpersonateUser:   os.Getenv("KUBE_IMPERSONATE_USER"),
+		ImpersonateGroups: parseCommaSeparated(os.Getenv("KUBE_IMPERSONATE_GROUPS")),
+		QPS:               float32(qps),
+		Burst:             burst,
+		ListPageSize:      listPageSize,
+		CollectWorkers:    collectWorkers,
 	}
 
-	// Sanitizer configuration - using static defaults
-	cfg.Sanitizer = SanitizerConfig{}
+	// Sanitizer configuration
+	cfg.Sanitizer = SanitizerConfig{
+		StripFields:    parseStripFields(getEnvOrDefault("STRIP_FIELDS", defaultStripFields)),
+		SecretMode:     getEnvOrDefault("SECRET_MODE", SecretModePlain),
+		AgeRecipients:  parseCommaSeparated(os.Getenv("SOPS_AGE_RECIPIENTS")),
+		FingerprintKey: os.Getenv("SOPS_FINGERPRINT_KEY"),
+		RedactKey:      os.Getenv("REDACT_KEY"),
+	}
+
+	// Leader election configuration
+	leaderElection, err := loadLeaderElectionConfig()
+	if err != nil {
+		return nil, err
+	}
+	cfg.LeaderElection = leaderElection
+
+	// Cluster targets of a multi-cluster daemon (default: none). Their S3
+	// keys sit below S3_PREFIX, which then defaults to the bucket root.
+	if clustersPath := os.Getenv("CLUSTERS_FILE"); clustersPath != "" {
+		targets, err := loadTargets(clustersPath)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Targets = targets
+		cfg.S3.Prefix = os.Getenv("S3_PREFIX")
+	}
 
 	return cfg, nil
 }
 
+// loadTargets reads the cluster targets of CLUSTERS_FILE
+func loadTargets(path string) ([]ClusterTarget, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CLUSTERS_FILE: %w", err)
+	}
+
+	var file clustersFile
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid CLUSTERS_FILE %s: %w", path, err)
+	}
+	if len(file.Clusters) == 0 {
+		return nil, fmt.Errorf("CLUSTERS_FILE %s lists no clusters", path)
+	}
+
+	return file.Clusters, nil
+}
+
+// loadLeaderElectionConfig loads the LEADER_ELECTION_* settings
+func loadLeaderElectionConfig() (LeaderElectionConfig, error) {
+	le := LeaderElectionConfig{
+		Enabled:   getEnvOrDefault("LEADER_ELECTION", "false") == "true",
+		Namespace: getEnvOrDefault("LEADER_ELECTION_NAMESPACE", currentNamespace()),
+		LeaseName: getEnvOrDefault("LEADER_ELECTION_LEASE_NAME", "kube-git-backup"),
+		Identity:  os.Getenv("POD_NAME"),
+	}
+
+	if le.Identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return le, fmt.Errorf("failed to determine leader election identity: %w", err)
+		}
+		le.Identity = hostname
+	}
+
+	durations := []struct {
+		key          string
+		defaultValue string
+		target       *time.Duration
+	}{
+		{"LEADER_ELECTION_LEASE_DURATION", "15s", &le.LeaseDuration},
+		{"LEADER_ELECTION_RENEW_DEADLINE", "10s", &le.RenewDeadline},
+		{"LEADER_ELECTION_RETRY_PERIOD", "2s", &le.RetryPeriod},
+	}
+	for _, d := range durations {
+		value, err := time.ParseDuration(getEnvOrDefault(d.key, d.defaultValue))
+		if err != nil {
+			return le, fmt.Errorf("invalid %s: %w", d.key, err)
+		}
+		*d.target = value
+	}
+
+	return le, nil
+}
+
+// currentNamespace returns the namespace of the service account the daemon
+// runs as, falling back to kube-system outside of a cluster
+func currentNamespace() string {
+	if data, err := os.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace"); err == nil {
+		if ns := strings.TrimSpace(string(data)); ns != "" {
+			return ns
+		}
+	}
+	return "kube-system"
+}
+
 // Validate validates the configuration
 func (c *Config) Validate() error {
-	// Skip Git validation if in dump-only mode
-	if c.DumpOnly {
+	if c.LivenessFactor != 0 && c.LivenessFactor < 1 {
+		return fmt.Errorf("LIVENESS_INTERVAL_FACTOR must be at least 1")
+	}
+
+	if c.LogFormat != "" && c.LogFormat != "text" && c.LogFormat != "json" {
+		return fmt.Errorf("LOG_FORMAT must be either 'text' or 'json'")
+	}
+	if c.LogLevel != "" {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+			return fmt.Errorf("LOG_LEVEL must be one of 'debug', 'info', 'warn' or 'error'")
+		}
+	}
+
+	if c.BackupSchedule != "" {
+		if _, err := cron.ParseStandard(c.BackupSchedule); err != nil {
+			return fmt.Errorf("invalid BACKUP_SCHEDULE: %v", err)
+		}
+	}
+	if c.BackupTimezone != "" {
+		if _, err := time.LoadLocation(c.BackupTimezone); err != nil {
+			return fmt.Errorf("invalid BACKUP_TIMEZONE: %v", err)
+		}
+	}
+	if c.BackupJitter < 0 {
+		return fmt.Errorf("BACKUP_JITTER must not be negative")
+	}
+
+	if c.WatchMode && c.WatchDebounce <= 0 {
+		return fmt.Errorf("WATCH_DEBOUNCE must be positive")
+	}
+	if c.Git.Timeout < 0 {
+		return fmt.Errorf("GIT_TIMEOUT must not be negative")
+	}
+
+	switch c.ErrorPolicy {
+	case "", ErrorPolicyKeep, ErrorPolicyAbort:
+	default:
+		return fmt.Errorf("COLLECTION_ERROR_POLICY must be either 'keep' or 'abort'")
+	}
+	if c.ErrorPolicy == ErrorPolicyKeep && c.hasSnapshotSink() {
+		return fmt.Errorf("COLLECTION_ERROR_POLICY 'keep' is not supported with the s3, archive and oci sinks, " +
+			"whose snapshots would lose the failed types; use 'abort'")
+	}
+
+	if c.Kubernetes.QPS < 0 || c.Kubernetes.Burst < 0 {
+		return fmt.Errorf("KUBE_QPS and KUBE_BURST must not be negative")
+	}
+	if c.Kubernetes.ListPageSize < 0 {
+		return fmt.Errorf("KUBE_LIST_PAGE_SIZE must not be negative")
+	}
+	if c.Kubernetes.CollectWorkers < 0 {
+		return fmt.Errorf("COLLECT_WORKERS must not be negative")
+	}
+	if len(c.Kubernetes.ImpersonateGroups) > 0 && c.Kubernetes.ImpersonateUser == "" {
+		return fmt.Errorf("KUBE_IMPERSONATE_GROUPS requires KUBE_IMPERSONATE_USER")
+	}
+
+	if le := c.LeaderElection; le.Enabled {
+		if le.Namespace == "" || le.LeaseName == "" {
+			return fmt.Errorf("LEADER_ELECTION_NAMESPACE and LEADER_ELECTION_LEASE_NAME are required for leader election")
+		}
+		if le.LeaseDuration <= le.RenewDeadline {
+			return fmt.Errorf("LEADER_ELECTION_LEASE_DURATION must be greater than LEADER_ELECTION_RENEW_DEADLINE")
+		}
+		if le.RetryPeriod <= 0 || le.RenewDeadline <= le.RetryPeriod {
+			return fmt.Errorf("LEADER_ELECTION_RENEW_DEADLINE must be greater than LEADER_ELECTION_RETRY_PERIOD")
+		}
+	}
+
+	for _, field := range c.Sanitizer.StripFields {
+		if _, err := ParseFieldPath(field.Path); err != nil {
+			return fmt.Errorf("invalid STRIP_FIELDS entry '%s': %v", field, err)
+		}
+	}
+
+	switch c.Sanitizer.SecretMode {
+	case "", SecretModePlain:
+	case SecretModeRedact:
+		if c.Sanitizer.RedactKey == "" {
+			return fmt.Errorf("REDACT_KEY is required when SECRET_MODE is 'redact'")
+		}
+	case SecretModeSOPS:
+		if len(c.Sanitizer.AgeRecipients) == 0 {
+			return fmt.Errorf("SOPS_AGE_RECIPIENTS is required when SECRET_MODE is 'sops'")
+		}
+		for _, recipient := range c.Sanitizer.AgeRecipients {
+			if _, err := age.ParseX25519Recipient(recipient); err != nil {
+				return fmt.Errorf("invalid SOPS_AGE_RECIPIENTS entry '%s': %v", recipient, err)
+			}
+		}
+	default:
+		return fmt.Errorf("SECRET_MODE must be one of 'plain', 'sops' or 'redact'")
+	}
+
+	for _, name := range c.Sinks {
+		switch name {
+		case SinkGit, SinkLocal, SinkS3, SinkArchive, SinkOCI:
+		default:
+			return fmt.Errorf("unknown sink '%s' in SINKS, must be 'git', 'local', 's3', 'archive' or 'oci'", name)
+		}
+	}
+	if c.HasSink(SinkS3) {
+		if c.S3.Bucket == "" {
+			return fmt.Errorf("S3_BUCKET is required for the s3 sink")
+		}
+		if c.S3.Format != S3FormatObjects && c.S3.Format != S3FormatTarball {
+			return fmt.Errorf("S3_FORMAT must be either 'objects' or 'tarball'")
+		}
+		if (c.S3.AccessKeyID == "") != (c.S3.SecretAccessKey == "") {
+			return fmt.Errorf("S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY must be set together")
+		}
+	}
+	switch c.PathLayout {
+	case "", PathLayoutDefault, PathLayoutGrouped:
+	default:
+		return fmt.Errorf("PATH_LAYOUT must be either 'default' or 'grouped'")
+	}
+	if c.PathLayout != "" && c.PathTemplate != "" {
+		return fmt.Errorf("PATH_LAYOUT and PATH_TEMPLATE must not be set together")
+	}
+
+	if c.HasSink(SinkArchive) {
+		if c.Archive.Compression != ArchiveCompressionGzip && c.Archive.Compression != ArchiveCompressionZstd {
+			return fmt.Errorf("ARCHIVE_COMPRESSION must be either 'gzip' or 'zstd'")
+		}
+		if c.Archive.RetentionCount < 0 || c.Archive.RetentionAge < 0 {
+			return fmt.Errorf("ARCHIVE_RETENTION_COUNT and ARCHIVE_RETENTION_AGE must not be negative")
+		}
+		if c.HasSink(SinkGit) && isWithin(c.Archive.Dir, c.WorkDir) {
+			return fmt.Errorf("ARCHIVE_DIR must be outside WORK_DIR when both the git and archive sinks are enabled")
+		}
+	}
+	if c.HasSink(SinkOCI) {
+		if c.OCI.Repository == "" {
+			return fmt.Errorf("OCI_REPOSITORY is required for the oci sink")
+		}
+		if (c.OCI.Username == "") != (c.OCI.Password == "") {
+			return fmt.Errorf("OCI_USERNAME and OCI_PASSWORD must be set together")
+		}
+	}
+	if err := c.validateTargets(); err != nil {
+		return err
+	}
+	if c.DumpOnly && c.HasSink(SinkGit) {
+		return fmt.Errorf("SINKS must not include 'git' when DUMP_ONLY is true")
+	}
+	if c.HasSink(SinkGit) && c.HasSink(SinkLocal) && filepath.Clean(c.LocalDir) == filepath.Clean(c.WorkDir) {
+		return fmt.Errorf("LOCAL_DIR must differ from WORK_DIR when both the git and local sinks are enabled")
+	}
+
+	// Skip Git validation without the git sink
+	if !c.HasSink(SinkGit) {
 		if c.BackupInterval < time.Minute {
 			return fmt.Errorf("BACKUP_INTERVAL must be at least 1 minute")
 		}
@@ -141,6 +688,176 @@ func (c *Config) Validate() error {
 	return nil
 }
 
+// validateTargets checks that the cluster targets have distinct names and
+// outputs. Directories must not be nested, as the backup of a cluster removes
+// every file below its directory that isn't one of its resources, and only
+// clusters with their own branch may write to the root of one.
+func (c *Config) validateTargets() error {
+	names := make(map[string]bool, len(c.Targets))
+	branches := make(map[string]string, len(c.Targets)) // Cluster writing to the root of each branch
+	var directories []string
+	sharedBranch := false
+
+	for _, target := range c.Targets {
+		if !clusterNamePattern.MatchString(target.Name) {
+			return fmt.Errorf("invalid cluster name '%s' in CLUSTERS_FILE, must be a lowercase DNS label", target.Name)
+		}
+		if names[target.Name] {
+			return fmt.Errorf("duplicate cluster '%s' in CLUSTERS_FILE", target.Name)
+		}
+		names[target.Name] = true
+
+		dir := target.OutputDirectory()
+		if dir == "." || path.Clean(dir) != dir || path.IsAbs(dir) || strings.HasPrefix(dir, ".") || strings.Contains(dir, "/.") {
+			return fmt.Errorf("invalid directory '%s' of cluster '%s', must be a relative path without hidden or parent directories", dir, target.Name)
+		}
+		for _, other := range directories {
+			if dir == other || strings.HasPrefix(dir, other+"/") || strings.HasPrefix(other, dir+"/") {
+				return fmt.Errorf("directory '%s' of cluster '%s' overlaps with directory '%s'", dir, target.Name, other)
+			}
+		}
+		directories = append(directories, dir)
+
+		if target.Branch == "" {
+			sharedBranch = true
+			continue
+		}
+		if other, ok := branches[target.Branch]; ok {
+			return fmt.Errorf("clusters '%s' and '%s' must not share branch '%s'", other, target.Name, target.Branch)
+		}
+		branches[target.Branch] = target.Name
+	}
+
+	if other, ok := branches[c.Git.Branch]; ok && sharedBranch {
+		return fmt.Errorf("branch of cluster '%s' must differ from GIT_BRANCH, which holds the directories of the other clusters", other)
+	}
+
+	return nil
+}
+
+// OutputDirectory returns the slash-separated directory the target's tree
+// is written to in the Git repository, local directory, archive directory
+// and S3 prefix
+func (t ClusterTarget) OutputDirectory() string {
+	if t.Directory == "" {
+		return t.Name
+	}
+	return t.Directory
+}
+
+// ForTarget returns the configuration of a single cluster target: its name,
+// kubeconfig and filters, and outputs below its own directory. Every
+// cluster gets its own working copy below WORK_DIR.
+func (c *Config) ForTarget(target ClusterTarget) *Config {
+	tc := *c
+	tc.Targets = nil
+	tc.ClusterName = target.Name
+	dir := target.OutputDirectory()
+
+	if target.Kubeconfig != "" {
+		tc.Kubernetes.Kubeconfig = target.Kubeconfig
+	}
+	if target.Context != "" {
+		tc.Kubernetes.Context = target.Context
+	}
+	if target.IncludeResources != nil {
+		tc.Kubernetes.IncludeResources = target.IncludeResources
+	}
+	if target.ExcludeResources != nil {
+		tc.Kubernetes.ExcludeResources = target.ExcludeResources
+	}
+	if target.IncludeNamespaces != nil {
+		tc.Kubernetes.IncludeNamespaces = target.IncludeNamespaces
+	}
+	if target.ExcludeNamespaces != nil {
+		tc.Kubernetes.ExcludeNamespaces = target.ExcludeNamespaces
+	}
+
+	tc.WorkDir = filepath.Join(c.WorkDir, target.Name)
+	if c.Git.CacheDir != "" {
+		tc.Git.CacheDir = filepath.Join(c.Git.CacheDir, target.Name)
+	}
+	if target.Branch != "" {
+		tc.Git.Branch = target.Branch
+	} else {
+		tc.Git.Directory = dir
+	}
+
+	tc.LocalDir = filepath.Join(c.LocalDir, filepath.FromSlash(dir))
+	tc.Archive.Dir = filepath.Join(c.Archive.Dir, filepath.FromSlash(dir))
+	tc.S3.Prefix = path.Join(c.S3.Prefix, dir)
+	if c.OCI.Repository != "" {
+		tc.OCI.Repository = c.OCI.Repository + "/" + target.Name
+	}
+
+	return &tc
+}
+
+// Clusters returns the configuration of every cluster to back up: one per
+// target, or the configuration itself without targets
+func (c *Config) Clusters() []*Config {
+	if len(c.Targets) == 0 {
+		return []*Config{c}
+	}
+
+	clusters := make([]*Config, 0, len(c.Targets))
+	for _, target := range c.Targets {
+		clusters = append(clusters, c.ForTarget(target))
+	}
+	return clusters
+}
+
+// Cluster returns the configuration of the named target
+func (c *Config) Cluster(name string) (*Config, error) {
+	for _, target := range c.Targets {
+		if target.Name == name {
+			return c.ForTarget(target), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown cluster '%s', not listed in CLUSTERS_FILE", name)
+}
+
+// HasSink reports whether the named output sink is enabled. Without any
+// sinks configured, the git sink is used, or the local sink in dump-only mode.
+func (c *Config) HasSink(name string) bool {
+	if len(c.Sinks) == 0 {
+		if c.DumpOnly {
+			return name == SinkLocal
+		}
+		return name == SinkGit
+	}
+
+	for _, sink := range c.Sinks {
+		if sink == name {
+			return true
+		}
+	}
+	return false
+}
+
+// hasSnapshotSink reports whether a sink writing complete snapshots, which
+// can't keep the files of a previous snapshot, is enabled
+func (c *Config) hasSnapshotSink() bool {
+	return c.HasSink(SinkS3) || c.HasSink(SinkArchive) || c.HasSink(SinkOCI)
+}
+
+// isWithin reports whether path is dir or one of its subdirectories
+func isWithin(path, dir string) bool {
+	rel, err := filepath.Rel(filepath.Clean(dir), filepath.Clean(path))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// LivenessThreshold returns how long the daemon may go without a successful
+// backup before the liveness probe fails, given the longest gap between
+// scheduled backups
+func (c *Config) LivenessThreshold(period time.Duration) time.Duration {
+	factor := c.LivenessFactor
+	if factor == 0 {
+		factor = 3
+	}
+	return time.Duration(factor * float64(period))
+}
+
 // getEnvOrDefault returns the environment variable value or a default value
 func getEnvOrDefault(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
@@ -164,6 +881,95 @@ func parseCommaSeparated(s string) []string {
 	return result
 }
 
+// parseStripFields parses STRIP_FIELDS entries, each optionally prefixed
+// with a kind, e.g. "Deployment:spec.template.metadata.annotations[foo]"
+func parseStripFields(s string) []StripField {
+	var fields []StripField
+	for _, entry := range parseCommaSeparated(s) {
+		field := StripField{Path: entry}
+		if idx := strings.Index(entry, ":"); idx > 0 && !strings.ContainsAny(entry[:idx], ".[") {
+			field.Kind = entry[:idx]
+			field.Path = entry[idx+1:]
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// String returns the STRIP_FIELDS representation of the field
+func (f StripField) String() string {
+	if f.Kind == "" {
+		return f.Path
+	}
+	return f.Kind + ":" + f.Path
+}
+
+// ParseFieldPath splits a field path into its segments. Segments are separated
+// by dots outside of brackets and take one of the forms "field", "field[]"
+// (every item of a list, not allowed last) or "field[key]" (a single map key,
+// only allowed last), e.g. "metadata.annotations[kubectl.kubernetes.io/last-applied-configuration]".
+func ParseFieldPath(path string) ([]string, error) {
+	if path == "" {
+		return nil, fmt.Errorf("empty field path")
+	}
+
+	var segments []string
+	start := 0
+	inBrackets := false
+	for i, c := range path {
+		switch c {
+		case '[':
+			if inBrackets {
+				return nil, fmt.Errorf("nested '[' at position %d", i)
+			}
+			inBrackets = true
+		case ']':
+			if !inBrackets {
+				return nil, fmt.Errorf("unexpected ']' at position %d", i)
+			}
+			inBrackets = false
+		case '.':
+			if !inBrackets {
+				segments = append(segments, path[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if inBrackets {
+		return nil, fmt.Errorf("unterminated '['")
+	}
+	segments = append(segments, path[start:])
+
+	for i, segment := range segments {
+		last := i == len(segments)-1
+
+		open := strings.Index(segment, "[")
+		if open == -1 {
+			if segment == "" {
+				return nil, fmt.Errorf("empty segment")
+			}
+			continue
+		}
+
+		if open == 0 {
+			return nil, fmt.Errorf("segment '%s' is missing a field name", segment)
+		}
+		if !strings.HasSuffix(segment, "]") || strings.Count(segment, "[") != 1 {
+			return nil, fmt.Errorf("malformed segment '%s'", segment)
+		}
+
+		key := segment[open+1 : len(segment)-1]
+		if key == "" && last {
+			return nil, fmt.Errorf("list segment '%s' must be followed by a field", segment)
+		}
+		if key != "" && !last {
+			return nil, fmt.Errorf("key segment '%s' must be the last segment", segment)
+		}
+	}
+
+	return segments, nil
+}
+
 // loadEnvFile loads environment variables from .env file if it exists
 func loadEnvFile() {
 	file, err := os.Open(".env")
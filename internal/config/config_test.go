@@ -2,6 +2,8 @@ package config
 
 import (
 	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -143,6 +145,392 @@ func TestValidate(t *testing.T) {
 			expectError: true,
 			errorMsg:    "BACKUP_INTERVAL must be at least 1 minute",
 		},
+		{
+			name: "invalid strip field",
+			config: &Config{
+				BackupInterval: time.Hour,
+				DumpOnly:       true,
+				Sanitizer: SanitizerConfig{
+					StripFields: []StripField{{Kind: "Deployment", Path: "spec.ports[]"}},
+				},
+			},
+			expectError: true,
+			errorMsg:    "invalid STRIP_FIELDS entry 'Deployment:spec.ports[]': list segment 'ports[]' must be followed by a field",
+		},
+		{
+			name: "sops without recipients",
+			config: &Config{
+				BackupInterval: time.Hour,
+				DumpOnly:       true,
+				Sanitizer: SanitizerConfig{
+					SecretMode: SecretModeSOPS,
+				},
+			},
+			expectError: true,
+			errorMsg:    "SOPS_AGE_RECIPIENTS is required when SECRET_MODE is 'sops'",
+		},
+		{
+			name: "redact without key",
+			config: &Config{
+				BackupInterval: time.Hour,
+				DumpOnly:       true,
+				Sanitizer: SanitizerConfig{
+					SecretMode: SecretModeRedact,
+				},
+			},
+			expectError: true,
+			errorMsg:    "REDACT_KEY is required when SECRET_MODE is 'redact'",
+		},
+		{
+			name: "invalid secret mode",
+			config: &Config{
+				BackupInterval: time.Hour,
+				DumpOnly:       true,
+				Sanitizer: SanitizerConfig{
+					SecretMode: "base64",
+				},
+			},
+			expectError: true,
+			errorMsg:    "SECRET_MODE must be one of 'plain', 'sops' or 'redact'",
+		},
+		{
+			name: "liveness factor below one",
+			config: &Config{
+				BackupInterval: time.Hour,
+				DumpOnly:       true,
+				LivenessFactor: 0.5,
+			},
+			expectError: true,
+			errorMsg:    "LIVENESS_INTERVAL_FACTOR must be at least 1",
+		},
+		{
+			name: "unknown sink",
+			config: &Config{
+				BackupInterval: time.Hour,
+				DumpOnly:       true,
+				Sinks:          []string{SinkLocal, "ftp"},
+			},
+			expectError: true,
+			errorMsg:    "unknown sink 'ftp' in SINKS, must be 'git', 'local', 's3', 'archive' or 'oci'",
+		},
+		{
+			name: "git and local sinks sharing a directory",
+			config: &Config{
+				BackupInterval: time.Hour,
+				WorkDir:        "/tmp/kube-backup",
+				LocalDir:       "/tmp/kube-backup/",
+				Sinks:          []string{SinkGit, SinkLocal},
+				Git: GitConfig{
+					Repository: "git@github.com:test/repo.git",
+					AuthMethod: "ssh",
+					SSHKeyPath: "/path/to/key",
+				},
+			},
+			expectError: true,
+			errorMsg:    "LOCAL_DIR must differ from WORK_DIR when both the git and local sinks are enabled",
+		},
+		{
+			name: "s3 sink without bucket",
+			config: &Config{
+				BackupInterval: time.Hour,
+				Sinks:          []string{SinkS3},
+				S3:             S3Config{Format: S3FormatObjects},
+			},
+			expectError: true,
+			errorMsg:    "S3_BUCKET is required for the s3 sink",
+		},
+		{
+			name: "invalid s3 format",
+			config: &Config{
+				BackupInterval: time.Hour,
+				Sinks:          []string{SinkS3},
+				S3:             S3Config{Bucket: "backups", Format: "zip"},
+			},
+			expectError: true,
+			errorMsg:    "S3_FORMAT must be either 'objects' or 'tarball'",
+		},
+		{
+			name: "invalid archive compression",
+			config: &Config{
+				BackupInterval: time.Hour,
+				Sinks:          []string{SinkArchive},
+				Archive:        ArchiveConfig{Dir: "/backups", Compression: "bzip2"},
+			},
+			expectError: true,
+			errorMsg:    "ARCHIVE_COMPRESSION must be either 'gzip' or 'zstd'",
+		},
+		{
+			name: "negative archive retention",
+			config: &Config{
+				BackupInterval: time.Hour,
+				Sinks:          []string{SinkArchive},
+				Archive:        ArchiveConfig{Dir: "/backups", Compression: ArchiveCompressionZstd, RetentionCount: -1},
+			},
+			expectError: true,
+			errorMsg:    "ARCHIVE_RETENTION_COUNT and ARCHIVE_RETENTION_AGE must not be negative",
+		},
+		{
+			name: "archive directory inside the git working directory",
+			config: &Config{
+				BackupInterval: time.Hour,
+				WorkDir:        "/tmp/kube-backup",
+				Sinks:          []string{SinkGit, SinkArchive},
+				Archive:        ArchiveConfig{Dir: "/tmp/kube-backup/archives", Compression: ArchiveCompressionGzip},
+				Git: GitConfig{
+					Repository: "git@github.com:test/repo.git",
+					AuthMethod: "ssh",
+					SSHKeyPath: "/path/to/key",
+				},
+			},
+			expectError: true,
+			errorMsg:    "ARCHIVE_DIR must be outside WORK_DIR when both the git and archive sinks are enabled",
+		},
+		{
+			name: "archive sink next to git",
+			config: &Config{
+				BackupInterval: time.Hour,
+				WorkDir:        "/tmp/kube-backup",
+				Sinks:          []string{SinkGit, SinkArchive},
+				Archive:        ArchiveConfig{Dir: "/tmp/kube-backup-archives", Compression: ArchiveCompressionGzip, RetentionCount: 7},
+				Git: GitConfig{
+					Repository: "git@github.com:test/repo.git",
+					AuthMethod: "ssh",
+					SSHKeyPath: "/path/to/key",
+				},
+			},
+			expectError: false,
+		},
+		{
+			name: "oci sink without repository",
+			config: &Config{
+				BackupInterval: time.Hour,
+				Sinks:          []string{SinkOCI},
+			},
+			expectError: true,
+			errorMsg:    "OCI_REPOSITORY is required for the oci sink",
+		},
+		{
+			name: "oci username without password",
+			config: &Config{
+				BackupInterval: time.Hour,
+				Sinks:          []string{SinkOCI},
+				OCI:            OCIConfig{Repository: "localhost:5000/backups", Username: "backup"},
+			},
+			expectError: true,
+			errorMsg:    "OCI_USERNAME and OCI_PASSWORD must be set together",
+		},
+		{
+			name: "invalid path layout",
+			config: &Config{
+				BackupInterval: time.Hour,
+				DumpOnly:       true,
+				PathLayout:     "flat",
+			},
+			expectError: true,
+			errorMsg:    "PATH_LAYOUT must be either 'default' or 'grouped'",
+		},
+		{
+			name: "path layout and template",
+			config: &Config{
+				BackupInterval: time.Hour,
+				DumpOnly:       true,
+				PathLayout:     PathLayoutGrouped,
+				PathTemplate:   "{{.Kind}}/{{.Name}}.yaml",
+			},
+			expectError: true,
+			errorMsg:    "PATH_LAYOUT and PATH_TEMPLATE must not be set together",
+		},
+		{
+			name: "local sink without git configuration",
+			config: &Config{
+				BackupInterval: time.Hour,
+				Sinks:          []string{SinkLocal},
+			},
+			expectError: false,
+		},
+		{
+			name: "invalid log format",
+			config: &Config{
+				BackupInterval: time.Hour,
+				DumpOnly:       true,
+				LogFormat:      "logfmt",
+			},
+			expectError: true,
+			errorMsg:    "LOG_FORMAT must be either 'text' or 'json'",
+		},
+		{
+			name: "invalid log level",
+			config: &Config{
+				BackupInterval: time.Hour,
+				DumpOnly:       true,
+				LogLevel:       "verbose",
+			},
+			expectError: true,
+			errorMsg:    "LOG_LEVEL must be one of 'debug', 'info', 'warn' or 'error'",
+		},
+		{
+			name: "invalid backup schedule",
+			config: &Config{
+				BackupInterval: time.Hour,
+				BackupSchedule: "every night",
+				DumpOnly:       true,
+			},
+			expectError: true,
+			errorMsg:    "invalid BACKUP_SCHEDULE: expected exactly 5 fields, found 2: [every night]",
+		},
+		{
+			name: "lease duration not above renew deadline",
+			config: &Config{
+				BackupInterval: time.Hour,
+				DumpOnly:       true,
+				LeaderElection: LeaderElectionConfig{
+					Enabled:       true,
+					Namespace:     "kube-system",
+					LeaseName:     "kube-git-backup",
+					LeaseDuration: 10 * time.Second,
+					RenewDeadline: 10 * time.Second,
+					RetryPeriod:   2 * time.Second,
+				},
+			},
+			expectError: true,
+			errorMsg:    "LEADER_ELECTION_LEASE_DURATION must be greater than LEADER_ELECTION_RENEW_DEADLINE",
+		},
+		{
+			name: "negative client QPS",
+			config: &Config{
+				BackupInterval: time.Hour,
+				DumpOnly:       true,
+				Kubernetes:     KubernetesConfig{QPS: -1},
+			},
+			expectError: true,
+			errorMsg:    "KUBE_QPS and KUBE_BURST must not be negative",
+		},
+		{
+			name: "negative list page size",
+			config: &Config{
+				BackupInterval: time.Hour,
+				DumpOnly:       true,
+				Kubernetes:     KubernetesConfig{ListPageSize: -1},
+			},
+			expectError: true,
+			errorMsg:    "KUBE_LIST_PAGE_SIZE must not be negative",
+		},
+		{
+			name: "invalid collection error policy",
+			config: &Config{
+				BackupInterval: time.Hour,
+				DumpOnly:       true,
+				ErrorPolicy:    "ignore",
+			},
+			expectError: true,
+			errorMsg:    "COLLECTION_ERROR_POLICY must be either 'keep' or 'abort'",
+		},
+		{
+			name: "keep collection error policy with snapshot sinks",
+			config: &Config{
+				BackupInterval: time.Hour,
+				DumpOnly:       true,
+				Sinks:          []string{SinkLocal, SinkArchive},
+				Archive:        ArchiveConfig{Dir: "/backups"},
+				ErrorPolicy:    ErrorPolicyKeep,
+			},
+			expectError: true,
+			errorMsg:    "COLLECTION_ERROR_POLICY 'keep' is not supported with the s3, archive and oci sinks, whose snapshots would lose the failed types; use 'abort'",
+		},
+		{
+			name: "negative collect workers",
+			config: &Config{
+				BackupInterval: time.Hour,
+				DumpOnly:       true,
+				Kubernetes:     KubernetesConfig{CollectWorkers: -1},
+			},
+			expectError: true,
+			errorMsg:    "COLLECT_WORKERS must not be negative",
+		},
+		{
+			name: "impersonated groups without user",
+			config: &Config{
+				BackupInterval: time.Hour,
+				DumpOnly:       true,
+				Kubernetes:     KubernetesConfig{ImpersonateGroups: []string{"auditors"}},
+			},
+			expectError: true,
+			errorMsg:    "KUBE_IMPERSONATE_GROUPS requires KUBE_IMPERSONATE_USER",
+		},
+		{
+			name: "valid cluster targets",
+			config: &Config{
+				BackupInterval: time.Hour,
+				DumpOnly:       true,
+				Targets: []ClusterTarget{
+					{Name: "production"},
+					{Name: "staging", Directory: "clusters/staging"},
+					{Name: "edge", Branch: "edge"},
+				},
+			},
+			expectError: false,
+		},
+		{
+			name: "invalid cluster name",
+			config: &Config{
+				BackupInterval: time.Hour,
+				DumpOnly:       true,
+				Targets:        []ClusterTarget{{Name: "Production"}},
+			},
+			expectError: true,
+			errorMsg:    "invalid cluster name 'Production' in CLUSTERS_FILE, must be a lowercase DNS label",
+		},
+		{
+			name: "duplicate cluster",
+			config: &Config{
+				BackupInterval: time.Hour,
+				DumpOnly:       true,
+				Targets:        []ClusterTarget{{Name: "production"}, {Name: "production", Directory: "prod"}},
+			},
+			expectError: true,
+			errorMsg:    "duplicate cluster 'production' in CLUSTERS_FILE",
+		},
+		{
+			name: "cluster directory escapes",
+			config: &Config{
+				BackupInterval: time.Hour,
+				DumpOnly:       true,
+				Targets:        []ClusterTarget{{Name: "production", Directory: "../production"}},
+			},
+			expectError: true,
+			errorMsg:    "invalid directory '../production' of cluster 'production', must be a relative path without hidden or parent directories",
+		},
+		{
+			name: "nested cluster directories",
+			config: &Config{
+				BackupInterval: time.Hour,
+				DumpOnly:       true,
+				Targets:        []ClusterTarget{{Name: "production"}, {Name: "canary", Directory: "production/canary"}},
+			},
+			expectError: true,
+			errorMsg:    "directory 'production/canary' of cluster 'canary' overlaps with directory 'production'",
+		},
+		{
+			name: "shared cluster branch",
+			config: &Config{
+				BackupInterval: time.Hour,
+				DumpOnly:       true,
+				Targets:        []ClusterTarget{{Name: "production", Branch: "clusters"}, {Name: "staging", Branch: "clusters"}},
+			},
+			expectError: true,
+			errorMsg:    "clusters 'production' and 'staging' must not share branch 'clusters'",
+		},
+		{
+			name: "cluster branch is GIT_BRANCH",
+			config: &Config{
+				BackupInterval: time.Hour,
+				DumpOnly:       true,
+				Git:            GitConfig{Branch: "main"},
+				Targets:        []ClusterTarget{{Name: "production", Branch: "main"}, {Name: "staging"}},
+			},
+			expectError: true,
+			errorMsg:    "branch of cluster 'production' must differ from GIT_BRANCH, which holds the directories of the other clusters",
+		},
 	}
 
 	for _, tt := range tests {
@@ -163,6 +551,181 @@ func TestValidate(t *testing.T) {
 	}
 }
 
+func TestLoadTargets(t *testing.T) {
+	clustersFile := filepath.Join(t.TempDir(), "clusters.yaml")
+	content := `clusters:
+- name: production
+  kubeconfig: /etc/kube-git-backup/production.kubeconfig
+  context: admin@production
+  includeNamespaces: [shop, payments]
+- name: staging
+  directory: clusters/staging
+  excludeResources: []
+`
+	if err := os.WriteFile(clustersFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CLUSTERS_FILE", clustersFile)
+	t.Setenv("WORK_DIR", "/data/work")
+	t.Setenv("S3_BUCKET", "backups")
+	t.Setenv("OCI_REPOSITORY", "registry.example.com/backups")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	}
+	if len(cfg.Targets) != 2 {
+		t.Fatalf("Expected 2 targets, got %d", len(cfg.Targets))
+	}
+
+	clusters := cfg.Clusters()
+	production, staging := clusters[0], clusters[1]
+
+	if production.ClusterName != "production" || production.Kubernetes.Context != "admin@production" ||
+		production.Kubernetes.Kubeconfig != "/etc/kube-git-backup/production.kubeconfig" {
+		t.Errorf("Unexpected production cluster: %+v", production.Kubernetes)
+	}
+	if !reflect.DeepEqual(production.Kubernetes.IncludeNamespaces, []string{"shop", "payments"}) {
+		t.Errorf("Expected the namespaces of the target, got %v", production.Kubernetes.IncludeNamespaces)
+	}
+	if !reflect.DeepEqual(production.Kubernetes.ExcludeResources, cfg.Kubernetes.ExcludeResources) {
+		t.Errorf("Expected the excluded resources of the environment, got %v", production.Kubernetes.ExcludeResources)
+	}
+	if len(staging.Kubernetes.ExcludeResources) != 0 {
+		t.Errorf("Expected an empty list to override EXCLUDE_RESOURCES, got %v", staging.Kubernetes.ExcludeResources)
+	}
+
+	expected := map[string][2]string{
+		"work dir":       {production.WorkDir, "/data/work/production"},
+		"git directory":  {staging.Git.Directory, "clusters/staging"},
+		"local dir":      {staging.LocalDir, "/data/work/clusters/staging"},
+		"s3 prefix":      {staging.S3.Prefix, "clusters/staging"},
+		"oci repository": {staging.OCI.Repository, "registry.example.com/backups/staging"},
+	}
+	for name, values := range expected {
+		if values[0] != values[1] {
+			t.Errorf("Expected %s %s, got %s", name, values[1], values[0])
+		}
+	}
+
+	if _, err := cfg.Cluster("development"); err == nil {
+		t.Error("Expected an error for an unknown cluster")
+	}
+
+	// Targets with their own branch are written to its root
+	branched := cfg.ForTarget(ClusterTarget{Name: "edge", Branch: "edge"})
+	if branched.Git.Branch != "edge" || branched.Git.Directory != "" || branched.LocalDir != "/data/work/edge" {
+		t.Errorf("Unexpected branch target: %+v", branched.Git)
+	}
+}
+
+func TestLoadClientSettings(t *testing.T) {
+	t.Setenv("KUBECONFIG", "/etc/kube/a:/etc/kube/b")
+	t.Setenv("KUBE_CONTEXT", "admin@production")
+	t.Setenv("KUBE_IMPERSONATE_USER", "backup-reader")
+	t.Setenv("KUBE_IMPERSONATE_GROUPS", "auditors, readers")
+	t.Setenv("KUBE_QPS", "50")
+	t.Setenv("KUBE_BURST", "100")
+	t.Setenv("KUBE_LIST_PAGE_SIZE", "250")
+	t.Setenv("COLLECT_WORKERS", "8")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	expected := KubernetesConfig{
+		Kubeconfig:        "/etc/kube/a:/etc/kube/b",
+		Context:           "admin@production",
+		ImpersonateUser:   "backup-reader",
+		ImpersonateGroups: []string{"auditors", "readers"},
+		QPS:               50,
+		Burst:             100,
+		ListPageSize:      250,
+		CollectWorkers:    8,
+	}
+	got := cfg.Kubernetes
+	got.IncludeResources, got.ExcludeResources, got.IncludeNamespaces, got.ExcludeNamespaces = nil, nil, nil, nil
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, got)
+	}
+
+	// Targets inherit the client settings unless they set their own
+	target := cfg.ForTarget(ClusterTarget{Name: "staging", Context: "admin@staging"})
+	if target.Kubernetes.Kubeconfig != expected.Kubeconfig || target.Kubernetes.Context != "admin@staging" ||
+		target.Kubernetes.ImpersonateUser != "backup-reader" {
+		t.Errorf("Unexpected target client settings: %+v", target.Kubernetes)
+	}
+
+	t.Setenv("KUBE_QPS", "fast")
+	if _, err := Load(); err == nil {
+		t.Error("Expected an error for an invalid KUBE_QPS")
+	}
+}
+
+func TestLoadDiscoveryExcludes(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	}
+	if !reflect.DeepEqual(cfg.Kubernetes.ExcludeResources, []string{"pods", "events", "endpoints", "replicasets"}) {
+		t.Errorf("Unexpected default exclusions: %v", cfg.Kubernetes.ExcludeResources)
+	}
+
+	// Discovery also leaves out volatile types
+	t.Setenv("DISCOVERY_MODE", "true")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	}
+	expected := []string{"pods", "events", "endpoints", "replicasets", "leases", "endpointslices", "controllerrevisions", "metrics.k8s.io/*"}
+	if !reflect.DeepEqual(cfg.Kubernetes.ExcludeResources, expected) {
+		t.Errorf("Expected %v in discovery mode, got %v", expected, cfg.Kubernetes.ExcludeResources)
+	}
+
+	// Unless they are set explicitly
+	t.Setenv("EXCLUDE_RESOURCES", "events")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	}
+	if !reflect.DeepEqual(cfg.Kubernetes.ExcludeResources, []string{"events"}) {
+		t.Errorf("Expected EXCLUDE_RESOURCES to be used, got %v", cfg.Kubernetes.ExcludeResources)
+	}
+}
+
+func TestLoadErrorPolicy(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	}
+	if cfg.ErrorPolicy != ErrorPolicyKeep {
+		t.Errorf("Expected the git sink to keep the files of failed types, got %s", cfg.ErrorPolicy)
+	}
+
+	// Snapshot sinks can't keep them, so runs with failed types are aborted
+	t.Setenv("SINKS", "git,s3")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	}
+	if cfg.ErrorPolicy != ErrorPolicyAbort {
+		t.Errorf("Expected the abort policy with the s3 sink, got %s", cfg.ErrorPolicy)
+	}
+}
+
+func TestLoadTargetsInvalid(t *testing.T) {
+	clustersFile := filepath.Join(t.TempDir(), "clusters.yaml")
+	if err := os.WriteFile(clustersFile, []byte("clusters:\n- name: production\n  namespaces: [shop]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CLUSTERS_FILE", clustersFile)
+
+	if _, err := Load(); err == nil {
+		t.Error("Expected an error for an unknown field")
+	}
+}
+
 func TestParseCommaSeparated(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -188,3 +751,68 @@ func TestParseCommaSeparated(t *testing.T) {
 		}
 	}
 }
+
+func TestParseStripFields(t *testing.T) {
+	fields := parseStripFields("status, Deployment:spec.template.metadata.annotations[foo],metadata.annotations[kubectl.kubernetes.io/last-applied-configuration]")
+
+	expected := []StripField{
+		{Path: "status"},
+		{Kind: "Deployment", Path: "spec.template.metadata.annotations[foo]"},
+		{Path: "metadata.annotations[kubectl.kubernetes.io/last-applied-configuration]"},
+	}
+
+	if len(fields) != len(expected) {
+		t.Fatalf("Expected %d strip fields, got %d", len(expected), len(fields))
+	}
+	for i, field := range expected {
+		if fields[i] != field {
+			t.Errorf("Expected strip field %+v, got %+v", field, fields[i])
+		}
+	}
+}
+
+func TestParseFieldPath(t *testing.T) {
+	tests := []struct {
+		path        string
+		expected    []string
+		expectError bool
+	}{
+		{path: "status", expected: []string{"status"}},
+		{path: "spec.ports[].nodePort", expected: []string{"spec", "ports[]", "nodePort"}},
+		{
+			path:     "metadata.annotations[kubectl.kubernetes.io/last-applied-configuration]",
+			expected: []string{"metadata", "annotations[kubectl.kubernetes.io/last-applied-configuration]"},
+		},
+		{path: "", expectError: true},
+		{path: "metadata..uid", expectError: true},
+		{path: "metadata.", expectError: true},
+		{path: "spec.ports[]", expectError: true},
+		{path: "metadata.annotations[foo].bar", expectError: true},
+		{path: "metadata.annotations[foo", expectError: true},
+		{path: "metadata.annotations]", expectError: true},
+		{path: "metadata.[foo]", expectError: true},
+	}
+
+	for _, tt := range tests {
+		segments, err := ParseFieldPath(tt.path)
+		if tt.expectError {
+			if err == nil {
+				t.Errorf("For path '%s', expected error but got none", tt.path)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("For path '%s', expected no error but got: %v", tt.path, err)
+			continue
+		}
+		if len(segments) != len(tt.expected) {
+			t.Errorf("For path '%s', expected %d segments, got %d", tt.path, len(tt.expected), len(segments))
+			continue
+		}
+		for i, segment := range tt.expected {
+			if segments[i] != segment {
+				t.Errorf("For path '%s', expected segment %d to be '%s', got '%s'", tt.path, i, segment, segments[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package git
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"kube-git-backup/internal/sink"
+
+	"github.com/go-git/go-git/v5"
+)
+
+// maxListedChanges caps the number of resources listed in a commit message body
+const maxListedChanges = 1000
+
+// collectChanges builds the list of changed resources from the staged worktree status
+func (gm *Manager) collectChanges(status git.Status) []sink.ResourceChange {
+	var changes []sink.ResourceChange
+
+	for path, fileStatus := range status {
+		if !strings.HasSuffix(path, ".yaml") {
+			continue
+		}
+
+		var changeType string
+		switch fileStatus.Staging {
+		case git.Added:
+			changeType = sink.ChangeAdded
+		case git.Modified, git.Renamed, git.Copied:
+			changeType = sink.ChangeModified
+		case git.Deleted:
+			changeType = sink.ChangeDeleted
+		default:
+			continue
+		}
+
+		change := sink.ResourceChange{Type: changeType, Path: path}
+		if resource, ok := gm.written[path]; ok {
+			change.Namespace = resource.Namespace
+			change.Kind = resource.Kind
+			change.Name = resource.Name
+		} else {
+			gm.describeFromHead(&change)
+		}
+		changes = append(changes, change)
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+
+	return changes
+}
+
+// describeFromHead fills in the resource identity of a file that is no longer
+// written (i.e. deleted) from its last committed content, falling back to the path
+func (gm *Manager) describeFromHead(change *sink.ResourceChange) {
+	if head, err := gm.repository.Head(); err == nil {
+		if commit, err := gm.repository.CommitObject(head.Hash()); err == nil {
+			if file, err := commit.File(change.Path); err == nil {
+				if content, err := file.Contents(); err == nil {
+					sink.Describe(change, []byte(content))
+					return
+				}
+			}
+		}
+	}
+
+	sink.DescribePath(change)
+}
+
+// buildMigrationMessage describes a commit moving the files of the
+// repository to a new layout
+func buildMigrationMessage(clusterName, pathTemplate string, moves []sink.Move) string {
+	var msg strings.Builder
+	if len(moves) == 0 {
+		fmt.Fprintf(&msg, "Record the layout of %s\n\n", clusterName)
+	} else {
+		fmt.Fprintf(&msg, "Migrate %s to a new layout: %d moved\n\n", clusterName, len(moves))
+	}
+
+	fmt.Fprintf(&msg, "Path template: %s\n\n", pathTemplate)
+	for i, move := range moves {
+		if i == maxListedChanges {
+			fmt.Fprintf(&msg, "... and %d more\n", len(moves)-maxListedChanges)
+			break
+		}
+		fmt.Fprintf(&msg, "%s -> %s\n", move.From, move.To)
+	}
+	if len(moves) > 0 {
+		msg.WriteString("\n")
+	}
+
+	fmt.Fprintf(&msg, "Cluster: %s\n", clusterName)
+	fmt.Fprintf(&msg, "Resources-Moved: %d\n", len(moves))
+
+	return msg.String()
+}
+
+// buildCommitMessage summarizes the changes with a subject line, a body
+// listing every changed resource and machine-readable trailers
+func buildCommitMessage(clusterName string, changes []sink.ResourceChange) string {
+	counts := make(map[string]int)
+	for _, change := range changes {
+		counts[change.Type]++
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "Backup %s: %d added, %d modified, %d deleted\n\n",
+		clusterName, counts[sink.ChangeAdded], counts[sink.ChangeModified], counts[sink.ChangeDeleted])
+
+	for i, change := range changes {
+		if i == maxListedChanges {
+			fmt.Fprintf(&msg, "... and %d more\n", len(changes)-maxListedChanges)
+			break
+		}
+		fmt.Fprintf(&msg, "%-8s %s\n", change.Type, change)
+	}
+	if len(changes) > 0 {
+		msg.WriteString("\n")
+	}
+
+	fmt.Fprintf(&msg, "Cluster: %s\n", clusterName)
+	fmt.Fprintf(&msg, "Resources-Added: %d\n", counts[sink.ChangeAdded])
+	fmt.Fprintf(&msg, "Resources-Modified: %d\n", counts[sink.ChangeModified])
+	fmt.Fprintf(&msg, "Resources-Deleted: %d\n", counts[sink.ChangeDeleted])
+
+	return msg.String()
+}
@@ -0,0 +1,115 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"kube-git-backup/internal/sanitizer"
+	"kube-git-backup/internal/sink"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func TestBuildCommitMessage(t *testing.T) {
+	changes := []sink.ResourceChange{
+		{Type: sink.ChangeDeleted, Path: "cluster-scoped/storageclass/slow.yaml", Kind: "StorageClass", Name: "slow"},
+		{Type: sink.ChangeAdded, Path: "namespaces/prod/deployment/web.yaml", Namespace: "prod", Kind: "Deployment", Name: "web"},
+		{Type: sink.ChangeModified, Path: "namespaces/prod/configmap/settings.yaml", Namespace: "prod", Kind: "ConfigMap", Name: "settings"},
+	}
+
+	msg := buildCommitMessage("prod-eu", changes)
+
+	expected := `Backup prod-eu: 1 added, 1 modified, 1 deleted
+
+deleted  StorageClass/slow
+added    prod/Deployment/web
+modified prod/ConfigMap/settings
+
+Cluster: prod-eu
+Resources-Added: 1
+Resources-Modified: 1
+Resources-Deleted: 1
+`
+	if msg != expected {
+		t.Errorf("Unexpected commit message:\n%s\nexpected:\n%s", msg, expected)
+	}
+}
+
+func TestCollectChanges(t *testing.T) {
+	workDir := t.TempDir()
+
+	repo, err := git.PlainInit(workDir, false)
+	if err != nil {
+		t.Fatalf("Failed to init repository: %v", err)
+	}
+
+	// Commit a resource that will be deleted and one that will be modified
+	initial := map[string]string{
+		"namespaces/prod/service/old.yaml":        "apiVersion: v1\nkind: Service\nmetadata:\n  name: old\n  namespace: prod\n",
+		"namespaces/prod/configmap/settings.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\n  namespace: prod\n",
+	}
+	for path, content := range initial {
+		fullPath := filepath.Join(workDir, path)
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	workTree, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := workTree.Add("."); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := workTree.Commit("initial", &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	gm := &Manager{workDir: workDir, repository: repo}
+
+	resources := []sanitizer.SanitizedResource{
+		{APIVersion: "v1", Kind: "ConfigMap", Namespace: "prod", Name: "settings",
+			YAML: []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\n  namespace: prod\ndata:\n  key: value\n")},
+		{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "prod", Name: "web",
+			YAML: []byte("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: web\n  namespace: prod\n")},
+	}
+
+	if err := gm.CleanupOldBackups(t.Context(), resources); err != nil {
+		t.Fatalf("Failed to clean up: %v", err)
+	}
+	if err := gm.writeResources(resources); err != nil {
+		t.Fatalf("Failed to write resources: %v", err)
+	}
+	if err := gm.addChanges(); err != nil {
+		t.Fatalf("Failed to add changes: %v", err)
+	}
+
+	status, err := workTree.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, change := range gm.collectChanges(status) {
+		got = append(got, change.Type+" "+change.String())
+	}
+
+	expected := []string{
+		"modified prod/ConfigMap/settings",
+		"added prod/Deployment/web",
+		"deleted prod/Service/old",
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected changes:\n%s\nexpected:\n%s", strings.Join(got, "\n"), strings.Join(expected, "\n"))
+	}
+}
@@ -2,8 +2,11 @@ package git
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"time"
@@ -11,30 +14,60 @@ import (
 	"golang.org/x/crypto/ssh/knownhosts"
 
 	"kube-git-backup/internal/config"
+	"kube-git-backup/internal/logging"
+	"kube-git-backup/internal/metrics"
 	"kube-git-backup/internal/sanitizer"
+	"kube-git-backup/internal/sink"
 
+	"github.com/go-git/go-billy/v5/osfs"
 	"github.com/go-git/go-git/v5"
 	config2 "github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/http"
 	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/go-git/go-git/v5/storage/filesystem"
 )
 
 // Manager handles Git operations for backing up Kubernetes resources
 type Manager struct {
-	config     config.GitConfig
-	workDir    string
-	repository *git.Repository
-	auth       transport.AuthMethod
+	config      config.GitConfig
+	clusterName string
+	workDir     string       // Working copy of the backup repository
+	directory   string       // Slash-separated directory of the backup within the repository, empty for the root
+	cacheDir    string       // Git object and reference storage
+	layout      *sink.Layout // Paths of the resource files, the default layout when nil
+	repository  *git.Repository
+	auth        transport.AuthMethod
+	written     map[string]sanitizer.SanitizedResource // Files written by the current backup, by repository path
+	lock        *os.File                               // Held while the manager owns the working copy
+	restage     func(ctx context.Context) error        // Stages the current write again after a rejected push
+	runLogger   *slog.Logger                           // Logger of the current backup run
 }
 
-// NewManager creates a new Git manager
-func NewManager(cfg config.GitConfig) (*Manager, error) {
+// NewManager creates a new Git manager using WORK_DIR as the working copy
+// and GIT_CACHE_DIR (default: <WORK_DIR>/.git) as the Git storage. The
+// working copy stays locked until Close is called.
+func NewManager(cfg *config.Config) (*Manager, error) {
+	cacheDir := cfg.Git.CacheDir
+	if cacheDir == "" {
+		cacheDir = filepath.Join(cfg.WorkDir, ".git")
+	}
+
+	layout, err := sink.LayoutFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	manager := &Manager{
-		config:  cfg,
-		workDir: "/tmp/kube-backup",
+		config:      cfg.Git,
+		clusterName: cfg.ClusterName,
+		workDir:     cfg.WorkDir,
+		directory:   cfg.Git.Directory,
+		cacheDir:    cacheDir,
+		layout:      layout,
 	}
 
 	// Setup authentication
@@ -44,8 +77,14 @@ func NewManager(cfg config.GitConfig) (*Manager, error) {
 	}
 	manager.auth = auth
 
+	// Lock the working copy before touching it
+	if err := manager.lockWorkDir(); err != nil {
+		return nil, err
+	}
+
 	// Initialize repository
 	if err := manager.initRepository(); err != nil {
+		manager.Close()
 		return nil, fmt.Errorf("failed to initialize repository: %w", err)
 	}
 
@@ -92,24 +131,45 @@ func (gm *Manager) setupAuth() (transport.AuthMethod, error) {
 
 // initRepository initializes or clones the Git repository
 func (gm *Manager) initRepository() error {
-	// Create work directory if it doesn't exist
-	if err := os.MkdirAll(gm.workDir, 0755); err != nil {
-		return fmt.Errorf("failed to create work directory: %w", err)
+	// Create work and cache directories if they don't exist
+	for _, dir := range []string{gm.workDir, gm.cacheDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", dir, err)
+		}
 	}
 
+	storage := filesystem.NewStorage(osfs.New(gm.cacheDir), cache.NewObjectLRUDefault())
+	workTree := osfs.New(gm.workDir)
+
 	// Check if repository already exists
-	repo, err := git.PlainOpen(gm.workDir)
+	repo, err := git.Open(storage, workTree)
 	if err != nil {
 		// Repository doesn't exist, try to clone it
-		repo, err = git.PlainClone(gm.workDir, false, &git.CloneOptions{
-			URL:      gm.config.Repository,
-			Auth:     gm.auth,
-			Progress: os.Stdout,
-		})
+		cloneOptions := &git.CloneOptions{
+			URL:  gm.config.Repository,
+			Auth: gm.auth,
+		}
+		// Only ask the remote for progress messages when they are logged
+		if gm.logger().Enabled(context.Background(), slog.LevelDebug) {
+			cloneOptions.Progress = &progressWriter{logger: gm.logger()}
+		}
+		cloneCtx, cancel := gm.remoteContext(context.Background())
+		repo, err = git.CloneContext(cloneCtx, storage, workTree, cloneOptions)
+		cancel()
 		if err != nil {
 			// If clone fails due to empty repository, initialize a new one
 			if strings.Contains(err.Error(), "remote repository is empty") {
-				repo, err = git.PlainInit(gm.workDir, false)
+				// The failed clone leaves the remote configuration behind, so start
+				// from an empty cache directory
+				if err := os.RemoveAll(gm.cacheDir); err != nil {
+					return fmt.Errorf("failed to reset cache directory: %w", err)
+				}
+				if err := os.MkdirAll(gm.cacheDir, 0755); err != nil {
+					return fmt.Errorf("failed to create directory %s: %w", gm.cacheDir, err)
+				}
+				storage = filesystem.NewStorage(osfs.New(gm.cacheDir), cache.NewObjectLRUDefault())
+
+				repo, err = git.Init(storage, workTree)
 				if err != nil {
 					return fmt.Errorf("failed to initialize repository: %w", err)
 				}
@@ -142,7 +202,9 @@ func (gm *Manager) checkoutBranch() error {
 	}
 
 	// Try to fetch latest changes (skip if remote is empty)
-	err = gm.repository.Fetch(&git.FetchOptions{
+	fetchCtx, cancel := gm.remoteContext(context.Background())
+	defer cancel()
+	err = gm.repository.FetchContext(fetchCtx, &git.FetchOptions{
 		Auth: gm.auth,
 	})
 	if err != nil && err != git.NoErrAlreadyUpToDate && !strings.Contains(err.Error(), "remote repository is empty") {
@@ -166,8 +228,12 @@ func (gm *Manager) checkoutBranch() error {
 				Create: true,
 			})
 			if err != nil {
-				// If even creating fails, the repo might be completely empty
-				// We'll handle this in the first commit
+				// If even creating fails, the repo might be completely empty.
+				// Point HEAD at the branch so the first commit creates it.
+				head := plumbing.NewSymbolicReference(plumbing.HEAD, branchRef)
+				if err := gm.repository.Storer.SetReference(head); err != nil {
+					return fmt.Errorf("failed to point HEAD at branch: %w", err)
+				}
 				return nil
 			}
 		} else {
@@ -194,15 +260,30 @@ func (gm *Manager) checkoutBranch() error {
 	return nil
 }
 
-// BackupResources writes sanitized resources to the repository and commits them
-func (gm *Manager) BackupResources(ctx context.Context, resources []sanitizer.SanitizedResource) error {
+// Name returns the name of the sink
+func (gm *Manager) Name() string {
+	return "git"
+}
+
+// WriteSnapshot pulls the latest changes and stages the resources, removing
+// the files of resources that no longer exist in the cluster
+func (gm *Manager) WriteSnapshot(ctx context.Context, resources []sanitizer.SanitizedResource) error {
+	gm.runLogger = logging.FromContext(ctx)
+
 	// Pull latest changes first
-	if err := gm.pullLatestChanges(); err != nil {
+	if err := gm.pullLatestChanges(ctx); err != nil {
 		return fmt.Errorf("failed to pull latest changes: %w", err)
 	}
 
+	// Move the files of an earlier layout, so that the backup commit only
+	// holds the changes of the cluster
+	if err := gm.migrateLayout(); err != nil {
+		return fmt.Errorf("failed to migrate repository layout: %w", err)
+	}
+
 	// Clean up resources that no longer exist in cluster
-	if err := gm.cleanupDeletedResources(resources); err != nil {
+	writeStart := time.Now()
+	if err := gm.cleanupDeletedResources(ctx, resources); err != nil {
 		return fmt.Errorf("failed to cleanup deleted resources: %w", err)
 	}
 
@@ -215,51 +296,160 @@ func (gm *Manager) BackupResources(ctx context.Context, resources []sanitizer.Sa
 	if err := gm.addChanges(); err != nil {
 		return fmt.Errorf("failed to add changes: %w", err)
 	}
+	metrics.ObservePhase(gm.clusterName, metrics.PhaseWrite, writeStart)
+
+	gm.restage = func(ctx context.Context) error {
+		return gm.WriteSnapshot(ctx, resources)
+	}
+	return nil
+}
+
+// WriteChanges pulls the latest changes and stages only the given updated
+// and deleted resources, leaving every other file in the repository untouched
+func (gm *Manager) WriteChanges(ctx context.Context, updated, deleted []sanitizer.SanitizedResource) error {
+	gm.runLogger = logging.FromContext(ctx)
+
+	// Pull latest changes first
+	if err := gm.pullLatestChanges(ctx); err != nil {
+		return fmt.Errorf("failed to pull latest changes: %w", err)
+	}
+
+	writeStart := time.Now()
+	if err := gm.removeResources(deleted); err != nil {
+		return fmt.Errorf("failed to remove deleted resources: %w", err)
+	}
+
+	if err := gm.writeResources(updated); err != nil {
+		return fmt.Errorf("failed to write resources: %w", err)
+	}
+
+	if err := gm.addChanges(); err != nil {
+		return fmt.Errorf("failed to add changes: %w", err)
+	}
+	metrics.ObservePhase(gm.clusterName, metrics.PhaseWrite, writeStart)
+
+	gm.restage = func(ctx context.Context) error {
+		return gm.WriteChanges(ctx, updated, deleted)
+	}
+	return nil
+}
+
+// Finalize commits the staged changes and pushes them. The reference of the
+// result is the new commit, or empty if nothing changed. When the push is
+// rejected because the branch moved since the pull, the write is staged and
+// committed once more on top of the remote branch.
+func (gm *Manager) Finalize(ctx context.Context) (sink.Result, error) {
+	gm.runLogger = logging.FromContext(ctx)
+	restage := gm.restage
+	gm.restage = nil
 
 	// Commit changes
-	if err := gm.commitChanges(); err != nil {
-		return fmt.Errorf("failed to commit changes: %w", err)
+	commitStart := time.Now()
+	result, err := gm.commitChanges()
+	if err != nil {
+		return result, fmt.Errorf("failed to commit changes: %w", err)
 	}
+	metrics.ObservePhase(gm.clusterName, metrics.PhaseCommit, commitStart)
 
 	// Push changes
-	if err := gm.pushChanges(); err != nil {
-		return fmt.Errorf("failed to push changes: %w", err)
+	pushStart := time.Now()
+	err = gm.pushChanges(ctx)
+	if isNonFastForward(err) && restage != nil {
+		gm.logger().Warn("Remote branch moved since the pull, writing the changes again on top of it",
+			"branch", gm.config.Branch)
+		if err := restage(ctx); err != nil {
+			metrics.PushFailures.WithLabelValues(gm.clusterName).Inc()
+			return result, fmt.Errorf("failed to write again after a rejected push: %w", err)
+		}
+		gm.restage = nil
+
+		result, err = gm.commitChanges()
+		if err != nil {
+			return result, fmt.Errorf("failed to commit changes: %w", err)
+		}
+		err = gm.pushChanges(ctx)
 	}
+	if err != nil {
+		metrics.PushFailures.WithLabelValues(gm.clusterName).Inc()
+		return result, fmt.Errorf("failed to push changes: %w", err)
+	}
+	metrics.ObservePhase(gm.clusterName, metrics.PhasePush, pushStart)
 
-	return nil
+	return result, nil
 }
 
-// pullLatestChanges pulls the latest changes from remote
-func (gm *Manager) pullLatestChanges() error {
+// pullLatestChanges pulls the latest changes of the branch from remote. When
+// the local branch diverged, e.g. after a failed push while other clusters or
+// replicas pushed to the branch, it is reset to the remote branch, which is
+// safe as every write stages its resources again on top.
+func (gm *Manager) pullLatestChanges(ctx context.Context) error {
 	workTree, err := gm.repository.Worktree()
 	if err != nil {
 		return err
 	}
 
-	err = workTree.Pull(&git.PullOptions{
-		Auth: gm.auth,
+	ctx, cancel := gm.remoteContext(ctx)
+	defer cancel()
+	err = workTree.PullContext(ctx, &git.PullOptions{
+		ReferenceName: plumbing.NewBranchReferenceName(gm.config.Branch),
+		SingleBranch:  true,
+		Auth:          gm.auth,
 	})
-	if err != nil && err != git.NoErrAlreadyUpToDate && !strings.Contains(err.Error(), "remote repository is empty") {
+	switch {
+	case err == nil, errors.Is(err, git.NoErrAlreadyUpToDate):
+		return nil
+	case strings.Contains(err.Error(), "remote repository is empty"), errors.Is(err, plumbing.ErrReferenceNotFound):
+		// The branch doesn't exist on the remote yet, the first push creates it
+		return nil
+	case errors.Is(err, git.ErrNonFastForwardUpdate):
+		return gm.resetToRemote()
+	default:
 		return err
 	}
+}
+
+// resetToRemote resets the branch and the working copy to the fetched remote
+// branch, dropping local commits that were never pushed
+func (gm *Manager) resetToRemote() error {
+	remoteRef, err := gm.repository.Reference(plumbing.NewRemoteReferenceName("origin", gm.config.Branch), true)
+	if err != nil {
+		return fmt.Errorf("failed to resolve remote branch: %w", err)
+	}
 
+	workTree, err := gm.repository.Worktree()
+	if err != nil {
+		return err
+	}
+	if err := workTree.Reset(&git.ResetOptions{Commit: remoteRef.Hash(), Mode: git.HardReset}); err != nil {
+		return fmt.Errorf("failed to reset to remote branch: %w", err)
+	}
+
+	gm.logger().Warn("Local branch diverged from the remote, reset to the remote branch",
+		"branch", gm.config.Branch, "commit", remoteRef.Hash().String())
 	return nil
 }
 
 // writeResources writes sanitized resources to files in the repository
 func (gm *Manager) writeResources(resources []sanitizer.SanitizedResource) error {
-	// Create directory structure: namespace/kind/name.yaml
-	for _, resource := range resources {
-		var resourcePath string
+	gm.written = make(map[string]sanitizer.SanitizedResource, len(resources))
 
-		if resource.Namespace == "" {
-			// Cluster-scoped resource
-			resourcePath = filepath.Join(gm.workDir, "cluster-scoped",
-				strings.ToLower(resource.Kind), fmt.Sprintf("%s.yaml", resource.Name))
-		} else {
-			// Namespaced resource
-			resourcePath = filepath.Join(gm.workDir, "namespaces", resource.Namespace,
-				strings.ToLower(resource.Kind), fmt.Sprintf("%s.yaml", resource.Name))
+	paths, err := gm.pathLayout().Paths(resources)
+	if err != nil {
+		return err
+	}
+
+	for i, resource := range resources {
+		relPath := paths[i]
+		resourcePath := filepath.Join(gm.backupDir(), filepath.FromSlash(relPath))
+
+		gm.written[path.Join(gm.directory, relPath)] = resource
+
+		// Keep Secrets encrypted by an earlier process while they are unchanged
+		if resource.Kind == "Secret" {
+			existing, err := os.ReadFile(resourcePath)
+			if err == nil && sanitizer.SameSecret(existing, resource.YAML) {
+				continue
+			}
 		}
 
 		// Create directory if it doesn't exist
@@ -277,6 +467,22 @@ func (gm *Manager) writeResources(resources []sanitizer.SanitizedResource) error
 	return nil
 }
 
+// removeResources removes the files of deleted resources from the repository
+func (gm *Manager) removeResources(resources []sanitizer.SanitizedResource) error {
+	for _, resource := range resources {
+		relPath, err := gm.pathLayout().Path(resource)
+		if err != nil {
+			return err
+		}
+		resourcePath := filepath.Join(gm.backupDir(), filepath.FromSlash(relPath))
+		if err := os.Remove(resourcePath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove file %s: %w", resourcePath, err)
+		}
+	}
+
+	return nil
+}
+
 // addChanges adds all changes to Git staging area
 func (gm *Manager) addChanges() error {
 	workTree, err := gm.repository.Worktree()
@@ -290,26 +496,70 @@ func (gm *Manager) addChanges() error {
 }
 
 // commitChanges creates a commit with the changes
-func (gm *Manager) commitChanges() error {
+func (gm *Manager) commitChanges() (sink.Result, error) {
 	workTree, err := gm.repository.Worktree()
 	if err != nil {
-		return err
+		return sink.Result{}, err
 	}
 
 	// Check if there are any changes to commit
 	status, err := workTree.Status()
 	if err != nil {
-		return err
+		return sink.Result{}, err
 	}
 
 	if status.IsClean() {
 		// No changes to commit
+		return sink.Result{}, nil
+	}
+
+	// Create commit summarizing the changed resources
+	changes := gm.collectChanges(status)
+	commit, err := workTree.Commit(
+		buildCommitMessage(gm.clusterName, changes),
+		&git.CommitOptions{
+			Author: &object.Signature{
+				Name:  gm.config.AuthorName,
+				Email: gm.config.AuthorEmail,
+				When:  time.Now(),
+			},
+		},
+	)
+	if err != nil {
+		return sink.Result{}, err
+	}
+	metrics.Commits.WithLabelValues(gm.clusterName).Inc()
+
+	gm.logger().Info("Created commit", "commit", commit.String(), "changes", len(changes))
+	return sink.Result{Reference: commit.String(), Changes: changes}, nil
+}
+
+// migrateLayout moves the files of the repository to the current layout and
+// commits the moves on their own
+func (gm *Manager) migrateLayout() error {
+	moves, err := sink.MigrateLayout(gm.backupDir(), gm.pathLayout())
+	if err != nil {
+		return err
+	}
+
+	if err := gm.addChanges(); err != nil {
+		return fmt.Errorf("failed to add changes: %w", err)
+	}
+
+	workTree, err := gm.repository.Worktree()
+	if err != nil {
+		return err
+	}
+	status, err := workTree.Status()
+	if err != nil {
+		return err
+	}
+	if status.IsClean() {
 		return nil
 	}
 
-	// Create commit
 	commit, err := workTree.Commit(
-		fmt.Sprintf("Backup Kubernetes resources - %s", time.Now().Format("2006-01-02 15:04:05")),
+		buildMigrationMessage(gm.clusterName, gm.pathLayout().Template(), moves),
 		&git.CommitOptions{
 			Author: &object.Signature{
 				Name:  gm.config.AuthorName,
@@ -321,71 +571,236 @@ func (gm *Manager) commitChanges() error {
 	if err != nil {
 		return err
 	}
+	metrics.Commits.WithLabelValues(gm.clusterName).Inc()
 
-	// Log commit hash for debugging
-	fmt.Printf("Created commit: %s\n", commit)
+	gm.logger().Info("Migrated repository layout", "commit", commit.String(), "moved", len(moves))
 	return nil
 }
 
-// pushChanges pushes commits to remote repository
-func (gm *Manager) pushChanges() error {
-	return gm.repository.Push(&git.PushOptions{
-		Auth: gm.auth,
-	})
+// backupDir returns the directory of the working copy holding the backup
+func (gm *Manager) backupDir() string {
+	return filepath.Join(gm.workDir, filepath.FromSlash(gm.directory))
 }
 
-// CleanupOldBackups removes old backup files that are no longer present in Kubernetes
-// This is useful to keep the repository clean
-func (gm *Manager) CleanupOldBackups(currentResources []sanitizer.SanitizedResource) error {
-	// Create a set of current resource paths
-	currentPaths := make(map[string]bool)
-	for _, resource := range currentResources {
-		var resourcePath string
-		if resource.Namespace == "" {
-			resourcePath = filepath.Join("cluster-scoped",
-				strings.ToLower(resource.Kind), fmt.Sprintf("%s.yaml", resource.Name))
-		} else {
-			resourcePath = filepath.Join("namespaces", resource.Namespace,
-				strings.ToLower(resource.Kind), fmt.Sprintf("%s.yaml", resource.Name))
-		}
-		currentPaths[resourcePath] = true
+// pathLayout returns the layout of the resource files
+func (gm *Manager) pathLayout() *sink.Layout {
+	if gm.layout == nil {
+		return sink.DefaultLayout
 	}
+	return gm.layout
+}
 
-	// Walk through existing files and remove those not in current set
-	return filepath.Walk(gm.workDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+// logger returns the logger of the current backup run
+func (gm *Manager) logger() *slog.Logger {
+	if gm.runLogger == nil {
+		return slog.Default()
+	}
+	return gm.runLogger
+}
+
+// progressWriter logs the progress messages of the remote at debug level. The
+// remote sends them in arbitrary chunks and rewrites lines with carriage returns,
+// so every complete line is logged once.
+type progressWriter struct {
+	logger  *slog.Logger
+	pending []byte
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.pending = append(w.pending, p...)
+	for {
+		i := strings.IndexAny(string(w.pending), "\r\n")
+		if i < 0 {
+			return len(p), nil
 		}
+		if line := strings.TrimSpace(string(w.pending[:i])); line != "" {
+			w.logger.Debug("Clone progress", "message", line)
+		}
+		w.pending = w.pending[i+1:]
+	}
+}
+
+// pushChanges pushes the commits of the branch to remote repository
+func (gm *Manager) pushChanges(ctx context.Context) error {
+	ctx, cancel := gm.remoteContext(ctx)
+	defer cancel()
+
+	branchRef := plumbing.NewBranchReferenceName(gm.config.Branch)
+	err := gm.repository.PushContext(ctx, &git.PushOptions{
+		RefSpecs: []config2.RefSpec{config2.RefSpec(branchRef + ":" + branchRef)},
+		Auth:     gm.auth,
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return err
+	}
+
+	return nil
+}
 
-		// Skip directories and non-YAML files
-		if info.IsDir() || !strings.HasSuffix(info.Name(), ".yaml") {
+// remoteContext bounds an operation on the remote by GIT_TIMEOUT, so that a
+// hung remote fails the run instead of blocking the branch's writes forever
+func (gm *Manager) remoteContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if gm.config.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, gm.config.Timeout)
+}
+
+// isNonFastForward reports whether a push was rejected because the remote
+// branch moved since the last pull
+func isNonFastForward(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "non-fast-forward update")
+}
+
+// ReadRevision returns the backed-up manifests at the given revision like
+// ReadFiles, read from a temporary clone of the backup repository. Unlike a
+// Manager it doesn't lock WORK_DIR, so it works next to a running daemon.
+func ReadRevision(ctx context.Context, cfg *config.Config, revision string) (map[string][]byte, error) {
+	reader := &Manager{
+		config:      cfg.Git,
+		clusterName: cfg.ClusterName,
+		directory:   cfg.Git.Directory,
+	}
+
+	auth, err := reader.setupAuth()
+	if err != nil {
+		return nil, fmt.Errorf("failed to setup Git authentication: %w", err)
+	}
+
+	cloneDir, err := os.MkdirTemp("", "kube-git-backup-restore-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(cloneDir)
+
+	// Fetch every branch and tag rather than cloning, which fails when the
+	// remote HEAD points to a branch that doesn't exist
+	reader.repository, err = git.PlainInit(cloneDir, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize repository: %w", err)
+	}
+	if _, err := reader.repository.CreateRemote(&config2.RemoteConfig{
+		Name: "origin",
+		URLs: []string{cfg.Git.Repository},
+	}); err != nil {
+		return nil, fmt.Errorf("failed to add remote origin: %w", err)
+	}
+
+	fetchCtx, cancel := reader.remoteContext(ctx)
+	defer cancel()
+	err = reader.repository.FetchContext(fetchCtx, &git.FetchOptions{
+		RefSpecs: []config2.RefSpec{"+refs/heads/*:refs/remotes/origin/*"},
+		Auth:     auth,
+		Tags:     git.AllTags,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch repository: %w", err)
+	}
+
+	return reader.ReadFiles(revision)
+}
+
+// ReadFiles returns the backed-up manifests, see sink.IsResourcePath,
+// below the backup directory at the given revision (commit hash, tag or
+// branch), keyed by their path within the repository
+func (gm *Manager) ReadFiles(revision string) (map[string][]byte, error) {
+	hash, err := gm.resolveRevision(revision)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve revision %s: %w", revision, err)
+	}
+
+	commit, err := gm.repository.CommitObject(*hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commit %s: %w", hash, err)
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tree for commit %s: %w", hash, err)
+	}
+
+	files := make(map[string][]byte)
+	err = tree.Files().ForEach(func(file *object.File) error {
+		if gm.directory != "" && !strings.HasPrefix(file.Name, gm.directory+"/") {
+			return nil
+		}
+		if !sink.IsResourcePath(strings.TrimPrefix(file.Name, gm.directory+"/")) {
 			return nil
 		}
 
-		// Get relative path from work directory
-		relPath, err := filepath.Rel(gm.workDir, path)
+		content, err := file.Contents()
 		if err != nil {
-			return err
+			return fmt.Errorf("failed to read %s: %w", file.Name, err)
 		}
+		files[file.Name] = []byte(content)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		// Skip .git directory and other non-resource files
-		if strings.HasPrefix(relPath, ".git") {
-			return nil
+	return files, nil
+}
+
+// resolveRevision resolves a revision to a commit. Branch names resolve to
+// the remote branch as of the last fetch, as local branches other than the
+// backup branch are never updated.
+func (gm *Manager) resolveRevision(revision string) (*plumbing.Hash, error) {
+	remoteBranch := plumbing.NewRemoteReferenceName("origin", revision)
+	if ref, err := gm.repository.Reference(remoteBranch, true); err == nil {
+		hash := ref.Hash()
+		return &hash, nil
+	}
+	return gm.repository.ResolveRevision(plumbing.Revision(revision))
+}
+
+// CleanupOldBackups removes old backup files that are no longer present in Kubernetes
+// This is useful to keep the repository clean. The files of kinds that failed
+// to be collected in the run of ctx are kept.
+func (gm *Manager) CleanupOldBackups(ctx context.Context, currentResources []sanitizer.SanitizedResource) error {
+	// Create a set of current resource paths
+	paths, err := gm.pathLayout().Paths(currentResources)
+	if err != nil {
+		return err
+	}
+	currentPaths := make(map[string]bool, len(paths))
+	for _, relPath := range paths {
+		currentPaths[relPath] = true
+	}
+
+	// Walk through existing files, skipping .git and other hidden entries,
+	// and remove those not in current set
+	files, err := sink.ListResourceFiles(gm.backupDir())
+	if err != nil {
+		return err
+	}
+	for _, relPath := range files {
+		if currentPaths[relPath] {
+			continue
 		}
 
-		// If this file is not in current resources, remove it
-		if !currentPaths[relPath] {
-			fmt.Printf("Removing old backup file: %s\n", relPath)
-			return os.Remove(path)
+		filePath := filepath.Join(gm.backupDir(), filepath.FromSlash(relPath))
+		keep, err := sink.KeepsFile(ctx, filePath)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", relPath, err)
+		}
+		if keep {
+			gm.logger().Debug("Keeping file of a kind that failed to be collected", "path", path.Join(gm.directory, relPath))
+			continue
 		}
 
-		return nil
-	})
+		gm.logger().Info("Removing old backup file", "path", path.Join(gm.directory, relPath))
+		if err := os.Remove(filePath); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 // cleanupDeletedResources removes files from Git that no longer exist in the cluster
-func (gm *Manager) cleanupDeletedResources(resources []sanitizer.SanitizedResource) error {
-	return gm.CleanupOldBackups(resources)
+func (gm *Manager) cleanupDeletedResources(ctx context.Context, resources []sanitizer.SanitizedResource) error {
+	return gm.CleanupOldBackups(ctx, resources)
 }
 
 // getHostKeyCallback returns an appropriate SSH host key callback
@@ -412,13 +827,13 @@ func (gm *Manager) getHostKeyCallback() (ssh.HostKeyCallback, error) {
 	knownHostsPath := "/root/.ssh/known_hosts"
 	if err := gm.createDefaultKnownHosts(knownHostsPath); err != nil {
 		// If we can't create known_hosts, fall back to insecure (but log warning)
-		fmt.Printf("Warning: Using insecure SSH host key verification. Could not setup known_hosts: %v\n", err)
+		gm.logger().Warn("Using insecure SSH host key verification, could not set up known_hosts", "error", err)
 		return ssh.InsecureIgnoreHostKey(), nil
 	}
 	
 	callback, err := knownhosts.New(knownHostsPath)
 	if err != nil {
-		fmt.Printf("Warning: Using insecure SSH host key verification. Could not load known_hosts: %v\n", err)
+		gm.logger().Warn("Using insecure SSH host key verification, could not load known_hosts", "error", err)
 		return ssh.InsecureIgnoreHostKey(), nil
 	}
 	
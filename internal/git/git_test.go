@@ -0,0 +1,642 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"kube-git-backup/internal/config"
+	"kube-git-backup/internal/sanitizer"
+	"kube-git-backup/internal/sink"
+
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+func TestInitRepositoryWithSeparateCacheDir(t *testing.T) {
+	remoteDir := t.TempDir()
+	if _, err := git.PlainInit(remoteDir, true); err != nil {
+		t.Fatalf("Failed to init remote: %v", err)
+	}
+
+	workDir := filepath.Join(t.TempDir(), "work")
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+
+	gm := &Manager{
+		config:   config.GitConfig{Repository: remoteDir, Branch: "main"},
+		workDir:  workDir,
+		cacheDir: cacheDir,
+	}
+	if err := gm.initRepository(); err != nil {
+		t.Fatalf("Failed to initialize repository: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(cacheDir, "HEAD")); err != nil {
+		t.Errorf("Expected Git storage in the cache directory: %v", err)
+	}
+	// The work directory only holds a .git file pointing to the cache directory
+	if info, err := os.Stat(filepath.Join(workDir, ".git")); err == nil && info.IsDir() {
+		t.Errorf("Expected no .git directory in the work directory")
+	}
+
+	resources := []sanitizer.SanitizedResource{
+		{APIVersion: "v1", Kind: "ConfigMap", Namespace: "prod", Name: "settings",
+			YAML: []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\n  namespace: prod\n")},
+	}
+	if err := gm.writeResources(resources); err != nil {
+		t.Fatalf("Failed to write resources: %v", err)
+	}
+	if err := gm.addChanges(); err != nil {
+		t.Fatalf("Failed to add changes: %v", err)
+	}
+
+	workTree, err := gm.repository.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := workTree.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileStatus := status.File("namespaces/prod/configmap/settings.yaml"); fileStatus.Staging != git.Added {
+		t.Errorf("Expected the resource to be staged, got %q", fileStatus.Staging)
+	}
+}
+
+func TestProgressWriter(t *testing.T) {
+	var buf bytes.Buffer
+	writer := &progressWriter{logger: slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))}
+
+	for _, chunk := range []string{"Counting obj", "ects: 50% (1/2)\rCounting objects: 100% (2/2)", ", done.\n\n"} {
+		if n, err := writer.Write([]byte(chunk)); err != nil || n != len(chunk) {
+			t.Fatalf("Write(%q) = %d, %v", chunk, n, err)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 log lines, got %d: %s", len(lines), buf.String())
+	}
+	for i, expected := range []string{`message="Counting objects: 50% (1/2)"`, `message="Counting objects: 100% (2/2), done."`} {
+		if !strings.Contains(lines[i], "level=DEBUG") || !strings.Contains(lines[i], expected) {
+			t.Errorf("Expected debug line with %s, got %s", expected, lines[i])
+		}
+	}
+}
+
+func TestLockWorkDir(t *testing.T) {
+	workDir := t.TempDir()
+
+	first := &Manager{workDir: workDir}
+	if err := first.lockWorkDir(); err != nil {
+		t.Fatalf("Failed to lock work directory: %v", err)
+	}
+
+	second := &Manager{workDir: workDir}
+	if err := second.lockWorkDir(); err == nil {
+		t.Errorf("Expected the second lock to fail while the first is held")
+	}
+
+	if err := first.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.lockWorkDir(); err != nil {
+		t.Errorf("Expected the lock to succeed once released, got %v", err)
+	}
+	second.Close()
+}
+
+func TestWriteChanges(t *testing.T) {
+	remoteDir := t.TempDir()
+	if _, err := git.PlainInit(remoteDir, true); err != nil {
+		t.Fatalf("Failed to init remote: %v", err)
+	}
+
+	workDir := t.TempDir()
+	gm := &Manager{
+		config:   config.GitConfig{Repository: remoteDir, Branch: "main", AuthorName: "test", AuthorEmail: "test@example.com"},
+		workDir:  workDir,
+		cacheDir: filepath.Join(workDir, ".git"),
+	}
+	if err := gm.initRepository(); err != nil {
+		t.Fatalf("Failed to initialize repository: %v", err)
+	}
+
+	settings := sanitizer.SanitizedResource{APIVersion: "v1", Kind: "ConfigMap", Namespace: "prod", Name: "settings",
+		YAML: []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\n  namespace: prod\n")}
+	web := sanitizer.SanitizedResource{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "prod", Name: "web",
+		YAML: []byte("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: web\n  namespace: prod\n")}
+	slow := sanitizer.SanitizedResource{APIVersion: "storage.k8s.io/v1", Kind: "StorageClass", Name: "slow",
+		YAML: []byte("apiVersion: storage.k8s.io/v1\nkind: StorageClass\nmetadata:\n  name: slow\n")}
+
+	if err := gm.writeResources([]sanitizer.SanitizedResource{settings, slow}); err != nil {
+		t.Fatal(err)
+	}
+	if err := gm.addChanges(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gm.commitChanges(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the changed resources are touched, settings stays in place
+	ctx := context.Background()
+	if err := gm.WriteChanges(ctx, []sanitizer.SanitizedResource{web}, []sanitizer.SanitizedResource{slow}); err != nil {
+		t.Fatalf("Failed to write changes: %v", err)
+	}
+	result, err := gm.Finalize(ctx)
+	if err != nil {
+		t.Fatalf("Failed to finalize: %v", err)
+	}
+
+	head, err := gm.repository.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Reference != head.Hash().String() {
+		t.Errorf("Expected reference %s, got %s", head.Hash(), result.Reference)
+	}
+	if added, modified, deleted := result.Counts(); added != 1 || modified != 0 || deleted != 1 {
+		t.Errorf("Expected 1 added and 1 deleted, got %d added, %d modified, %d deleted", added, modified, deleted)
+	}
+
+	commit, err := gm.repository.CommitObject(head.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]bool{
+		"namespaces/prod/configmap/settings.yaml": true,
+		"namespaces/prod/deployment/web.yaml":     true,
+		"cluster-scoped/storageclass/slow.yaml":   false,
+	}
+	for path, exists := range expected {
+		if _, err := commit.File(path); (err == nil) != exists {
+			t.Errorf("Expected %s to exist: %v, got error %v", path, exists, err)
+		}
+	}
+
+	if !strings.HasPrefix(commit.Message, "Backup : 1 added, 0 modified, 1 deleted") {
+		t.Errorf("Unexpected commit message:\n%s", commit.Message)
+	}
+}
+
+func TestCleanupOldBackupsKeepsFailedKinds(t *testing.T) {
+	workDir := t.TempDir()
+	gm := &Manager{workDir: workDir, layout: sink.DefaultLayout}
+
+	settings := sanitizer.SanitizedResource{APIVersion: "v1", Kind: "ConfigMap", Namespace: "prod", Name: "settings",
+		YAML: []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\n  namespace: prod\n")}
+	credentials := sanitizer.SanitizedResource{APIVersion: "v1", Kind: "Secret", Namespace: "prod", Name: "credentials",
+		YAML: []byte("apiVersion: v1\nkind: Secret\nmetadata:\n  name: credentials\n  namespace: prod\n")}
+	old := sanitizer.SanitizedResource{APIVersion: "v1", Kind: "ConfigMap", Namespace: "prod", Name: "old",
+		YAML: []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: old\n  namespace: prod\n")}
+	web := sanitizer.SanitizedResource{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "prod", Name: "web",
+		YAML: []byte("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: web\n  namespace: prod\n")}
+
+	if err := gm.writeResources([]sanitizer.SanitizedResource{settings, credentials, old, web}); err != nil {
+		t.Fatalf("Failed to write resources: %v", err)
+	}
+
+	// Secrets failed to be collected and the Deployment had an invalid
+	// manifest, so only the deleted ConfigMap goes
+	ctx := sink.WithCollection(t.Context(), sink.Collection{
+		Kept:          []sink.KeptKind{{Kind: "Secret"}},
+		KeptResources: []sink.KeptResource{{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "prod", Name: "web"}},
+	})
+	if err := gm.CleanupOldBackups(ctx, []sanitizer.SanitizedResource{settings}); err != nil {
+		t.Fatalf("Failed to clean up: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(workDir, "namespaces/prod/secret/credentials.yaml")); err != nil {
+		t.Errorf("Expected the Secret to be kept: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(workDir, "namespaces/prod/deployment/web.yaml")); err != nil {
+		t.Errorf("Expected the invalid Deployment to be kept: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(workDir, "namespaces/prod/configmap/old.yaml")); !os.IsNotExist(err) {
+		t.Errorf("Expected the deleted ConfigMap to be removed, got %v", err)
+	}
+}
+
+func TestCleanupOldBackupsWithLayout(t *testing.T) {
+	layout, err := sink.NewLayout("{{.Cluster}}/{{.Namespace}}/{{lower .Kind}}/{{.Name}}.yaml", "production")
+	if err != nil {
+		t.Fatalf("Failed to create layout: %v", err)
+	}
+
+	workDir := t.TempDir()
+	gm := &Manager{workDir: workDir, layout: layout}
+
+	settings := sanitizer.SanitizedResource{APIVersion: "v1", Kind: "ConfigMap", Namespace: "prod", Name: "settings",
+		YAML: []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\n  namespace: prod\n")}
+	slow := sanitizer.SanitizedResource{APIVersion: "storage.k8s.io/v1", Kind: "StorageClass", Name: "slow",
+		YAML: []byte("apiVersion: storage.k8s.io/v1\nkind: StorageClass\nmetadata:\n  name: slow\n")}
+
+	if err := gm.writeResources([]sanitizer.SanitizedResource{settings, slow}); err != nil {
+		t.Fatalf("Failed to write resources: %v", err)
+	}
+	for _, relPath := range []string{"production/prod/configmap/settings.yaml", "production/storageclass/slow.yaml"} {
+		if _, err := os.Stat(filepath.Join(workDir, relPath)); err != nil {
+			t.Errorf("Expected %s to be written: %v", relPath, err)
+		}
+	}
+
+	if err := gm.CleanupOldBackups(t.Context(), []sanitizer.SanitizedResource{settings}); err != nil {
+		t.Fatalf("Failed to clean up: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(workDir, "production/prod/configmap/settings.yaml")); err != nil {
+		t.Errorf("Expected the current resource to be kept: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(workDir, "production/storageclass/slow.yaml")); !os.IsNotExist(err) {
+		t.Errorf("Expected the stale resource to be removed, got %v", err)
+	}
+
+	// Resources rendering to the same path are rejected before touching files
+	duplicate := settings
+	duplicate.APIVersion = "example.com/v1"
+	if err := gm.writeResources([]sanitizer.SanitizedResource{settings, duplicate}); err == nil ||
+		!strings.Contains(err.Error(), "path collision") {
+		t.Errorf("Expected a path collision error, got %v", err)
+	}
+}
+
+func TestMigrateLayoutCommit(t *testing.T) {
+	remoteDir := t.TempDir()
+	if _, err := git.PlainInit(remoteDir, true); err != nil {
+		t.Fatalf("Failed to init remote: %v", err)
+	}
+
+	workDir := t.TempDir()
+	gm := &Manager{
+		config:      config.GitConfig{Repository: remoteDir, Branch: "main", AuthorName: "test", AuthorEmail: "test@example.com"},
+		workDir:     workDir,
+		cacheDir:    filepath.Join(workDir, ".git"),
+		clusterName: "production",
+	}
+	if err := gm.initRepository(); err != nil {
+		t.Fatalf("Failed to initialize repository: %v", err)
+	}
+
+	web := sanitizer.SanitizedResource{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "prod", Name: "web",
+		YAML: []byte("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: web\n  namespace: prod\n")}
+	if err := gm.writeResources([]sanitizer.SanitizedResource{web}); err != nil {
+		t.Fatal(err)
+	}
+	if err := gm.addChanges(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gm.commitChanges(); err != nil {
+		t.Fatal(err)
+	}
+
+	layout, err := sink.NewLayout(sink.GroupedPathTemplate, "production")
+	if err != nil {
+		t.Fatalf("Failed to create layout: %v", err)
+	}
+	gm.layout = layout
+	if err := gm.migrateLayout(); err != nil {
+		t.Fatalf("Failed to migrate layout: %v", err)
+	}
+
+	head, err := gm.repository.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, err := gm.repository.CommitObject(head.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(commit.Message, "Migrate production to a new layout: 1 moved") ||
+		!strings.Contains(commit.Message, "namespaces/prod/deployment/web.yaml -> namespaces/prod/deployment.apps/web.yaml") {
+		t.Errorf("Unexpected commit message:\n%s", commit.Message)
+	}
+	for path, exists := range map[string]bool{
+		"namespaces/prod/deployment.apps/web.yaml": true,
+		"namespaces/prod/deployment/web.yaml":      false,
+		sink.LayoutFileName:                        true,
+	} {
+		if _, err := commit.File(path); (err == nil) != exists {
+			t.Errorf("Expected %s to exist: %v, got error %v", path, exists, err)
+		}
+	}
+
+	// Nothing to commit once the layout is recorded
+	if err := gm.migrateLayout(); err != nil {
+		t.Fatalf("Failed to migrate layout: %v", err)
+	}
+	again, err := gm.repository.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Hash() != head.Hash() {
+		t.Errorf("Expected no new commit, got %s", again.Hash())
+	}
+
+	// Restores read the migrated files
+	files, err := gm.ReadFiles("HEAD")
+	if err != nil {
+		t.Fatalf("Failed to read files: %v", err)
+	}
+	if _, ok := files["namespaces/prod/deployment.apps/web.yaml"]; !ok || len(files) != 1 {
+		t.Errorf("Unexpected files: %v", files)
+	}
+}
+
+func TestManagerDirectory(t *testing.T) {
+	remoteDir := t.TempDir()
+	if _, err := git.PlainInit(remoteDir, true); err != nil {
+		t.Fatalf("Failed to init remote: %v", err)
+	}
+
+	workDir := t.TempDir()
+	gm := &Manager{
+		config:      config.GitConfig{Repository: remoteDir, Branch: "main", AuthorName: "test", AuthorEmail: "test@example.com"},
+		workDir:     workDir,
+		directory:   "clusters/production",
+		cacheDir:    filepath.Join(workDir, ".git"),
+		clusterName: "production",
+	}
+	if err := gm.initRepository(); err != nil {
+		t.Fatalf("Failed to initialize repository: %v", err)
+	}
+
+	// The tree of another cluster sharing the branch
+	staging := filepath.Join(workDir, "clusters", "staging", "namespaces", "prod", "configmap", "settings.yaml")
+	if err := os.MkdirAll(filepath.Dir(staging), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(staging, []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\n  namespace: prod\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := gm.addChanges(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gm.commitChanges(); err != nil {
+		t.Fatal(err)
+	}
+
+	settings := sanitizer.SanitizedResource{APIVersion: "v1", Kind: "ConfigMap", Namespace: "prod", Name: "settings",
+		YAML: []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\n  namespace: prod\n")}
+	if err := gm.CleanupOldBackups(t.Context(), []sanitizer.SanitizedResource{settings}); err != nil {
+		t.Fatalf("Failed to clean up: %v", err)
+	}
+	if err := gm.writeResources([]sanitizer.SanitizedResource{settings}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(staging); err != nil {
+		t.Errorf("Expected the files of the other cluster to be kept: %v", err)
+	}
+
+	if err := gm.addChanges(); err != nil {
+		t.Fatal(err)
+	}
+	result, err := gm.commitChanges()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Changes) != 1 || result.Changes[0].Path != "clusters/production/namespaces/prod/configmap/settings.yaml" ||
+		result.Changes[0].Type != sink.ChangeAdded {
+		t.Errorf("Unexpected changes: %+v", result.Changes)
+	}
+
+	files, err := gm.ReadFiles("HEAD")
+	if err != nil {
+		t.Fatalf("Failed to read files: %v", err)
+	}
+	if _, ok := files["clusters/production/namespaces/prod/configmap/settings.yaml"]; !ok || len(files) != 1 {
+		t.Errorf("Expected only the files of the cluster, got %v", files)
+	}
+}
+
+func TestManagersSharingRemote(t *testing.T) {
+	tests := []struct {
+		name        string
+		branches    [2]string
+		directories [2]string
+	}{
+		{name: "shared branch", branches: [2]string{"main", "main"},
+			directories: [2]string{"clusters/production", "clusters/staging"}},
+		{name: "branch per cluster", branches: [2]string{"production", "staging"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remoteDir := t.TempDir()
+			if _, err := git.PlainInit(remoteDir, true); err != nil {
+				t.Fatalf("Failed to init remote: %v", err)
+			}
+
+			var managers [2]*Manager
+			for i, clusterName := range []string{"production", "staging"} {
+				workDir := t.TempDir()
+				managers[i] = &Manager{
+					config: config.GitConfig{Repository: remoteDir, Branch: tt.branches[i],
+						AuthorName: "test", AuthorEmail: "test@example.com"},
+					workDir:     workDir,
+					directory:   tt.directories[i],
+					cacheDir:    filepath.Join(workDir, ".git"),
+					clusterName: clusterName,
+				}
+				if err := managers[i].initRepository(); err != nil {
+					t.Fatalf("Failed to initialize repository of %s: %v", clusterName, err)
+				}
+			}
+			production, staging := managers[0], managers[1]
+
+			ctx := t.Context()
+			settings := func(value string) []sanitizer.SanitizedResource {
+				return []sanitizer.SanitizedResource{{APIVersion: "v1", Kind: "ConfigMap", Namespace: "prod", Name: "settings",
+					YAML: []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\n  namespace: prod\ndata:\n  value: " +
+						value + "\n")}}
+			}
+			backup := func(gm *Manager, value string) {
+				t.Helper()
+				if err := gm.WriteSnapshot(ctx, settings(value)); err != nil {
+					t.Fatalf("Failed to write snapshot of %s: %v", gm.clusterName, err)
+				}
+				if _, err := gm.Finalize(ctx); err != nil {
+					t.Fatalf("Failed to finalize %s: %v", gm.clusterName, err)
+				}
+			}
+
+			// The clusters take turns, as serialized by the daemon
+			backup(production, "1")
+			backup(staging, "1")
+			backup(production, "2")
+
+			// Both pull before either pushes, as replicas or daemons sharing
+			// the remote may, so the second push is rejected and retried
+			if err := production.WriteSnapshot(ctx, settings("3")); err != nil {
+				t.Fatal(err)
+			}
+			if err := staging.WriteSnapshot(ctx, settings("3")); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := production.Finalize(ctx); err != nil {
+				t.Fatalf("Failed to finalize production: %v", err)
+			}
+			if _, err := staging.Finalize(ctx); err != nil {
+				t.Fatalf("Failed to finalize staging after the remote moved: %v", err)
+			}
+
+			// A commit that was never pushed diverges from the remote once the
+			// other cluster pushed, and is replaced by the next backup
+			if err := staging.WriteSnapshot(ctx, settings("unpushed")); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := staging.commitChanges(); err != nil {
+				t.Fatal(err)
+			}
+			backup(production, "4")
+			backup(staging, "4")
+
+			remote, err := git.PlainOpen(remoteDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, gm := range managers {
+				ref, err := remote.Reference(plumbing.NewBranchReferenceName(gm.config.Branch), true)
+				if err != nil {
+					t.Fatalf("Failed to resolve branch %s: %v", gm.config.Branch, err)
+				}
+				commit, err := remote.CommitObject(ref.Hash())
+				if err != nil {
+					t.Fatal(err)
+				}
+				file, err := commit.File(path.Join(tt.directories[i], "namespaces/prod/configmap/settings.yaml"))
+				if err != nil {
+					t.Fatalf("Expected the file of %s on branch %s: %v", gm.clusterName, gm.config.Branch, err)
+				}
+				content, err := file.Contents()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !strings.Contains(content, "value: 4") {
+					t.Errorf("Expected the last backup of %s, got\n%s", gm.clusterName, content)
+				}
+			}
+		})
+	}
+}
+
+func TestRemoteTimeout(t *testing.T) {
+	// A remote that accepts connections but never answers
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer hung.Close()
+
+	remoteDir := t.TempDir()
+	if _, err := git.PlainInit(remoteDir, true); err != nil {
+		t.Fatalf("Failed to init remote: %v", err)
+	}
+	workDir := t.TempDir()
+	gm := &Manager{
+		config: config.GitConfig{Repository: remoteDir, Branch: "main",
+			AuthorName: "test", AuthorEmail: "test@example.com", Timeout: 100 * time.Millisecond},
+		workDir:  workDir,
+		cacheDir: filepath.Join(workDir, ".git"),
+	}
+	if err := gm.initRepository(); err != nil {
+		t.Fatalf("Failed to initialize repository: %v", err)
+	}
+
+	// Point the working copy at the hung remote
+	if err := gm.repository.DeleteRemote("origin"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gm.repository.CreateRemote(&gitconfig.RemoteConfig{Name: "origin", URLs: []string{hung.URL + "/repo.git"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, operation := range map[string]func(context.Context) error{
+		"pull": gm.pullLatestChanges,
+		"push": gm.pushChanges,
+	} {
+		done := make(chan error, 1)
+		go func() { done <- operation(t.Context()) }()
+		select {
+		case err := <-done:
+			if err == nil {
+				t.Errorf("Expected the %s to fail against a hung remote", name)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Expected the %s to time out", name)
+		}
+	}
+}
+
+func TestReadRevision(t *testing.T) {
+	remoteDir := t.TempDir()
+	if _, err := git.PlainInit(remoteDir, true); err != nil {
+		t.Fatalf("Failed to init remote: %v", err)
+	}
+
+	workDir := t.TempDir()
+	gm := &Manager{
+		config: config.GitConfig{Repository: remoteDir, Branch: "production",
+			AuthorName: "test", AuthorEmail: "test@example.com"},
+		workDir:     workDir,
+		cacheDir:    filepath.Join(workDir, ".git"),
+		clusterName: "production",
+	}
+	// The daemon holds the lock of the working copy
+	if err := gm.lockWorkDir(); err != nil {
+		t.Fatal(err)
+	}
+	defer gm.Close()
+	if err := gm.initRepository(); err != nil {
+		t.Fatalf("Failed to initialize repository: %v", err)
+	}
+
+	ctx := t.Context()
+	backup := func(value string) plumbing.Hash {
+		t.Helper()
+		settings := sanitizer.SanitizedResource{APIVersion: "v1", Kind: "ConfigMap", Namespace: "prod", Name: "settings",
+			YAML: []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\n  namespace: prod\ndata:\n  value: " +
+				value + "\n")}
+		if err := gm.WriteSnapshot(ctx, []sanitizer.SanitizedResource{settings}); err != nil {
+			t.Fatal(err)
+		}
+		result, err := gm.Finalize(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return plumbing.NewHash(result.Reference)
+	}
+	first := backup("1")
+	if _, err := gm.repository.CreateTag("v1", first, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := gm.repository.Push(&git.PushOptions{RefSpecs: []gitconfig.RefSpec{"refs/tags/*:refs/tags/*"}}); err != nil {
+		t.Fatal(err)
+	}
+	backup("2")
+
+	cfg := &config.Config{WorkDir: workDir, ClusterName: "production",
+		Git: config.GitConfig{Repository: remoteDir, AuthMethod: "token", Token: "test"}}
+	for revision, expected := range map[string]string{
+		"production":   "value: 2",
+		"v1":           "value: 1",
+		first.String(): "value: 1",
+	} {
+		files, err := ReadRevision(ctx, cfg, revision)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", revision, err)
+		}
+		content, ok := files["namespaces/prod/configmap/settings.yaml"]
+		if !ok || !strings.Contains(string(content), expected) {
+			t.Errorf("Expected %q at %s, got %v", expected, revision, files)
+		}
+	}
+}
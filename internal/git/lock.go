@@ -0,0 +1,42 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// lockWorkDir takes an exclusive lock on the work directory so that two
+// instances sharing a volume never operate on the same working copy
+func (gm *Manager) lockWorkDir() error {
+	if err := os.MkdirAll(gm.workDir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", gm.workDir, err)
+	}
+
+	dir, err := os.Open(gm.workDir)
+	if err != nil {
+		return fmt.Errorf("failed to open work directory: %w", err)
+	}
+
+	if err := syscall.Flock(int(dir.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		dir.Close()
+		if err == syscall.EWOULDBLOCK {
+			return fmt.Errorf("work directory %s is locked by another instance", gm.workDir)
+		}
+		return fmt.Errorf("failed to lock work directory: %w", err)
+	}
+
+	gm.lock = dir
+	return nil
+}
+
+// Close releases the work directory lock
+func (gm *Manager) Close() error {
+	if gm.lock == nil {
+		return nil
+	}
+
+	err := gm.lock.Close()
+	gm.lock = nil
+	return err
+}
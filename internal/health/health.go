@@ -0,0 +1,131 @@
+package health
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Checker tracks the backup loops of the clusters for the liveness and
+// readiness probes. A cluster whose backups fail, e.g. because it is
+// unreachable, doesn't fail the probes, as restarting the process wouldn't
+// help and would interrupt the other clusters; its staleness is exported by
+// the per-cluster metrics instead.
+type Checker struct {
+	mu          sync.RWMutex
+	started     time.Time            // Startup, or when the replica last became active
+	clusters    []string             // Clusters whose backup loops are checked, sorted
+	lastRun     map[string]time.Time // Last finished backup run per cluster, successful or not
+	lastSuccess time.Time            // Last successful backup run of any cluster
+	maxAge      time.Duration
+	standby     bool // Standby replicas don't run backups and always pass the probes
+	now         func() time.Time
+}
+
+// NewChecker creates a Checker that reports the process as not live once the
+// backup loop of one of the clusters hasn't finished a run within maxAge
+// (measured from startup until the cluster's first run)
+func NewChecker(maxAge time.Duration, clusters []string) *Checker {
+	return &Checker{
+		started:  time.Now(),
+		clusters: slices.Sorted(slices.Values(clusters)),
+		lastRun:  make(map[string]time.Time, len(clusters)),
+		maxAge:   maxAge,
+		now:      time.Now,
+	}
+}
+
+// SetStandby switches between standby and active. The liveness threshold of
+// a replica that becomes active is measured from that moment.
+func (c *Checker) SetStandby(standby bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.standby && !standby {
+		c.started = c.now()
+		clear(c.lastRun)
+		c.lastSuccess = time.Time{}
+	}
+	c.standby = standby
+}
+
+// RecordSuccess marks a backup run of cluster as finished and successful
+func (c *Checker) RecordSuccess(cluster string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastRun[cluster] = c.now()
+	c.lastSuccess = c.lastRun[cluster]
+}
+
+// RecordFailure marks a backup run of cluster as finished, but failed
+func (c *Checker) RecordFailure(cluster string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastRun[cluster] = c.now()
+}
+
+// Ready reports whether at least one backup has succeeded
+func (c *Checker) Ready() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.standby {
+		return nil
+	}
+	if c.lastSuccess.IsZero() {
+		return fmt.Errorf("no backup has succeeded yet")
+	}
+	return nil
+}
+
+// Live reports whether the backup loop of every cluster has finished a run
+// recently enough, i.e. none of them is stuck
+func (c *Checker) Live() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.standby {
+		return nil
+	}
+
+	for _, cluster := range c.clusters {
+		lastRun := c.lastRun[cluster]
+		since := lastRun
+		if since.IsZero() {
+			since = c.started
+		}
+
+		if age := c.now().Sub(since); age > c.maxAge {
+			if lastRun.IsZero() {
+				return fmt.Errorf("no backup run of cluster %s has finished since startup %s ago", cluster, age.Round(time.Second))
+			}
+			return fmt.Errorf("last backup run of cluster %s finished %s ago", cluster, age.Round(time.Second))
+		}
+	}
+	return nil
+}
+
+// LivenessHandler serves the /healthz endpoint
+func (c *Checker) LivenessHandler() http.Handler {
+	return probeHandler(c.Live)
+}
+
+// ReadinessHandler serves the /readyz endpoint
+func (c *Checker) ReadinessHandler() http.Handler {
+	return probeHandler(c.Ready)
+}
+
+// probeHandler responds with 200 when check passes and 503 otherwise
+func probeHandler(check func() error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err := check(); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, err)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+}
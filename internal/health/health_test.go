@@ -0,0 +1,133 @@
+package health
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChecker(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	checker := NewChecker(3*time.Hour, []string{"production"})
+	checker.started = now
+	checker.now = func() time.Time { return now }
+
+	if err := checker.Ready(); err == nil {
+		t.Errorf("Expected not ready before the first backup")
+	}
+	if err := checker.Live(); err != nil {
+		t.Errorf("Expected live shortly after startup, got %v", err)
+	}
+
+	// No backup run finished since startup, the loop is stuck
+	now = now.Add(4 * time.Hour)
+	if err := checker.Live(); err == nil {
+		t.Errorf("Expected not live without a backup run since startup")
+	}
+
+	checker.RecordSuccess("production")
+	if err := checker.Ready(); err != nil {
+		t.Errorf("Expected ready after a backup, got %v", err)
+	}
+	if err := checker.Live(); err != nil {
+		t.Errorf("Expected live after a backup, got %v", err)
+	}
+
+	// Readiness stays true once the last backup becomes stale
+	now = now.Add(3*time.Hour + time.Second)
+	if err := checker.Live(); err == nil {
+		t.Errorf("Expected not live with a stale backup")
+	}
+	if err := checker.Ready(); err != nil {
+		t.Errorf("Expected ready with a stale backup, got %v", err)
+	}
+}
+
+func TestCheckerStandby(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	checker := NewChecker(time.Hour, []string{"production"})
+	checker.now = func() time.Time { return now }
+	checker.SetStandby(true)
+
+	// Standby replicas pass both probes however long they wait
+	now = now.Add(24 * time.Hour)
+	if err := checker.Ready(); err != nil {
+		t.Errorf("Expected standby to be ready, got %v", err)
+	}
+	if err := checker.Live(); err != nil {
+		t.Errorf("Expected standby to be live, got %v", err)
+	}
+
+	// Becoming active restarts the liveness threshold
+	checker.SetStandby(false)
+	if err := checker.Ready(); err == nil {
+		t.Errorf("Expected not ready before the first backup as leader")
+	}
+	now = now.Add(30 * time.Minute)
+	if err := checker.Live(); err != nil {
+		t.Errorf("Expected live shortly after becoming active, got %v", err)
+	}
+}
+
+func TestCheckerClusters(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	checker := NewChecker(time.Hour, []string{"staging", "production"})
+	checker.started = now
+	checker.now = func() time.Time { return now }
+
+	// Readiness only waits for the first successful backup of any cluster
+	checker.RecordFailure("staging")
+	if err := checker.Ready(); err == nil {
+		t.Errorf("Expected not ready before the first successful backup")
+	}
+	checker.RecordSuccess("production")
+	if err := checker.Ready(); err != nil {
+		t.Errorf("Expected ready while another cluster fails, got %v", err)
+	}
+
+	// A cluster whose backups keep failing doesn't fail liveness
+	for range 3 {
+		now = now.Add(45 * time.Minute)
+		checker.RecordFailure("staging")
+		checker.RecordSuccess("production")
+	}
+	if err := checker.Live(); err != nil {
+		t.Errorf("Expected live with failing staging backups, got %v", err)
+	}
+
+	// A cluster whose backup loop is stuck does
+	now = now.Add(45 * time.Minute)
+	checker.RecordSuccess("production")
+	now = now.Add(30 * time.Minute)
+	if err := checker.Live(); err == nil || !strings.Contains(err.Error(), "staging") {
+		t.Errorf("Expected not live with a stuck staging backup loop, got %v", err)
+	}
+}
+
+func TestProbeHandlers(t *testing.T) {
+	checker := NewChecker(time.Hour, []string{"production"})
+
+	tests := []struct {
+		name     string
+		handler  http.Handler
+		expected int
+	}{
+		{"liveness", checker.LivenessHandler(), http.StatusOK},
+		{"readiness", checker.ReadinessHandler(), http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			tt.handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+			if recorder.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, recorder.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package leader
+
+import (
+	"context"
+	"fmt"
+
+	"kube-git-backup/internal/config"
+	"kube-git-backup/internal/logging"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// Run campaigns for the Lease and calls onStartedLeading once this replica
+// acquires it. It returns when ctx is done or leadership is lost; in both
+// cases the context passed to onStartedLeading is cancelled and
+// onStoppedLeading is called.
+func Run(ctx context.Context, cfg config.LeaderElectionConfig, kubeConfig *rest.Config,
+	onStartedLeading func(context.Context), onStoppedLeading func()) error {
+	logger := logging.FromContext(ctx).With("lease", cfg.Namespace+"/"+cfg.LeaseName)
+
+	clientset, err := kubernetes.NewForConfig(kubeConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create Kubernetes clientset: %w", err)
+	}
+
+	lock, err := resourcelock.New(
+		resourcelock.LeasesResourceLock,
+		cfg.Namespace,
+		cfg.LeaseName,
+		clientset.CoreV1(),
+		clientset.CoordinationV1(),
+		resourcelock.ResourceLockConfig{Identity: cfg.Identity},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create lease lock: %w", err)
+	}
+
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   cfg.LeaseDuration,
+		RenewDeadline:   cfg.RenewDeadline,
+		RetryPeriod:     cfg.RetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            cfg.LeaseName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: onStartedLeading,
+			OnStoppedLeading: onStoppedLeading,
+			OnNewLeader: func(identity string) {
+				if identity != cfg.Identity {
+					logger.Info("Another replica holds the lease", "leader", identity)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create leader elector: %w", err)
+	}
+
+	logger.Info("Waiting to acquire lease", "identity", cfg.Identity)
+
+	elector.Run(ctx)
+	return nil
+}
@@ -0,0 +1,87 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// Log output formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+type contextKey struct{}
+
+type runIDKey struct{}
+
+// NewHandler creates a slog handler writing to w in the given format ("text"
+// or "json") at the given level ("debug", "info", "warn" or "error")
+func NewHandler(w io.Writer, format, level string) (slog.Handler, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level '%s'", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch format {
+	case FormatText:
+		return slog.NewTextHandler(w, opts), nil
+	case FormatJSON:
+		return slog.NewJSONHandler(w, opts), nil
+	default:
+		return nil, fmt.Errorf("invalid log format '%s', must be 'text' or 'json'", format)
+	}
+}
+
+// Setup installs the default logger, which also receives the output of the
+// standard log package
+func Setup(w io.Writer, format, level string) error {
+	handler, err := NewHandler(w, format, level)
+	if err != nil {
+		return err
+	}
+
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// WithRunID returns a context carrying a logger that tags every line with a
+// new run ID, along with the ID
+func WithRunID(ctx context.Context) (context.Context, string) {
+	id := newRunID()
+	ctx = context.WithValue(ctx, runIDKey{}, id)
+	return NewContext(ctx, FromContext(ctx).With("run_id", id)), id
+}
+
+// RunID returns the run ID set by WithRunID, or an empty string
+func RunID(ctx context.Context) string {
+	id, _ := ctx.Value(runIDKey{}).(string)
+	return id
+}
+
+// NewContext returns a context carrying logger
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger carried by ctx, or the default logger
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// newRunID returns a short random identifier for correlating a run's log lines
+func newRunID() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
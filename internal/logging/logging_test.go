@@ -0,0 +1,75 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestNewHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		format      string
+		level       string
+		expectError bool
+	}{
+		{name: "text", format: FormatText, level: "info"},
+		{name: "json", format: FormatJSON, level: "debug"},
+		{name: "upper case level", format: FormatJSON, level: "WARN"},
+		{name: "invalid format", format: "logfmt", level: "info", expectError: true},
+		{name: "invalid level", format: FormatText, level: "verbose", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewHandler(&bytes.Buffer{}, tt.format, tt.level)
+			if tt.expectError && err == nil {
+				t.Errorf("Expected error but got none")
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestWithRunID(t *testing.T) {
+	var buf bytes.Buffer
+	handler, err := NewHandler(&buf, FormatJSON, "info")
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	ctx := NewContext(context.Background(), slog.New(handler))
+	ctx, runID := WithRunID(ctx)
+	if runID == "" || RunID(ctx) != runID {
+		t.Fatalf("Expected run ID %q in the context, got %q", runID, RunID(ctx))
+	}
+
+	FromContext(ctx).Info("Created commit", "commit", "abc123")
+	FromContext(ctx).Debug("Below the configured level")
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("Expected a single JSON line, got %q: %v", buf.String(), err)
+	}
+	if line["run_id"] != runID {
+		t.Errorf("Expected run_id %s, got %v", runID, line["run_id"])
+	}
+	if line["commit"] != "abc123" {
+		t.Errorf("Expected commit abc123, got %v", line["commit"])
+	}
+
+	_, otherID := WithRunID(ctx)
+	if otherID == runID {
+		t.Errorf("Expected a new run ID for each run")
+	}
+}
+
+func TestFromContextDefault(t *testing.T) {
+	if FromContext(context.Background()) != slog.Default() {
+		t.Errorf("Expected the default logger without a logger in the context")
+	}
+}
@@ -0,0 +1,133 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "kube_git_backup"
+
+// Backup phases reported by PhaseDuration
+const (
+	PhaseCollect  = "collect"
+	PhaseSanitize = "sanitize"
+	PhaseWrite    = "write"
+	PhaseCommit   = "commit"
+	PhasePush     = "push"
+)
+
+var (
+	registry = prometheus.NewRegistry()
+
+	// LastSuccessTimestamp is the Unix time of the last successful backup run, per cluster
+	LastSuccessTimestamp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_success_timestamp_seconds",
+		Help:      "Unix timestamp of the last successful backup run, per cluster.",
+	}, []string{"cluster"})
+
+	// NextRunTimestamp is the Unix time of the next scheduled backup run per cluster
+	NextRunTimestamp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "next_run_timestamp_seconds",
+		Help:      "Unix timestamp of the next scheduled backup run, per cluster.",
+	}, []string{"cluster"})
+
+	// BackupRuns counts backup runs by cluster and result ("success" or "failure")
+	BackupRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "runs_total",
+		Help:      "Total number of backup runs by cluster and result.",
+	}, []string{"cluster", "result"})
+
+	// PhaseDuration observes the duration of each backup phase per cluster
+	PhaseDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "phase_duration_seconds",
+		Help:      "Duration of each backup phase, per cluster.",
+		Buckets:   []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600},
+	}, []string{"cluster", "phase"})
+
+	// Resources is the number of resources collected by the last run, per cluster and kind
+	Resources = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "resources",
+		Help:      "Number of resources collected by the last backup run, per cluster and kind.",
+	}, []string{"cluster", "kind"})
+
+	// CollectionErrors counts failed collections per cluster and resource type
+	CollectionErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "collection_errors_total",
+		Help:      "Total number of failed collections per cluster and resource type.",
+	}, []string{"cluster", "resource"})
+
+	// InvalidResources counts resources skipped because their sanitized
+	// manifest did not decode back into a valid object
+	InvalidResources = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "invalid_resources_total",
+		Help:      "Total number of resources skipped because of an invalid manifest, per cluster and kind.",
+	}, []string{"cluster", "kind"})
+
+	// PathCollisions counts resources skipped because an earlier resource
+	// renders to the same path
+	PathCollisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "path_collisions_total",
+		Help:      "Total number of resources skipped because their path is taken by another resource, per cluster and kind.",
+	}, []string{"cluster", "kind"})
+
+	// Commits counts the commits created in the backup repository per cluster
+	Commits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "commits_total",
+		Help:      "Total number of commits created in the backup repository, per cluster.",
+	}, []string{"cluster"})
+
+	// PushFailures counts failed pushes to the backup repository per cluster
+	PushFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "push_failures_total",
+		Help:      "Total number of failed pushes to the backup repository, per cluster.",
+	}, []string{"cluster"})
+)
+
+func init() {
+	registry.MustRegister(
+		LastSuccessTimestamp,
+		NextRunTimestamp,
+		BackupRuns,
+		PhaseDuration,
+		Resources,
+		CollectionErrors,
+		InvalidResources,
+		PathCollisions,
+		Commits,
+		PushFailures,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Handler returns the HTTP handler serving the metrics
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// ObservePhase records the duration of a backup phase of a cluster that started at start
+func ObservePhase(cluster, phase string, start time.Time) {
+	PhaseDuration.WithLabelValues(cluster, phase).Observe(time.Since(start).Seconds())
+}
+
+// SetResourceCounts replaces the per-kind resource counts of a cluster with counts
+func SetResourceCounts(cluster string, counts map[string]int) {
+	Resources.DeletePartialMatch(prometheus.Labels{"cluster": cluster})
+	for kind, count := range counts {
+		Resources.WithLabelValues(cluster, kind).Set(float64(count))
+	}
+}
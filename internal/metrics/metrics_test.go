@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandler(t *testing.T) {
+	SetResourceCounts("production", map[string]int{"Deployment": 3, "ConfigMap": 5})
+	SetResourceCounts("production", map[string]int{"Deployment": 2})
+	SetResourceCounts("staging", map[string]int{"ConfigMap": 1})
+	CollectionErrors.WithLabelValues("staging", "secrets").Inc()
+	ObservePhase("production", PhaseCommit, time.Now())
+	InvalidResources.WithLabelValues("production", "Deployment").Inc()
+	PushFailures.WithLabelValues("staging").Inc()
+
+	recorder := httptest.NewRecorder()
+	Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	body, err := io.ReadAll(recorder.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := string(body)
+
+	for _, expected := range []string{
+		`kube_git_backup_resources{cluster="production",kind="Deployment"} 2`,
+		`kube_git_backup_resources{cluster="staging",kind="ConfigMap"} 1`,
+		`kube_git_backup_collection_errors_total{cluster="staging",resource="secrets"} 1`,
+		`kube_git_backup_phase_duration_seconds_count{cluster="production",phase="commit"} 1`,
+		`kube_git_backup_invalid_resources_total{cluster="production",kind="Deployment"} 1`,
+		`kube_git_backup_push_failures_total{cluster="staging"} 1`,
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected %q in metrics output", expected)
+		}
+	}
+
+	// Counts of kinds missing from the last run of a cluster are dropped
+	if strings.Contains(output, `cluster="production",kind="ConfigMap"`) {
+		t.Errorf("Expected stale ConfigMap count to be removed")
+	}
+}
@@ -0,0 +1,336 @@
+package restore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"kube-git-backup/internal/logging"
+	"kube-git-backup/internal/sink"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"sigs.k8s.io/yaml"
+)
+
+// FieldManager is the field manager used for server-side apply
+const FieldManager = "kube-git-backup"
+
+// Options controls which manifests are restored and how
+type Options struct {
+	Namespaces   []string          // Only restore these namespaces (empty means all)
+	Kinds        []string          // Only restore these kinds (empty means all)
+	NamespaceMap map[string]string // Remap namespaces, old -> new
+	DryRun       bool              // Server-side dry run
+}
+
+// Restorer applies backed-up manifests to a cluster through server-side apply
+type Restorer struct {
+	dynamicClient dynamic.Interface
+	mapper        *restmapper.DeferredDiscoveryRESTMapper
+	options       Options
+}
+
+// Result summarizes a restore run
+type Result struct {
+	Applied int
+	Failed  int
+}
+
+// NewRestorer creates a new Restorer
+func NewRestorer(kubeConfig *rest.Config, opts Options) (*Restorer, error) {
+	dynamicClient, err := dynamic.NewForConfig(kubeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(kubeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create discovery client: %w", err)
+	}
+
+	return &Restorer{
+		dynamicClient: dynamicClient,
+		mapper:        restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discoveryClient)),
+		options:       opts,
+	}, nil
+}
+
+// LoadDirectory reads all manifests below dir, whatever path template they
+// were written with, keyed by their slash-separated relative path
+func LoadDirectory(dir string) (map[string][]byte, error) {
+	relPaths, err := sink.ListResourceFiles(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", dir, err)
+	}
+
+	files := make(map[string][]byte, len(relPaths))
+	for _, relPath := range relPaths {
+		path := filepath.Join(dir, filepath.FromSlash(relPath))
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		files[relPath] = content
+	}
+
+	return files, nil
+}
+
+// PrepareObjects decodes manifests, applies the namespace and kind filters and
+// the namespace map, and returns the objects in dependency order
+func PrepareObjects(files map[string][]byte, opts Options) ([]*unstructured.Unstructured, error) {
+	var objects []*unstructured.Unstructured
+
+	for path, content := range files {
+		obj := &unstructured.Unstructured{}
+		if err := yaml.Unmarshal(content, &obj.Object); err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", path, err)
+		}
+
+		if obj.GetKind() == "" || obj.GetName() == "" {
+			slog.Warn("Skipping file, not a Kubernetes manifest", "path", path)
+			continue
+		}
+
+		// Encrypted or redacted Secrets cannot be applied as-is
+		if _, encrypted := obj.Object["sops"]; encrypted {
+			slog.Warn("Skipping file, SOPS encrypted, decrypt it with sops before restoring", "path", path)
+			continue
+		}
+		if isRedacted(obj) {
+			slog.Warn("Skipping file, secret values are redacted", "path", path)
+			continue
+		}
+
+		if !matchesNamespace(obj, opts.Namespaces) || !matchesKind(obj, opts.Kinds) {
+			continue
+		}
+
+		remapNamespace(obj, opts.NamespaceMap)
+		objects = append(objects, obj)
+	}
+
+	sortObjects(objects)
+	return objects, nil
+}
+
+// Restore applies the objects in order and continues past individual failures
+func (r *Restorer) Restore(ctx context.Context, objects []*unstructured.Unstructured) (Result, error) {
+	var result Result
+	logger := logging.FromContext(ctx)
+
+	for _, obj := range objects {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		if err := r.apply(ctx, obj); err != nil {
+			logger.Error("Failed to apply object", "object", describe(obj), "error", err)
+			result.Failed++
+			continue
+		}
+
+		result.Applied++
+		logger.Info("Applied object", "object", describe(obj), "dry_run", r.options.DryRun)
+	}
+
+	if result.Failed > 0 {
+		return result, fmt.Errorf("failed to apply %d of %d objects", result.Failed, len(objects))
+	}
+
+	return result, nil
+}
+
+// apply server-side applies a single object
+func (r *Restorer) apply(ctx context.Context, obj *unstructured.Unstructured) error {
+	gvk := obj.GroupVersionKind()
+
+	mapping, err := r.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if meta.IsNoMatchError(err) {
+		// The kind may have been registered by a CRD applied earlier in this run
+		r.mapper.Reset()
+		mapping, err = r.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to map %s: %w", gvk, err)
+	}
+
+	data, err := json.Marshal(obj.Object)
+	if err != nil {
+		return fmt.Errorf("failed to encode object: %w", err)
+	}
+
+	force := true
+	patchOptions := metav1.PatchOptions{
+		FieldManager: FieldManager,
+		Force:        &force,
+	}
+	if r.options.DryRun {
+		patchOptions.DryRun = []string{metav1.DryRunAll}
+	}
+
+	var resourceClient dynamic.ResourceInterface
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		namespace := obj.GetNamespace()
+		if namespace == "" {
+			namespace = metav1.NamespaceDefault
+		}
+		resourceClient = r.dynamicClient.Resource(mapping.Resource).Namespace(namespace)
+	} else {
+		resourceClient = r.dynamicClient.Resource(mapping.Resource)
+	}
+
+	_, err = resourceClient.Patch(ctx, obj.GetName(), types.ApplyPatchType, data, patchOptions)
+	return err
+}
+
+// applyOrder returns the restore tier of a kind: Namespaces, CRDs,
+// StorageClasses, RBAC, ConfigMaps/Secrets, volumes, then workloads and everything else
+func applyOrder(kind string) int {
+	switch kind {
+	case "Namespace":
+		return 0
+	case "CustomResourceDefinition":
+		return 1
+	case "StorageClass":
+		return 2
+	case "ServiceAccount", "ClusterRole", "ClusterRoleBinding", "Role", "RoleBinding":
+		return 3
+	case "ConfigMap", "Secret":
+		return 4
+	case "PersistentVolume", "PersistentVolumeClaim":
+		return 5
+	default:
+		return 6
+	}
+}
+
+// sortObjects sorts objects in dependency order, then by kind, namespace and name
+func sortObjects(objects []*unstructured.Unstructured) {
+	sort.SliceStable(objects, func(i, j int) bool {
+		a, b := objects[i], objects[j]
+		if orderA, orderB := applyOrder(a.GetKind()), applyOrder(b.GetKind()); orderA != orderB {
+			return orderA < orderB
+		}
+		if a.GetKind() != b.GetKind() {
+			return a.GetKind() < b.GetKind()
+		}
+		if a.GetNamespace() != b.GetNamespace() {
+			return a.GetNamespace() < b.GetNamespace()
+		}
+		return a.GetName() < b.GetName()
+	})
+}
+
+// matchesNamespace checks the namespace filter; Namespace objects match by name
+// and cluster-scoped objects are always included
+func matchesNamespace(obj *unstructured.Unstructured, namespaces []string) bool {
+	if len(namespaces) == 0 {
+		return true
+	}
+
+	namespace := obj.GetNamespace()
+	if obj.GetKind() == "Namespace" {
+		namespace = obj.GetName()
+	} else if namespace == "" {
+		return true
+	}
+
+	for _, ns := range namespaces {
+		if ns == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesKind checks the kind filter (case-insensitive)
+func matchesKind(obj *unstructured.Unstructured, kinds []string) bool {
+	if len(kinds) == 0 {
+		return true
+	}
+
+	for _, kind := range kinds {
+		if strings.EqualFold(kind, obj.GetKind()) {
+			return true
+		}
+	}
+	return false
+}
+
+// remapNamespace moves an object to its mapped namespace, renaming Namespace
+// objects and updating namespaced RBAC subjects along the way
+func remapNamespace(obj *unstructured.Unstructured, namespaceMap map[string]string) {
+	if len(namespaceMap) == 0 {
+		return
+	}
+
+	if obj.GetKind() == "Namespace" {
+		if newName, ok := namespaceMap[obj.GetName()]; ok {
+			obj.SetName(newName)
+		}
+		return
+	}
+
+	if newNamespace, ok := namespaceMap[obj.GetNamespace()]; ok {
+		obj.SetNamespace(newNamespace)
+	}
+
+	if obj.GetKind() == "RoleBinding" || obj.GetKind() == "ClusterRoleBinding" {
+		subjects, found, _ := unstructured.NestedSlice(obj.Object, "subjects")
+		if !found {
+			return
+		}
+		for _, subject := range subjects {
+			subjectMap, ok := subject.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if namespace, ok := subjectMap["namespace"].(string); ok {
+				if newNamespace, ok := namespaceMap[namespace]; ok {
+					subjectMap["namespace"] = newNamespace
+				}
+			}
+		}
+		unstructured.SetNestedSlice(obj.Object, subjects, "subjects")
+	}
+}
+
+// isRedacted checks if a Secret was written with SECRET_MODE=redact, whose
+// values are keyed fingerprints or, in older backups, plain SHA-256 sums
+func isRedacted(obj *unstructured.Unstructured) bool {
+	if obj.GetKind() != "Secret" {
+		return false
+	}
+
+	for _, field := range []string{"data", "stringData"} {
+		values, _, _ := unstructured.NestedMap(obj.Object, field)
+		for _, value := range values {
+			if str, ok := value.(string); ok && (strings.HasPrefix(str, "hmac-sha256:") || strings.HasPrefix(str, "sha256:")) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// describe returns a human readable identifier for an object
+func describe(obj *unstructured.Unstructured) string {
+	if obj.GetNamespace() == "" {
+		return fmt.Sprintf("%s/%s", obj.GetKind(), obj.GetName())
+	}
+	return fmt.Sprintf("%s/%s/%s", obj.GetNamespace(), obj.GetKind(), obj.GetName())
+}
@@ -0,0 +1,112 @@
+package restore
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestPrepareObjects(t *testing.T) {
+	files := map[string][]byte{
+		"namespaces/prod/deployment/web.yaml":                                       []byte("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: web\n  namespace: prod\n"),
+		"namespaces/prod/configmap/settings.yaml":                                   []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\n  namespace: prod\n"),
+		"namespaces/prod/rolebinding/deployers.yaml":                                []byte("apiVersion: rbac.authorization.k8s.io/v1\nkind: RoleBinding\nmetadata:\n  name: deployers\n  namespace: prod\nroleRef:\n  apiGroup: rbac.authorization.k8s.io\n  kind: Role\n  name: deployer\nsubjects:\n- kind: ServiceAccount\n  name: ci\n  namespace: prod\n"),
+		"namespaces/prod/secret/encrypted.yaml":                                     []byte("apiVersion: v1\nkind: Secret\nmetadata:\n  name: encrypted\n  namespace: prod\ndata:\n  password: ENC[AES256_GCM,data:abc,iv:def,tag:ghi,type:str]\nsops:\n  version: 3.9.0\n"),
+		"namespaces/prod/secret/redacted.yaml":                                      []byte("apiVersion: v1\nkind: Secret\nmetadata:\n  name: redacted\n  namespace: prod\ndata:\n  password: hmac-sha256:0a1b\n"),
+		"namespaces/prod/secret/redacted-unkeyed.yaml":                              []byte("apiVersion: v1\nkind: Secret\nmetadata:\n  name: redacted-unkeyed\n  namespace: prod\ndata:\n  password: sha256:0a1b\n"),
+		"namespaces/staging/service/web.yaml":                                       []byte("apiVersion: v1\nkind: Service\nmetadata:\n  name: web\n  namespace: staging\n"),
+		"cluster-scoped/namespace/prod.yaml":                                        []byte("apiVersion: v1\nkind: Namespace\nmetadata:\n  name: prod\n"),
+		"cluster-scoped/storageclass/fast.yaml":                                     []byte("apiVersion: storage.k8s.io/v1\nkind: StorageClass\nmetadata:\n  name: fast\nprovisioner: example.com/fast\n"),
+		"cluster-scoped/customresourcedefinition/certificates.cert-manager.io.yaml": []byte("apiVersion: apiextensions.k8s.io/v1\nkind: CustomResourceDefinition\nmetadata:\n  name: certificates.cert-manager.io\n"),
+	}
+
+	objects, err := PrepareObjects(files, Options{
+		Namespaces:   []string{"prod"},
+		NamespaceMap: map[string]string{"prod": "prod-drill"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to prepare objects: %v", err)
+	}
+
+	expected := []struct {
+		kind      string
+		namespace string
+		name      string
+	}{
+		{"Namespace", "", "prod-drill"},
+		{"CustomResourceDefinition", "", "certificates.cert-manager.io"},
+		{"StorageClass", "", "fast"},
+		{"RoleBinding", "prod-drill", "deployers"},
+		{"ConfigMap", "prod-drill", "settings"},
+		{"Deployment", "prod-drill", "web"},
+	}
+
+	if len(objects) != len(expected) {
+		t.Fatalf("Expected %d objects, got %d", len(expected), len(objects))
+	}
+
+	for i, want := range expected {
+		obj := objects[i]
+		if obj.GetKind() != want.kind || obj.GetNamespace() != want.namespace || obj.GetName() != want.name {
+			t.Errorf("Expected object %d to be %s %s/%s, got %s %s/%s", i,
+				want.kind, want.namespace, want.name, obj.GetKind(), obj.GetNamespace(), obj.GetName())
+		}
+	}
+
+	// RBAC subjects follow the namespace map
+	subjects := objects[3].Object["subjects"].([]interface{})
+	if ns := subjects[0].(map[string]interface{})["namespace"]; ns != "prod-drill" {
+		t.Errorf("Expected subject namespace 'prod-drill', got '%v'", ns)
+	}
+}
+
+func TestPrepareObjectsKindFilter(t *testing.T) {
+	files := map[string][]byte{
+		"namespaces/prod/deployment/web.yaml":     []byte("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: web\n  namespace: prod\n"),
+		"namespaces/prod/configmap/settings.yaml": []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\n  namespace: prod\n"),
+	}
+
+	objects, err := PrepareObjects(files, Options{Kinds: []string{"deployment"}})
+	if err != nil {
+		t.Fatalf("Failed to prepare objects: %v", err)
+	}
+
+	if len(objects) != 1 || objects[0].GetKind() != "Deployment" {
+		t.Fatalf("Expected only the Deployment, got %d objects", len(objects))
+	}
+}
+
+func TestLoadDirectory(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"production/prod/deployment.apps/web.yaml": "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: web\n",
+		"production/storageclass/slow.yaml":        "apiVersion: storage.k8s.io/v1\nkind: StorageClass\nmetadata:\n  name: slow\n",
+		".git/config.yaml":                         "ignored",
+		"manifest.json":                            "{}",
+	}
+	for relPath, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(relPath))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	loaded, err := LoadDirectory(dir)
+	if err != nil {
+		t.Fatalf("Failed to load directory: %v", err)
+	}
+
+	var paths []string
+	for relPath := range loaded {
+		paths = append(paths, relPath)
+	}
+	sort.Strings(paths)
+	if strings.Join(paths, ",") != "production/prod/deployment.apps/web.yaml,production/storageclass/slow.yaml" {
+		t.Errorf("Unexpected files: %v", paths)
+	}
+}
@@ -1,11 +1,15 @@
 package sanitizer
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strings"
+	"sync"
 
 	"kube-git-backup/internal/collector"
 	"kube-git-backup/internal/config"
+	"kube-git-backup/internal/logging"
 
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -15,6 +19,10 @@ import (
 // YAMLSanitizer sanitizes Kubernetes YAML resources
 type YAMLSanitizer struct {
 	config *config.SanitizerConfig
+
+	encrypterOnce sync.Once
+	encrypter     *sopsEncrypter
+	encrypterErr  error
 }
 
 // SanitizedResource represents a sanitized Kubernetes resource
@@ -33,20 +41,47 @@ func NewYAMLSanitizer(cfg config.SanitizerConfig) *YAMLSanitizer {
 	}
 }
 
-// SanitizeResources sanitizes a list of Kubernetes resources
-func (ys *YAMLSanitizer) SanitizeResources(resources []collector.Resource) ([]SanitizedResource, error) {
+// ErrInvalidManifest is returned for resources whose sanitized YAML does not
+// decode back into a valid object
+var ErrInvalidManifest = errors.New("invalid manifest")
+
+// InvalidResource is a resource left out because its sanitized manifest is invalid
+type InvalidResource struct {
+	APIVersion string
+	Kind       string
+	Namespace  string
+	Name       string
+	Err        error
+}
+
+// SanitizeResources sanitizes a list of Kubernetes resources. Resources with an
+// invalid manifest are logged and returned as invalid instead of failing the batch.
+func (ys *YAMLSanitizer) SanitizeResources(ctx context.Context, resources []collector.Resource) ([]SanitizedResource, []InvalidResource, error) {
 	var sanitized []SanitizedResource
+	var invalid []InvalidResource
 
 	for _, resource := range resources {
 		sanitizedResource, err := ys.sanitizeResource(resource)
+		if errors.Is(err, ErrInvalidManifest) {
+			logging.FromContext(ctx).Warn("Skipping resource with an invalid manifest",
+				"kind", resource.Kind, "namespace", resource.Namespace, "name", resource.Name, "error", err)
+			invalid = append(invalid, InvalidResource{
+				APIVersion: resource.APIVersion,
+				Kind:       resource.Kind,
+				Namespace:  resource.Namespace,
+				Name:       resource.Name,
+				Err:        err,
+			})
+			continue
+		}
 		if err != nil {
-			return nil, fmt.Errorf("failed to sanitize resource %s/%s: %w",
+			return nil, nil, fmt.Errorf("failed to sanitize resource %s/%s: %w",
 				resource.Namespace, resource.Name, err)
 		}
 		sanitized = append(sanitized, sanitizedResource)
 	}
 
-	return sanitized, nil
+	return sanitized, invalid, nil
 }
 
 // sanitizeResource sanitizes a single Kubernetes resource
@@ -59,6 +94,12 @@ func (ys *YAMLSanitizer) sanitizeResource(resource collector.Resource) (Sanitize
 
 	unstructured := &unstructured.Unstructured{Object: unstructuredObj}
 
+	// Typed list items come back with an empty TypeMeta, so populate it to
+	// make the output apply-ready
+	if err := ys.setTypeMeta(unstructured, resource); err != nil {
+		return SanitizedResource{}, err
+	}
+
 	// Apply sanitization rules
 	ys.sanitizeMetadata(unstructured)
 	ys.sanitizeSpec(unstructured)
@@ -71,6 +112,19 @@ func (ys *YAMLSanitizer) sanitizeResource(resource collector.Resource) (Sanitize
 		return SanitizedResource{}, fmt.Errorf("failed to marshal to YAML: %w", err)
 	}
 
+	// Reject output that would not decode back into a valid object
+	if err := validateManifest(yamlBytes); err != nil {
+		return SanitizedResource{}, fmt.Errorf("%w: %w", ErrInvalidManifest, err)
+	}
+
+	// Encrypt or redact Secret values once the plain manifest has been validated
+	if unstructured.GetKind() == "Secret" && ys.config.SecretMode != "" && ys.config.SecretMode != config.SecretModePlain {
+		yamlBytes, err = ys.protectSecret(unstructured)
+		if err != nil {
+			return SanitizedResource{}, fmt.Errorf("failed to protect secret: %w", err)
+		}
+	}
+
 	return SanitizedResource{
 		APIVersion: resource.APIVersion,
 		Kind:       resource.Kind,
@@ -183,85 +237,68 @@ func (ys *YAMLSanitizer) sanitizeStatus(obj *unstructured.Unstructured) {
 	delete(obj.Object, "status")
 }
 
-// applyCustomStripFields applies static field stripping rules
+// applyCustomStripFields applies the configured STRIP_FIELDS rules
 func (ys *YAMLSanitizer) applyCustomStripFields(obj *unstructured.Unstructured) {
-	// Static list of fields to strip from all resources
-	staticStripFields := []string{
-		"metadata.uid",
-		"metadata.selfLink",
-		"metadata.resourceVersion", 
-		"metadata.generation",
-		"metadata.creationTimestamp",
-		"metadata.annotations[kubectl.kubernetes.io/last-applied-configuration]",
-		"metadata.annotations[deployment.kubernetes.io/revision]",
-		"status",
-		"spec.clusterIP",
-		"spec.clusterIPs",
-		"spec.ports[].nodePort",
-	}
-	
-	for _, fieldPath := range staticStripFields {
-		ys.removeFieldByPath(obj.Object, fieldPath)
+	for _, field := range ys.config.StripFields {
+		if field.Kind != "" && !strings.EqualFold(field.Kind, obj.GetKind()) {
+			continue
+		}
+		ys.removeFieldByPath(obj.Object, field.Path)
 	}
 }
 
 // removeFieldByPath removes a field specified by a dot-separated path
 func (ys *YAMLSanitizer) removeFieldByPath(obj map[string]interface{}, path string) {
-	if path == "" {
+	segments, err := config.ParseFieldPath(path)
+	if err != nil {
 		return
 	}
 
-	parts := strings.Split(path, ".")
-	if len(parts) == 1 {
-		delete(obj, parts[0])
-		return
-	}
+	removeField(obj, segments)
+}
 
-	// Handle nested paths
-	current := obj
-	for i, part := range parts[:len(parts)-1] {
-		// Handle array notation like "ports[].nodePort"
-		if strings.Contains(part, "[]") {
-			arrayField := strings.TrimSuffix(part, "[]")
-			if arrayValue, exists := current[arrayField]; exists {
-				if arraySlice, ok := arrayValue.([]interface{}); ok {
-					remainingPath := strings.Join(parts[i+1:], ".")
-					for _, item := range arraySlice {
-						if itemMap, ok := item.(map[string]interface{}); ok {
-							ys.removeFieldByPath(itemMap, remainingPath)
-						}
-					}
-				}
-			}
-			return
-		}
+// removeField walks the parsed path segments and removes the final field
+func removeField(obj map[string]interface{}, segments []string) {
+	part := segments[0]
+
+	if len(segments) == 1 {
+		// Handle map key syntax like "annotations[key]"
+		if open := strings.Index(part, "["); open != -1 {
+			fieldName := part[:open]
+			key := part[open+1 : len(part)-1]
 
-		// Handle special annotation syntax like "annotations[key]"
-		if strings.Contains(part, "[") && strings.Contains(part, "]") {
-			fieldName := part[:strings.Index(part, "[")]
-			key := part[strings.Index(part, "[")+1 : strings.Index(part, "]")]
+			if fieldMap, ok := obj[fieldName].(map[string]interface{}); ok {
+				delete(fieldMap, key)
 
-			if fieldValue, exists := current[fieldName]; exists {
-				if fieldMap, ok := fieldValue.(map[string]interface{}); ok {
-					delete(fieldMap, key)
+				// Remove if empty
+				if len(fieldMap) == 0 {
+					delete(obj, fieldName)
 				}
 			}
 			return
 		}
 
-		// Regular nested field
-		if nextLevel, exists := current[part]; exists {
-			if nextMap, ok := nextLevel.(map[string]interface{}); ok {
-				current = nextMap
-			} else {
-				return // Can't traverse further
+		delete(obj, part)
+		return
+	}
+
+	// Handle array notation like "ports[].nodePort"
+	if strings.HasSuffix(part, "[]") {
+		arrayField := strings.TrimSuffix(part, "[]")
+		if arraySlice, ok := obj[arrayField].([]interface{}); ok {
+			for _, item := range arraySlice {
+				if itemMap, ok := item.(map[string]interface{}); ok {
+					removeField(itemMap, segments[1:])
+				}
 			}
-		} else {
-			return // Path doesn't exist
 		}
+		return
 	}
 
-	// Remove the final field
-	finalField := parts[len(parts)-1]
-	delete(current, finalField)
+	// Regular nested field
+	nextMap, ok := obj[part].(map[string]interface{})
+	if !ok {
+		return // Path doesn't exist or can't traverse further
+	}
+	removeField(nextMap, segments[1:])
 }
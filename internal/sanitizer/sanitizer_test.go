@@ -1,11 +1,13 @@
 package sanitizer
 
 import (
+	"errors"
 	"testing"
 
 	"kube-git-backup/internal/collector"
 	"kube-git-backup/internal/config"
 
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
@@ -51,7 +53,7 @@ func TestSanitizeResources(t *testing.T) {
 	}
 
 	// Sanitize resources
-	sanitized, err := sanitizer.SanitizeResources(resources)
+	sanitized, _, err := sanitizer.SanitizeResources(t.Context(), resources)
 	if err != nil {
 		t.Fatalf("Failed to sanitize resources: %v", err)
 	}
@@ -246,3 +248,187 @@ func TestRemoveFieldByPath(t *testing.T) {
 		})
 	}
 }
+
+func TestSanitizeResourcesPopulatesTypeMeta(t *testing.T) {
+	sanitizer := NewYAMLSanitizer(config.SanitizerConfig{})
+
+	// Typed list items from the clientset have an empty TypeMeta
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "my-app",
+			Namespace: "production",
+		},
+	}
+
+	tests := []struct {
+		name     string
+		resource collector.Resource
+	}{
+		{
+			name: "from collected resource",
+			resource: collector.Resource{
+				APIVersion: "apps/v1",
+				Kind:       "Deployment",
+				Namespace:  "production",
+				Name:       "my-app",
+				Object:     deployment,
+			},
+		},
+		{
+			name: "from scheme",
+			resource: collector.Resource{
+				Namespace: "production",
+				Name:      "my-app",
+				Object:    deployment,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sanitized, _, err := sanitizer.SanitizeResources(t.Context(), []collector.Resource{tt.resource})
+			if err != nil {
+				t.Fatalf("Failed to sanitize resources: %v", err)
+			}
+
+			var obj unstructured.Unstructured
+			if err := yaml.Unmarshal(sanitized[0].YAML, &obj.Object); err != nil {
+				t.Fatalf("Failed to unmarshal sanitized YAML: %v", err)
+			}
+
+			if obj.GetAPIVersion() != "apps/v1" {
+				t.Errorf("Expected apiVersion 'apps/v1', got '%s'", obj.GetAPIVersion())
+			}
+			if obj.GetKind() != "Deployment" {
+				t.Errorf("Expected kind 'Deployment', got '%s'", obj.GetKind())
+			}
+		})
+	}
+}
+
+func TestValidateManifest(t *testing.T) {
+	tests := []struct {
+		name        string
+		manifest    string
+		expectError bool
+	}{
+		{
+			name:     "valid built-in kind",
+			manifest: "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: test\ndata:\n  key: value\n",
+		},
+		{
+			name:     "unknown kind is only checked for metadata",
+			manifest: "apiVersion: cert-manager.io/v1\nkind: Certificate\nmetadata:\n  name: test\nspec:\n  secretName: tls\n",
+		},
+		{
+			name:        "missing kind",
+			manifest:    "apiVersion: v1\nmetadata:\n  name: test\n",
+			expectError: true,
+		},
+		{
+			name:        "missing name",
+			manifest:    "apiVersion: v1\nkind: ConfigMap\nmetadata: {}\n",
+			expectError: true,
+		},
+		{
+			name:        "wrong field type for built-in kind",
+			manifest:    "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: test\nspec:\n  replicas: three\n",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateManifest([]byte(tt.manifest))
+			if tt.expectError && err == nil {
+				t.Error("Expected error but got none")
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("Expected no error but got: %v", err)
+			}
+		})
+	}
+}
+
+func TestSanitizeResourcesSkipsInvalidManifests(t *testing.T) {
+	// A strip rule removing the name leaves an invalid manifest
+	sanitizer := NewYAMLSanitizer(config.SanitizerConfig{
+		StripFields: []config.StripField{{Kind: "ConfigMap", Path: "metadata.name"}},
+	})
+
+	configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: "default"}}
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"}}
+
+	sanitized, invalid, err := sanitizer.SanitizeResources(t.Context(), []collector.Resource{
+		{APIVersion: "v1", Kind: "ConfigMap", Namespace: "default", Name: "settings", Object: configMap},
+		{APIVersion: "v1", Kind: "Service", Namespace: "default", Name: "web", Object: service},
+	})
+	if err != nil {
+		t.Fatalf("Expected invalid resources to be skipped, got: %v", err)
+	}
+	if len(sanitized) != 1 || sanitized[0].Name != "web" {
+		t.Fatalf("Expected only the valid resource, got %+v", sanitized)
+	}
+	if len(invalid) != 1 || invalid[0].Kind != "ConfigMap" || invalid[0].Name != "settings" || invalid[0].APIVersion != "v1" {
+		t.Fatalf("Expected the ConfigMap to be reported as invalid, got %+v", invalid)
+	}
+	if !errors.Is(invalid[0].Err, ErrInvalidManifest) {
+		t.Errorf("Expected ErrInvalidManifest, got: %v", invalid[0].Err)
+	}
+}
+
+func TestApplyCustomStripFields(t *testing.T) {
+	cfg := config.SanitizerConfig{
+		StripFields: []config.StripField{
+			{Path: "metadata.annotations[kubectl.kubernetes.io/last-applied-configuration]"},
+			{Kind: "Deployment", Path: "spec.template.metadata.annotations[foo]"},
+		},
+	}
+	sanitizer := NewYAMLSanitizer(cfg)
+
+	newObject := func(kind string) *unstructured.Unstructured {
+		return &unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"kind": kind,
+				"metadata": map[string]interface{}{
+					"name": "test",
+					"annotations": map[string]interface{}{
+						"kubectl.kubernetes.io/last-applied-configuration": "should-be-removed",
+					},
+				},
+				"spec": map[string]interface{}{
+					"template": map[string]interface{}{
+						"metadata": map[string]interface{}{
+							"annotations": map[string]interface{}{
+								"foo": "bar",
+								"baz": "qux",
+							},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	deployment := newObject("Deployment")
+	sanitizer.applyCustomStripFields(deployment)
+
+	if _, found, _ := unstructured.NestedMap(deployment.Object, "metadata", "annotations"); found {
+		t.Error("Expected empty annotations to be removed")
+	}
+	annotations, _, _ := unstructured.NestedStringMap(deployment.Object, "spec", "template", "metadata", "annotations")
+	if _, exists := annotations["foo"]; exists {
+		t.Error("Expected Deployment template annotation 'foo' to be removed")
+	}
+	if annotations["baz"] != "qux" {
+		t.Error("Expected Deployment template annotation 'baz' to remain")
+	}
+
+	statefulSet := newObject("StatefulSet")
+	sanitizer.applyCustomStripFields(statefulSet)
+
+	annotations, _, _ = unstructured.NestedStringMap(statefulSet.Object, "spec", "template", "metadata", "annotations")
+	if annotations["foo"] != "bar" {
+		t.Error("Expected kind-scoped rule to leave StatefulSet untouched")
+	}
+}
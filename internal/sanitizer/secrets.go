@@ -0,0 +1,404 @@
+package sanitizer
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"kube-git-backup/internal/config"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	yamlv3 "gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// sopsEncryptedRegex limits encryption to Secret payloads so keys and
+	// metadata stay readable in diffs
+	sopsEncryptedRegex = "^(data|stringData)$"
+
+	// sopsVersion is the SOPS file format version written to the metadata
+	sopsVersion = "3.9.0"
+
+	// sopsNonceSize matches the 32 byte IVs SOPS uses for AES-GCM
+	sopsNonceSize = 32
+
+	// sopsFingerprintMetadata is the SOPS metadata key of the plaintext
+	// fingerprint. SOPS ignores unknown metadata keys.
+	sopsFingerprintMetadata = "kube_git_backup_fingerprint"
+)
+
+// protectSecret encrypts or redacts the data and stringData values of a
+// Secret according to the configured secret mode and returns the YAML to write
+func (ys *YAMLSanitizer) protectSecret(obj *unstructured.Unstructured) ([]byte, error) {
+	switch ys.config.SecretMode {
+	case config.SecretModeSOPS:
+		encrypter, err := ys.sopsEncrypter()
+		if err != nil {
+			return nil, err
+		}
+		return encrypter.encrypt(obj.Object)
+	case config.SecretModeRedact:
+		walkSecretValues(obj.Object, nil, func(value interface{}, path []string) interface{} {
+			mac := hmac.New(sha256.New, []byte(ys.config.RedactKey))
+			mac.Write([]byte(fmt.Sprint(value)))
+			return "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil))
+		})
+		return yaml.Marshal(obj.Object)
+	default:
+		return nil, fmt.Errorf("unsupported secret mode: %s", ys.config.SecretMode)
+	}
+}
+
+// sopsEncrypter returns the encrypter shared by every Secret the sanitizer
+// encrypts, creating it on first use
+func (ys *YAMLSanitizer) sopsEncrypter() (*sopsEncrypter, error) {
+	ys.encrypterOnce.Do(func() {
+		ys.encrypter, ys.encrypterErr = newSOPSEncrypter(ys.config.AgeRecipients, ys.config.FingerprintKey)
+	})
+	return ys.encrypter, ys.encrypterErr
+}
+
+// sopsEncrypter encrypts Secrets in the SOPS format. It uses a single data
+// key for the lifetime of the process and derives the IV of every value from
+// its plaintext, so unchanged Secrets encrypt to the same bytes on every run
+// and a changed value only changes its own line and the MAC.
+type sopsEncrypter struct {
+	dataKey        []byte
+	ivKey          []byte
+	fingerprintKey []byte
+	ageKeys        []interface{}
+	lastModified   string
+}
+
+// newSOPSEncrypter creates an encrypter with a fresh data key wrapped for
+// each age recipient. Without a fingerprint key a random one is used, so
+// fingerprints only match within the process.
+func newSOPSEncrypter(recipients []string, fingerprintKey string) (*sopsEncrypter, error) {
+	encrypter := &sopsEncrypter{
+		dataKey:        make([]byte, 32),
+		ivKey:          make([]byte, 32),
+		fingerprintKey: []byte(fingerprintKey),
+		lastModified:   time.Now().UTC().Format(time.RFC3339),
+	}
+	if _, err := rand.Read(encrypter.dataKey); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	if _, err := rand.Read(encrypter.ivKey); err != nil {
+		return nil, fmt.Errorf("failed to generate IV key: %w", err)
+	}
+	if fingerprintKey == "" {
+		encrypter.fingerprintKey = make([]byte, 32)
+		if _, err := rand.Read(encrypter.fingerprintKey); err != nil {
+			return nil, fmt.Errorf("failed to generate fingerprint key: %w", err)
+		}
+	}
+
+	for _, recipient := range recipients {
+		encryptedKey, err := ageEncryptDataKey(encrypter.dataKey, recipient)
+		if err != nil {
+			return nil, err
+		}
+		encrypter.ageKeys = append(encrypter.ageKeys, map[string]interface{}{
+			"recipient": recipient,
+			"enc":       encryptedKey,
+		})
+	}
+
+	return encrypter, nil
+}
+
+// encrypt encrypts secret values in the SOPS format, so the file can be
+// decrypted with `sops -d`. The metadata records a keyed fingerprint of the
+// plaintext, which sinks compare to leave files encrypted by an earlier
+// process alone while the Secret is unchanged.
+func (e *sopsEncrypter) encrypt(obj map[string]interface{}) ([]byte, error) {
+	plainBytes, err := yaml.Marshal(obj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal to YAML: %w", err)
+	}
+	fingerprint := e.fingerprint(plainBytes)
+
+	// Encrypt values in place, remembering the plaintext for the MAC
+	plaintexts := make(map[string]interface{})
+	var encryptErr error
+	walkSecretValues(obj, nil, func(value interface{}, path []string) interface{} {
+		additionalData := strings.Join(path, ":") + ":"
+		encrypted, err := e.encryptValue(value, additionalData)
+		if err != nil {
+			encryptErr = err
+			return value
+		}
+		plaintexts[additionalData] = value
+		return encrypted
+	})
+	if encryptErr != nil {
+		return nil, fmt.Errorf("failed to encrypt secret value: %w", encryptErr)
+	}
+
+	yamlBytes, err := yaml.Marshal(obj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal to YAML: %w", err)
+	}
+
+	// SOPS computes the MAC over every value in document order, so walk the
+	// marshaled output rather than the (unordered) map
+	mac, err := sopsMAC(yamlBytes, plaintexts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute MAC: %w", err)
+	}
+
+	encryptedMAC, err := e.encryptValue(mac, e.lastModified)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt MAC: %w", err)
+	}
+
+	metadataBytes, err := yaml.Marshal(map[string]interface{}{
+		"sops": map[string]interface{}{
+			"age":                   e.ageKeys,
+			"lastmodified":          e.lastModified,
+			"mac":                   encryptedMAC,
+			"encrypted_regex":       sopsEncryptedRegex,
+			"version":               sopsVersion,
+			sopsFingerprintMetadata: fingerprint,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal SOPS metadata: %w", err)
+	}
+
+	return append(yamlBytes, metadataBytes...), nil
+}
+
+// encryptValue encrypts a value with an IV derived from the value and its
+// path, so the same value always encrypts to the same ciphertext while
+// different values never share an IV
+func (e *sopsEncrypter) encryptValue(value interface{}, additionalData string) (string, error) {
+	plaintext, valueType, err := sopsValueBytes(value)
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, e.ivKey)
+	for _, part := range [][]byte{[]byte(additionalData), []byte(valueType), plaintext} {
+		// Length prefixes keep the parts from running into each other
+		mac.Write(binary.BigEndian.AppendUint64(nil, uint64(len(part))))
+		mac.Write(part)
+	}
+
+	return sopsEncryptValue(plaintext, valueType, e.dataKey, mac.Sum(nil)[:sopsNonceSize], additionalData)
+}
+
+// fingerprint returns the keyed fingerprint of a plain Secret manifest. The
+// recipients are included so that changing them re-encrypts every Secret.
+func (e *sopsEncrypter) fingerprint(plainBytes []byte) string {
+	mac := hmac.New(sha256.New, e.fingerprintKey)
+	for _, ageKey := range e.ageKeys {
+		mac.Write([]byte(ageKey.(map[string]interface{})["recipient"].(string) + "\n"))
+	}
+	mac.Write(plainBytes)
+	return "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SameSecret reports whether two files are SOPS encrypted Secrets with the
+// same fingerprint, i.e. encrypted from the same plaintext for the same
+// recipients. The existing file can then be kept instead of rewriting it with
+// new ciphertext.
+func SameSecret(existing, content []byte) bool {
+	marker := []byte(sopsFingerprintMetadata + ":")
+	if !bytes.Contains(existing, marker) || !bytes.Contains(content, marker) {
+		return false
+	}
+
+	existingFingerprint := readFingerprint(existing)
+	return existingFingerprint != "" && existingFingerprint == readFingerprint(content)
+}
+
+// readFingerprint returns the fingerprint recorded in the SOPS metadata of a
+// file, or an empty string
+func readFingerprint(content []byte) string {
+	var file struct {
+		SOPS struct {
+			Fingerprint string `json:"kube_git_backup_fingerprint"`
+		} `json:"sops"`
+	}
+	if err := yaml.Unmarshal(content, &file); err != nil {
+		return ""
+	}
+	return file.SOPS.Fingerprint
+}
+
+// walkSecretValues calls fn for every scalar value whose path contains a
+// data or stringData key and replaces it with the returned value
+func walkSecretValues(value interface{}, path []string, fn func(value interface{}, path []string) interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = walkSecretValues(item, append(path[:len(path):len(path)], key), fn)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = walkSecretValues(item, path, fn)
+		}
+		return v
+	case nil:
+		return nil
+	default:
+		if isSecretPath(path) {
+			return fn(v, path)
+		}
+		return v
+	}
+}
+
+// isSecretPath mirrors how SOPS applies encrypted_regex to every key in the path
+func isSecretPath(path []string) bool {
+	for _, key := range path {
+		if key == "data" || key == "stringData" {
+			return true
+		}
+	}
+	return false
+}
+
+// sopsEncryptValue encrypts a single value in the SOPS ENC[...] format
+func sopsEncryptValue(plaintext []byte, valueType string, key, iv []byte, additionalData string) (string, error) {
+	// SOPS leaves empty strings unencrypted
+	if valueType == "str" && len(plaintext) == 0 {
+		return "", nil
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, sopsNonceSize)
+	if err != nil {
+		return "", err
+	}
+
+	out := gcm.Seal(nil, iv, plaintext, []byte(additionalData))
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:%s]",
+		base64.StdEncoding.EncodeToString(out[:len(out)-aes.BlockSize]),
+		base64.StdEncoding.EncodeToString(iv),
+		base64.StdEncoding.EncodeToString(out[len(out)-aes.BlockSize:]),
+		valueType), nil
+}
+
+// sopsValueBytes converts a value to the byte representation and type tag SOPS uses
+func sopsValueBytes(value interface{}) ([]byte, string, error) {
+	switch v := value.(type) {
+	case string:
+		return []byte(v), "str", nil
+	case int:
+		return []byte(strconv.Itoa(v)), "int", nil
+	case int64:
+		return []byte(strconv.FormatInt(v, 10)), "int", nil
+	case float64:
+		return []byte(strconv.FormatFloat(v, 'f', -1, 64)), "float", nil
+	case bool:
+		// SOPS encodes booleans in title case
+		if v {
+			return []byte("True"), "bool", nil
+		}
+		return []byte("False"), "bool", nil
+	case time.Time:
+		text, err := v.MarshalText()
+		return text, "time", err
+	default:
+		return nil, "", fmt.Errorf("unsupported value type %T", value)
+	}
+}
+
+// sopsMAC computes the SHA-512 MAC over all values of the document in order,
+// using the recorded plaintext for values that were encrypted
+func sopsMAC(yamlBytes []byte, plaintexts map[string]interface{}) (string, error) {
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal(yamlBytes, &doc); err != nil {
+		return "", err
+	}
+
+	hash := sha512.New()
+	var walk func(node *yamlv3.Node, path []string) error
+	walk = func(node *yamlv3.Node, path []string) error {
+		switch node.Kind {
+		case yamlv3.DocumentNode, yamlv3.SequenceNode:
+			for _, item := range node.Content {
+				if err := walk(item, path); err != nil {
+					return err
+				}
+			}
+		case yamlv3.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if err := walk(node.Content[i+1], append(path[:len(path):len(path)], node.Content[i].Value)); err != nil {
+					return err
+				}
+			}
+		case yamlv3.AliasNode:
+			return walk(node.Alias, path)
+		case yamlv3.ScalarNode:
+			var value interface{}
+			if err := node.Decode(&value); err != nil {
+				return err
+			}
+			if value == nil {
+				return nil
+			}
+			if plaintext, ok := plaintexts[strings.Join(path, ":")+":"]; ok {
+				value = plaintext
+			}
+			valueBytes, _, err := sopsValueBytes(value)
+			if err != nil {
+				return err
+			}
+			hash.Write(valueBytes)
+		}
+		return nil
+	}
+
+	if err := walk(&doc, nil); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%X", hash.Sum(nil)), nil
+}
+
+// ageEncryptDataKey wraps the SOPS data key for a single age recipient
+func ageEncryptDataKey(dataKey []byte, recipient string) (string, error) {
+	parsed, err := age.ParseX25519Recipient(recipient)
+	if err != nil {
+		return "", fmt.Errorf("invalid age recipient %s: %w", recipient, err)
+	}
+
+	var buffer bytes.Buffer
+	armorWriter := armor.NewWriter(&buffer)
+	writer, err := age.Encrypt(armorWriter, parsed)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt data key for %s: %w", recipient, err)
+	}
+	if _, err := writer.Write(dataKey); err != nil {
+		return "", fmt.Errorf("failed to encrypt data key for %s: %w", recipient, err)
+	}
+	if err := writer.Close(); err != nil {
+		return "", fmt.Errorf("failed to encrypt data key for %s: %w", recipient, err)
+	}
+	if err := armorWriter.Close(); err != nil {
+		return "", fmt.Errorf("failed to encrypt data key for %s: %w", recipient, err)
+	}
+
+	return buffer.String(), nil
+}
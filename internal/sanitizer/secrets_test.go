@@ -0,0 +1,254 @@
+package sanitizer
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+
+	"kube-git-backup/internal/collector"
+	"kube-git-backup/internal/config"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func newTestSecret() collector.Resource {
+	return collector.Resource{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Namespace:  "production",
+		Name:       "db-credentials",
+		Object: &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "db-credentials",
+				Namespace: "production",
+				Labels:    map[string]string{"app": "db"},
+			},
+			Type: corev1.SecretTypeOpaque,
+			Data: map[string][]byte{
+				"password": []byte("s3cr3t"),
+				"username": []byte("admin"),
+			},
+		},
+	}
+}
+
+func TestProtectSecretRedact(t *testing.T) {
+	sanitizer := NewYAMLSanitizer(config.SanitizerConfig{SecretMode: config.SecretModeRedact, RedactKey: "redact-key"})
+
+	sanitized, _, err := sanitizer.SanitizeResources(t.Context(), []collector.Resource{newTestSecret()})
+	if err != nil {
+		t.Fatalf("Failed to sanitize resources: %v", err)
+	}
+
+	var secret map[string]interface{}
+	if err := yaml.Unmarshal(sanitized[0].YAML, &secret); err != nil {
+		t.Fatalf("Failed to unmarshal sanitized YAML: %v", err)
+	}
+
+	data := secret["data"].(map[string]interface{})
+	mac := hmac.New(sha256.New, []byte("redact-key"))
+	mac.Write([]byte(base64.StdEncoding.EncodeToString([]byte("s3cr3t"))))
+	if expected := "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil)); data["password"] != expected {
+		t.Errorf("Expected password fingerprint %s, got '%v'", expected, data["password"])
+	}
+	if strings.Contains(string(sanitized[0].YAML), "czNjcjN0") {
+		t.Error("Expected plain secret value to be removed")
+	}
+
+	// Fingerprints must be stable so unchanged secrets don't produce diffs
+	again, _, err := sanitizer.SanitizeResources(t.Context(), []collector.Resource{newTestSecret()})
+	if err != nil {
+		t.Fatalf("Failed to sanitize resources: %v", err)
+	}
+	if string(again[0].YAML) != string(sanitized[0].YAML) {
+		t.Error("Expected redacted output to be deterministic")
+	}
+
+	// Without the key, the fingerprint of a guessed value doesn't match
+	other := NewYAMLSanitizer(config.SanitizerConfig{SecretMode: config.SecretModeRedact, RedactKey: "other-key"})
+	guessed, _, err := other.SanitizeResources(t.Context(), []collector.Resource{newTestSecret()})
+	if err != nil {
+		t.Fatalf("Failed to sanitize resources: %v", err)
+	}
+	if string(guessed[0].YAML) == string(sanitized[0].YAML) {
+		t.Error("Expected the fingerprints to depend on the key")
+	}
+}
+
+func TestProtectSecretSOPS(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("Failed to generate age identity: %v", err)
+	}
+
+	sanitizer := NewYAMLSanitizer(config.SanitizerConfig{
+		SecretMode:    config.SecretModeSOPS,
+		AgeRecipients: []string{identity.Recipient().String()},
+	})
+
+	sanitized, _, err := sanitizer.SanitizeResources(t.Context(), []collector.Resource{newTestSecret()})
+	if err != nil {
+		t.Fatalf("Failed to sanitize resources: %v", err)
+	}
+
+	var secret map[string]interface{}
+	if err := yaml.Unmarshal(sanitized[0].YAML, &secret); err != nil {
+		t.Fatalf("Failed to unmarshal sanitized YAML: %v", err)
+	}
+
+	// Keys and metadata stay readable
+	metadata := secret["metadata"].(map[string]interface{})
+	if metadata["name"] != "db-credentials" {
+		t.Errorf("Expected readable metadata, got %v", metadata)
+	}
+
+	sops, ok := secret["sops"].(map[string]interface{})
+	if !ok {
+		t.Fatal("Expected sops metadata")
+	}
+	ageKeys := sops["age"].([]interface{})
+	ageKey := ageKeys[0].(map[string]interface{})
+	if ageKey["recipient"] != identity.Recipient().String() {
+		t.Errorf("Expected recipient %s, got %v", identity.Recipient(), ageKey["recipient"])
+	}
+
+	// Unwrap the data key and decrypt the values
+	reader, err := age.Decrypt(armor.NewReader(strings.NewReader(ageKey["enc"].(string))), identity)
+	if err != nil {
+		t.Fatalf("Failed to decrypt data key: %v", err)
+	}
+	dataKey, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Failed to read data key: %v", err)
+	}
+
+	data := secret["data"].(map[string]interface{})
+	password := decryptTestValue(t, data["password"].(string), dataKey, "data:password:")
+	if password != base64.StdEncoding.EncodeToString([]byte("s3cr3t")) {
+		t.Errorf("Expected decrypted password, got '%s'", password)
+	}
+
+	// The MAC must match the plaintext values in document order
+	plaintexts := map[string]interface{}{
+		"data:password:": base64.StdEncoding.EncodeToString([]byte("s3cr3t")),
+		"data:username:": base64.StdEncoding.EncodeToString([]byte("admin")),
+	}
+	document := sanitized[0].YAML[:strings.Index(string(sanitized[0].YAML), "sops:")]
+	expectedMAC, err := sopsMAC(document, plaintexts)
+	if err != nil {
+		t.Fatalf("Failed to compute MAC: %v", err)
+	}
+	mac := decryptTestValue(t, sops["mac"].(string), dataKey, sops["lastmodified"].(string))
+	if mac != expectedMAC {
+		t.Errorf("Expected MAC %s, got %s", expectedMAC, mac)
+	}
+}
+
+func TestProtectSecretSOPSDeterministic(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("Failed to generate age identity: %v", err)
+	}
+	cfg := config.SanitizerConfig{
+		SecretMode:     config.SecretModeSOPS,
+		AgeRecipients:  []string{identity.Recipient().String()},
+		FingerprintKey: "fingerprint-key",
+	}
+	sanitizer := NewYAMLSanitizer(cfg)
+
+	first, _, err := sanitizer.SanitizeResources(t.Context(), []collector.Resource{newTestSecret()})
+	if err != nil {
+		t.Fatalf("Failed to sanitize resources: %v", err)
+	}
+	second, _, err := sanitizer.SanitizeResources(t.Context(), []collector.Resource{newTestSecret()})
+	if err != nil {
+		t.Fatalf("Failed to sanitize resources: %v", err)
+	}
+	if string(first[0].YAML) != string(second[0].YAML) {
+		t.Errorf("Expected an unchanged Secret to encrypt to the same bytes, got\n%s\nand\n%s",
+			first[0].YAML, second[0].YAML)
+	}
+
+	// A changed value only changes its own ciphertext and the MAC
+	changedSecret := newTestSecret()
+	changedSecret.Object.(*corev1.Secret).Data["password"] = []byte("n3w")
+	changed, _, err := sanitizer.SanitizeResources(t.Context(), []collector.Resource{changedSecret})
+	if err != nil {
+		t.Fatalf("Failed to sanitize resources: %v", err)
+	}
+	var changedLines []string
+	firstLines := strings.Split(string(first[0].YAML), "\n")
+	for i, line := range strings.Split(string(changed[0].YAML), "\n") {
+		if line != firstLines[i] {
+			changedLines = append(changedLines, strings.Fields(line)[0])
+		}
+	}
+	if strings.Join(changedLines, ",") != "password:,kube_git_backup_fingerprint:,mac:" {
+		t.Errorf("Expected only the password, MAC and fingerprint to change, got %v", changedLines)
+	}
+	if SameSecret(first[0].YAML, changed[0].YAML) {
+		t.Error("Expected a changed Secret to have another fingerprint")
+	}
+
+	// Another process encrypts with another data key, but the fingerprint
+	// shows that the plaintext didn't change
+	restarted, _, err := NewYAMLSanitizer(cfg).SanitizeResources(t.Context(), []collector.Resource{newTestSecret()})
+	if err != nil {
+		t.Fatalf("Failed to sanitize resources: %v", err)
+	}
+	if string(restarted[0].YAML) == string(first[0].YAML) {
+		t.Error("Expected a new data key after a restart")
+	}
+	if !SameSecret(first[0].YAML, restarted[0].YAML) {
+		t.Error("Expected the fingerprints of an unchanged Secret to match after a restart")
+	}
+
+	cfg.FingerprintKey = "other-key"
+	rekeyed, _, err := NewYAMLSanitizer(cfg).SanitizeResources(t.Context(), []collector.Resource{newTestSecret()})
+	if err != nil {
+		t.Fatalf("Failed to sanitize resources: %v", err)
+	}
+	if SameSecret(first[0].YAML, rekeyed[0].YAML) {
+		t.Error("Expected fingerprints with another key not to match")
+	}
+}
+
+var encPattern = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.*),iv:(.*),tag:(.*),type:str\]$`)
+
+func decryptTestValue(t *testing.T, value string, key []byte, additionalData string) string {
+	t.Helper()
+
+	matches := encPattern.FindStringSubmatch(value)
+	if matches == nil {
+		t.Fatalf("Value is not in SOPS format: %s", value)
+	}
+
+	data, _ := base64.StdEncoding.DecodeString(matches[1])
+	iv, _ := base64.StdEncoding.DecodeString(matches[2])
+	tag, _ := base64.StdEncoding.DecodeString(matches[3])
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatalf("Failed to create cipher: %v", err)
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		t.Fatalf("Failed to create GCM: %v", err)
+	}
+	plaintext, err := gcm.Open(nil, iv, append(data, tag...), []byte(additionalData))
+	if err != nil {
+		t.Fatalf("Failed to decrypt value: %v", err)
+	}
+	return string(plaintext)
+}
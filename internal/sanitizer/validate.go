@@ -0,0 +1,72 @@
+package sanitizer
+
+import (
+	"fmt"
+
+	"kube-git-backup/internal/collector"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/yaml"
+)
+
+// setTypeMeta populates apiVersion and kind from the collected resource,
+// falling back to the client-go scheme for typed objects
+func (ys *YAMLSanitizer) setTypeMeta(obj *unstructured.Unstructured, resource collector.Resource) error {
+	apiVersion := resource.APIVersion
+	kind := resource.Kind
+
+	if apiVersion == "" || kind == "" {
+		gvks, _, err := scheme.Scheme.ObjectKinds(resource.Object)
+		if err != nil || len(gvks) == 0 {
+			return fmt.Errorf("unable to determine apiVersion and kind for %s/%s", resource.Namespace, resource.Name)
+		}
+		if apiVersion == "" {
+			apiVersion = gvks[0].GroupVersion().String()
+		}
+		if kind == "" {
+			kind = gvks[0].Kind
+		}
+	}
+
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(kind)
+	return nil
+}
+
+// validateManifest checks that the sanitized YAML decodes back into a valid
+// object. Built-in kinds are decoded through the client-go scheme, which catches
+// wrong field types but allows unknown fields, as the API server may be newer than
+// client-go. Other kinds (e.g. custom resources) are only checked for type and
+// object metadata.
+func validateManifest(data []byte) error {
+	obj := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal(data, &obj.Object); err != nil {
+		return fmt.Errorf("failed to decode YAML: %w", err)
+	}
+
+	if obj.GetAPIVersion() == "" {
+		return fmt.Errorf("missing apiVersion")
+	}
+	if obj.GetKind() == "" {
+		return fmt.Errorf("missing kind")
+	}
+	if obj.GetName() == "" {
+		return fmt.Errorf("missing metadata.name")
+	}
+
+	gvk := obj.GroupVersionKind()
+	if !scheme.Scheme.Recognizes(gvk) {
+		return nil
+	}
+
+	decoded, decodedGVK, err := scheme.Codecs.UniversalDeserializer().Decode(data, nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to decode %s: %w", gvk.Kind, err)
+	}
+	if decoded == nil || *decodedGVK != gvk {
+		return fmt.Errorf("decoded %v, expected %v", decodedGVK, gvk)
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package schedule
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+	_ "time/tzdata" // Timezones must resolve in minimal container images
+
+	"kube-git-backup/internal/config"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Scheduler computes backup run times from BACKUP_SCHEDULE, falling back to
+// a fixed BACKUP_INTERVAL
+type Scheduler struct {
+	expression string
+	cron       cron.Schedule // Nil when running on a fixed interval
+	interval   time.Duration
+	jitter     time.Duration
+	location   *time.Location
+}
+
+// NewScheduler creates a Scheduler from the backup settings
+func NewScheduler(cfg *config.Config) (*Scheduler, error) {
+	location, err := Location(cfg.BackupTimezone)
+	if err != nil {
+		return nil, err
+	}
+
+	scheduler := &Scheduler{
+		expression: cfg.BackupSchedule,
+		interval:   cfg.BackupInterval,
+		jitter:     cfg.BackupJitter,
+		location:   location,
+	}
+
+	if cfg.BackupSchedule != "" {
+		scheduler.cron, err = Parse(cfg.BackupSchedule)
+		if err != nil {
+			return nil, err
+		}
+		if scheduler.Next(time.Now()).IsZero() {
+			return nil, fmt.Errorf("cron expression '%s' never runs", cfg.BackupSchedule)
+		}
+	}
+
+	return scheduler, nil
+}
+
+// Parse parses a standard 5-field cron expression or descriptor such as
+// "@daily". The expression may start with CRON_TZ=<zone> to override the
+// timezone of this schedule.
+func Parse(expression string) (cron.Schedule, error) {
+	schedule, err := cron.ParseStandard(expression)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cron expression '%s': %w", expression, err)
+	}
+	return schedule, nil
+}
+
+// Location resolves an IANA timezone name, defaulting to UTC
+func Location(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone '%s': %w", name, err)
+	}
+	return location, nil
+}
+
+// Next returns the first scheduled run after t, without jitter
+func (s *Scheduler) Next(t time.Time) time.Time {
+	if s.cron == nil {
+		return t.Add(s.interval)
+	}
+	return s.cron.Next(t.In(s.location))
+}
+
+// Jitter returns a random delay to add to a scheduled run
+func (s *Scheduler) Jitter() time.Duration {
+	if s.jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(s.jitter)))
+}
+
+// Period returns the longest gap between the upcoming scheduled runs,
+// including jitter, which bounds how long a healthy daemon can go
+// without a backup
+func (s *Scheduler) Period() time.Duration {
+	if s.cron == nil {
+		return s.interval + s.jitter
+	}
+
+	// Look far enough ahead to cover weekly and monthly schedules
+	var longest time.Duration
+	previous := s.Next(time.Now())
+	for i := 0; i < 60; i++ {
+		next := s.Next(previous)
+		if next.IsZero() {
+			break
+		}
+		if gap := next.Sub(previous); gap > longest {
+			longest = gap
+		}
+		previous = next
+	}
+
+	return longest + s.jitter
+}
+
+// String describes the schedule for logging
+func (s *Scheduler) String() string {
+	if s.cron == nil {
+		return fmt.Sprintf("every %s", s.interval)
+	}
+	return fmt.Sprintf("'%s' in %s", s.expression, s.location)
+}
@@ -0,0 +1,88 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"kube-git-backup/internal/config"
+)
+
+func TestNext(t *testing.T) {
+	start := time.Date(2024, 6, 1, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		config   *config.Config
+		expected time.Time
+	}{
+		{
+			name:     "interval fallback",
+			config:   &config.Config{BackupInterval: time.Hour},
+			expected: start.Add(time.Hour),
+		},
+		{
+			name:     "cron in UTC",
+			config:   &config.Config{BackupInterval: time.Hour, BackupSchedule: "0 2 * * *"},
+			expected: time.Date(2024, 6, 2, 2, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "cron in configured timezone",
+			config:   &config.Config{BackupInterval: time.Hour, BackupSchedule: "0 2 * * *", BackupTimezone: "Europe/Berlin"},
+			expected: time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "CRON_TZ prefix overrides timezone",
+			config:   &config.Config{BackupInterval: time.Hour, BackupSchedule: "CRON_TZ=Asia/Tokyo 0 2 * * *", BackupTimezone: "Europe/Berlin"},
+			expected: time.Date(2024, 6, 1, 17, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheduler, err := NewScheduler(tt.config)
+			if err != nil {
+				t.Fatalf("Failed to create scheduler: %v", err)
+			}
+			if got := scheduler.Next(start); !got.Equal(tt.expected) {
+				t.Errorf("Expected %s, got %s", tt.expected, got.UTC())
+			}
+		})
+	}
+}
+
+func TestNewSchedulerErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config *config.Config
+	}{
+		{"invalid expression", &config.Config{BackupSchedule: "0 2 * *"}},
+		{"invalid timezone", &config.Config{BackupSchedule: "0 2 * * *", BackupTimezone: "Mars/Olympus"}},
+		{"never runs", &config.Config{BackupSchedule: "0 0 30 2 *"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewScheduler(tt.config); err == nil {
+				t.Errorf("Expected an error")
+			}
+		})
+	}
+}
+
+func TestPeriodAndJitter(t *testing.T) {
+	scheduler, err := NewScheduler(&config.Config{BackupSchedule: "0 2 * * 1-5", BackupJitter: 10 * time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Weekdays only, so the longest gap is Friday to Monday
+	if period := scheduler.Period(); period != 72*time.Hour+10*time.Minute {
+		t.Errorf("Expected period of 72h10m, got %s", period)
+	}
+
+	for i := 0; i < 100; i++ {
+		if jitter := scheduler.Jitter(); jitter < 0 || jitter >= 10*time.Minute {
+			t.Fatalf("Jitter %s out of range", jitter)
+		}
+	}
+}
@@ -0,0 +1,367 @@
+package sink
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"kube-git-backup/internal/config"
+	"kube-git-backup/internal/logging"
+	"kube-git-backup/internal/metrics"
+	"kube-git-backup/internal/sanitizer"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Archive writes every snapshot as a single compressed tarball named
+// <cluster>-<timestamp>.tar.gz (or .tar.zst) to a local directory. Each
+// archive starts with a manifest.json listing the files and their checksums.
+// Old archives of the cluster are removed according to the retention settings.
+type Archive struct {
+	dir         string
+	cluster     string
+	layout      *Layout
+	compression string
+	keepCount   int           // Number of archives to keep, 0 keeps all
+	keepAge     time.Duration // Maximum age of archives, 0 keeps all
+	previous    *Manifest     // Manifest of the newest archive, loaded on first use
+	pending     *archiveSnapshot
+	now         func() time.Time
+}
+
+// archiveSnapshot is a snapshot staged by WriteSnapshot
+type archiveSnapshot struct {
+	files    []snapshotFile
+	manifest *Manifest
+}
+
+// archiveFile is an existing archive of the cluster
+type archiveFile struct {
+	name        string
+	compression string
+	createdAt   time.Time
+}
+
+// NewArchive creates an archive sink writing to cfg.Dir
+func NewArchive(cfg config.ArchiveConfig, clusterName string, layout *Layout) (*Archive, error) {
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory %s: %w", cfg.Dir, err)
+	}
+
+	return &Archive{
+		dir:         cfg.Dir,
+		cluster:     clusterName,
+		layout:      layout,
+		compression: cfg.Compression,
+		keepCount:   cfg.RetentionCount,
+		keepAge:     cfg.RetentionAge,
+		now:         time.Now,
+	}, nil
+}
+
+// Name returns the name of the sink
+func (a *Archive) Name() string {
+	return "archive"
+}
+
+// WriteSnapshot stages a snapshot, the archive is written by Finalize
+func (a *Archive) WriteSnapshot(ctx context.Context, resources []sanitizer.SanitizedResource) error {
+	files, err := snapshotFiles(a.layout, resources)
+	if err != nil {
+		return err
+	}
+	a.pending = &archiveSnapshot{
+		files:    files,
+		manifest: newManifest(ctx, a.cluster, a.now(), files),
+	}
+	return nil
+}
+
+// Finalize writes the staged snapshot to a new archive, removes archives
+// outside the retention settings and reports the changes since the previous
+// archive
+func (a *Archive) Finalize(ctx context.Context) (Result, error) {
+	snapshot := a.pending
+	if snapshot == nil {
+		return Result{}, nil
+	}
+	a.pending = nil
+
+	previous, err := a.previousManifest()
+	if err != nil {
+		return Result{}, err
+	}
+
+	writeStart := time.Now()
+	name := a.archiveName(snapshot.manifest.CreatedAt, a.compression)
+	snapshot.manifest.Location = filepath.Join(a.dir, name)
+	if err := a.writeFile(name, snapshot); err != nil {
+		return Result{}, err
+	}
+	metrics.ObservePhase(a.cluster, metrics.PhaseWrite, writeStart)
+
+	a.previous = snapshot.manifest
+	logging.FromContext(ctx).Info("Wrote archive", "path", snapshot.manifest.Location,
+		"resources", snapshot.manifest.Resources)
+
+	if err := a.applyRetention(ctx, name); err != nil {
+		return Result{}, err
+	}
+
+	return Result{
+		Reference: snapshot.manifest.Location,
+		Changes:   diffManifests(previous, snapshot.manifest),
+	}, nil
+}
+
+// Close does nothing, archives are closed as soon as they are written
+func (a *Archive) Close() error {
+	return nil
+}
+
+// archiveName returns the file name of the archive created at createdAt
+func (a *Archive) archiveName(createdAt time.Time, compression string) string {
+	return a.cluster + "-" + createdAt.UTC().Format(snapshotTimeFormat) + archiveExtensions[compression]
+}
+
+// writeFile writes the archive to a temporary file and renames it into
+// place, so that an interrupted backup never leaves a truncated archive
+func (a *Archive) writeFile(name string, snapshot *archiveSnapshot) error {
+	tmp, err := os.CreateTemp(a.dir, ".archive-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create archive in %s: %w", a.dir, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := writeArchive(tmp, a.compression, snapshot.files, snapshot.manifest); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write archive %s: %w", name, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write archive %s: %w", name, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write archive %s: %w", name, err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to set permissions of archive %s: %w", name, err)
+	}
+
+	if err := os.Rename(tmp.Name(), filepath.Join(a.dir, name)); err != nil {
+		return fmt.Errorf("failed to move archive %s into place: %w", name, err)
+	}
+	return nil
+}
+
+// previousManifest returns the manifest of the newest archive, or nil if
+// there is none
+func (a *Archive) previousManifest() (*Manifest, error) {
+	if a.previous != nil {
+		return a.previous, nil
+	}
+
+	archives, err := a.listArchives()
+	if err != nil {
+		return nil, err
+	}
+	if len(archives) == 0 {
+		return nil, nil
+	}
+
+	newest := archives[0]
+	file, err := os.Open(filepath.Join(a.dir, newest.name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive %s: %w", newest.name, err)
+	}
+	defer file.Close()
+
+	manifest, err := readArchiveManifest(file, newest.compression)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest of archive %s: %w", newest.name, err)
+	}
+	return manifest, nil
+}
+
+// applyRetention removes the archives beyond the retention count or older
+// than the retention age. The archive just written is always kept.
+func (a *Archive) applyRetention(ctx context.Context, current string) error {
+	if a.keepCount == 0 && a.keepAge == 0 {
+		return nil
+	}
+
+	archives, err := a.listArchives()
+	if err != nil {
+		return err
+	}
+
+	cutoff := a.now().Add(-a.keepAge)
+	for i, archive := range archives {
+		if archive.name == current {
+			continue
+		}
+		expired := a.keepCount > 0 && i >= a.keepCount
+		if a.keepAge > 0 && archive.createdAt.Before(cutoff) {
+			expired = true
+		}
+		if !expired {
+			continue
+		}
+
+		logging.FromContext(ctx).Info("Removing old archive", "path", filepath.Join(a.dir, archive.name))
+		if err := os.Remove(filepath.Join(a.dir, archive.name)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove archive %s: %w", archive.name, err)
+		}
+	}
+
+	return nil
+}
+
+// listArchives returns the archives of the cluster in the directory, newest
+// first. Files that don't follow the archive naming scheme are ignored.
+func (a *Archive) listArchives() ([]archiveFile, error) {
+	entries, err := os.ReadDir(a.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list archives in %s: %w", a.dir, err)
+	}
+
+	var archives []archiveFile
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !strings.HasPrefix(entry.Name(), a.cluster+"-") {
+			continue
+		}
+		for compression, extension := range archiveExtensions {
+			timestamp, ok := strings.CutSuffix(strings.TrimPrefix(entry.Name(), a.cluster+"-"), extension)
+			if !ok {
+				continue
+			}
+			createdAt, err := time.Parse(snapshotTimeFormat, timestamp)
+			if err != nil {
+				continue
+			}
+			archives = append(archives, archiveFile{name: entry.Name(), compression: compression, createdAt: createdAt})
+		}
+	}
+
+	sort.Slice(archives, func(i, j int) bool {
+		return archives[i].createdAt.After(archives[j].createdAt)
+	})
+
+	return archives, nil
+}
+
+// archiveExtensions maps the archive compressions to their file extensions
+var archiveExtensions = map[string]string{
+	config.ArchiveCompressionGzip: ".tar.gz",
+	config.ArchiveCompressionZstd: ".tar.zst",
+}
+
+// writeArchive writes the manifest followed by the files of a snapshot as a
+// tar archive with the given compression ("gzip" or "zstd")
+func writeArchive(w io.Writer, compression string, files []snapshotFile, manifest *Manifest) error {
+	var compressor io.WriteCloser
+	switch compression {
+	case config.ArchiveCompressionGzip:
+		compressor = gzip.NewWriter(w)
+	case config.ArchiveCompressionZstd:
+		encoder, err := zstd.NewWriter(w)
+		if err != nil {
+			return fmt.Errorf("failed to create zstd encoder: %w", err)
+		}
+		compressor = encoder
+	default:
+		return fmt.Errorf("unsupported archive compression '%s'", compression)
+	}
+
+	if err := writeTar(compressor, files, manifest); err != nil {
+		compressor.Close()
+		return err
+	}
+	return compressor.Close()
+}
+
+// readArchiveManifest reads the manifest at the start of an archive written
+// by writeArchive
+func readArchiveManifest(r io.Reader, compression string) (*Manifest, error) {
+	var decompressor io.Reader
+	switch compression {
+	case config.ArchiveCompressionGzip:
+		gzipReader, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer gzipReader.Close()
+		decompressor = gzipReader
+	case config.ArchiveCompressionZstd:
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer decoder.Close()
+		decompressor = decoder
+	default:
+		return nil, fmt.Errorf("unsupported archive compression '%s'", compression)
+	}
+
+	tarReader := tar.NewReader(decompressor)
+	header, err := tarReader.Next()
+	if err != nil {
+		return nil, err
+	}
+	if header.Name != ManifestFileName {
+		return nil, fmt.Errorf("archive does not start with %s", ManifestFileName)
+	}
+
+	var manifest Manifest
+	if err := json.NewDecoder(tarReader).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", ManifestFileName, err)
+	}
+	return &manifest, nil
+}
+
+// writeTar writes the manifest followed by the files of a snapshot as a tar archive
+func writeTar(w io.Writer, files []snapshotFile, manifest *Manifest) error {
+	tarWriter := tar.NewWriter(w)
+
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	if err := writeTarFile(tarWriter, ManifestFileName, content, manifest.CreatedAt); err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if err := writeTarFile(tarWriter, file.path, file.resource.YAML, manifest.CreatedAt); err != nil {
+			return err
+		}
+	}
+
+	return tarWriter.Close()
+}
+
+// writeTarFile adds a regular file to a tar archive
+func writeTarFile(tarWriter *tar.Writer, name string, content []byte, modTime time.Time) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(content)),
+		ModTime: modTime,
+		Format:  tar.FormatPAX,
+	}
+	if err := tarWriter.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write archive header for %s: %w", name, err)
+	}
+	if _, err := tarWriter.Write(content); err != nil {
+		return fmt.Errorf("failed to write %s to archive: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package sink
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"kube-git-backup/internal/config"
+)
+
+func TestArchiveRoundTrip(t *testing.T) {
+	for _, compression := range []string{config.ArchiveCompressionGzip, config.ArchiveCompressionZstd} {
+		t.Run(compression, func(t *testing.T) {
+			files, err := snapshotFiles(DefaultLayout, testResources())
+			if err != nil {
+				t.Fatalf("Failed to render paths: %v", err)
+			}
+			manifest := newManifest(context.Background(), "production", time.Date(2024, 6, 1, 2, 30, 0, 0, time.UTC), files)
+
+			var buf bytes.Buffer
+			if err := writeArchive(&buf, compression, files, manifest); err != nil {
+				t.Fatalf("Failed to write archive: %v", err)
+			}
+
+			read, err := readArchiveManifest(bytes.NewReader(buf.Bytes()), compression)
+			if err != nil {
+				t.Fatalf("Failed to read manifest: %v", err)
+			}
+			if read.Cluster != "production" || read.Resources != 2 || len(read.Files) != 2 {
+				t.Errorf("Unexpected manifest: %+v", read)
+			}
+			for i, entry := range read.Files {
+				checksum := sha256.Sum256(files[i].resource.YAML)
+				if entry.Path != files[i].path || entry.SHA256 != hex.EncodeToString(checksum[:]) {
+					t.Errorf("Unexpected manifest entry: %+v", entry)
+				}
+			}
+		})
+	}
+}
+
+func TestArchiveSink(t *testing.T) {
+	dir := t.TempDir()
+	ctx := WithCollection(context.Background(), Collection{
+		RunID:  "abc123",
+		Errors: map[string]string{"widgets.example.com": "forbidden"},
+	})
+
+	a, err := NewArchive(config.ArchiveConfig{Dir: dir, Compression: config.ArchiveCompressionZstd}, "production", DefaultLayout)
+	if err != nil {
+		t.Fatalf("Failed to create archive sink: %v", err)
+	}
+	a.now = func() time.Time { return time.Date(2024, 6, 1, 2, 30, 0, 0, time.UTC) }
+
+	if err := a.WriteSnapshot(ctx, testResources()); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+	result, err := a.Finalize(ctx)
+	if err != nil {
+		t.Fatalf("Failed to finalize: %v", err)
+	}
+
+	expectedPath := filepath.Join(dir, "production-20240601T023000Z.tar.zst")
+	if result.Reference != expectedPath {
+		t.Errorf("Expected reference %s, got %s", expectedPath, result.Reference)
+	}
+	if added, _, _ := result.Counts(); added != 2 {
+		t.Errorf("Expected 2 added resources, got %d", added)
+	}
+
+	file, err := os.Open(expectedPath)
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	defer file.Close()
+	manifest, err := readArchiveManifest(file, config.ArchiveCompressionZstd)
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	if manifest.Collection == nil || manifest.Collection.RunID != "abc123" ||
+		manifest.Collection.Errors["widgets.example.com"] != "forbidden" {
+		t.Errorf("Unexpected collection metadata: %+v", manifest.Collection)
+	}
+
+	// A new sink picks up the previous snapshot from the newest archive
+	a, err = NewArchive(config.ArchiveConfig{Dir: dir, Compression: config.ArchiveCompressionGzip}, "production", DefaultLayout)
+	if err != nil {
+		t.Fatalf("Failed to create archive sink: %v", err)
+	}
+	a.now = func() time.Time { return time.Date(2024, 6, 1, 3, 30, 0, 0, time.UTC) }
+
+	resources := testResources()[:1]
+	resources[0].YAML = append(resources[0].YAML, []byte("data:\n  key: value\n")...)
+	if err := a.WriteSnapshot(context.Background(), resources); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+	result, err = a.Finalize(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to finalize: %v", err)
+	}
+
+	var got []string
+	for _, change := range result.Changes {
+		got = append(got, change.Type+" "+change.String())
+	}
+	if strings.Join(got, ",") != "deleted StorageClass/slow,modified prod/ConfigMap/settings" {
+		t.Errorf("Unexpected changes: %v", got)
+	}
+
+	names := tarNames(t, filepath.Join(dir, "production-20240601T033000Z.tar.gz"))
+	if strings.Join(names, ",") != "manifest.json,namespaces/prod/configmap/settings.yaml" {
+		t.Errorf("Unexpected archive contents: %v", names)
+	}
+}
+
+func TestArchiveRetention(t *testing.T) {
+	tests := []struct {
+		name     string
+		count    int
+		age      time.Duration
+		expected []string
+	}{
+		{
+			name: "keep all",
+			expected: []string{
+				"production-20240601T000000Z.tar.gz",
+				"production-20240602T000000Z.tar.zst",
+				"production-20240603T000000Z.tar.gz",
+				"production-20240604T000000Z.tar.gz",
+			},
+		},
+		{
+			name:  "by count",
+			count: 2,
+			expected: []string{
+				"production-20240603T000000Z.tar.gz",
+				"production-20240604T000000Z.tar.gz",
+			},
+		},
+		{
+			name: "by age",
+			age:  36 * time.Hour,
+			expected: []string{
+				"production-20240603T000000Z.tar.gz",
+				"production-20240604T000000Z.tar.gz",
+			},
+		},
+		{
+			name:  "count and age",
+			count: 3,
+			age:   72 * time.Hour,
+			expected: []string{
+				"production-20240602T000000Z.tar.zst",
+				"production-20240603T000000Z.tar.gz",
+				"production-20240604T000000Z.tar.gz",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			unrelated := []string{"staging-20240101T000000Z.tar.gz", "production-notes.tar.gz", "README"}
+			for _, name := range append([]string{
+				"production-20240601T000000Z.tar.gz",
+				"production-20240602T000000Z.tar.zst",
+				"production-20240603T000000Z.tar.gz",
+			}, unrelated...) {
+				if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+					t.Fatalf("Failed to create %s: %v", name, err)
+				}
+			}
+
+			a, err := NewArchive(config.ArchiveConfig{
+				Dir:            dir,
+				Compression:    config.ArchiveCompressionGzip,
+				RetentionCount: tt.count,
+				RetentionAge:   tt.age,
+			}, "production", DefaultLayout)
+			if err != nil {
+				t.Fatalf("Failed to create archive sink: %v", err)
+			}
+			a.now = func() time.Time { return time.Date(2024, 6, 4, 0, 0, 0, 0, time.UTC) }
+			// The empty placeholder archives have no manifest to diff against
+			a.previous = &Manifest{}
+
+			ctx := context.Background()
+			if err := a.WriteSnapshot(ctx, testResources()); err != nil {
+				t.Fatalf("Failed to write snapshot: %v", err)
+			}
+			if _, err := a.Finalize(ctx); err != nil {
+				t.Fatalf("Failed to finalize: %v", err)
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatalf("Failed to list directory: %v", err)
+			}
+			var names []string
+			for _, entry := range entries {
+				names = append(names, entry.Name())
+			}
+			expected := append(append([]string(nil), tt.expected...), unrelated...)
+			sort.Strings(expected)
+			if strings.Join(names, "\n") != strings.Join(expected, "\n") {
+				t.Errorf("Unexpected archives:\n%s\nexpected:\n%s", strings.Join(names, "\n"), strings.Join(expected, "\n"))
+			}
+		})
+	}
+}
+
+// tarNames returns the names of the entries in a gzip-compressed tarball
+func tarNames(t *testing.T, path string) []string {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	defer file.Close()
+
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	tarReader := tar.NewReader(gzipReader)
+
+	var names []string
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read archive: %v", err)
+		}
+		names = append(names, header.Name)
+	}
+	return names
+}
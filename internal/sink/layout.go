@@ -0,0 +1,280 @@
+package sink
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"kube-git-backup/internal/config"
+	"kube-git-backup/internal/sanitizer"
+)
+
+// DefaultPathTemplate renders namespaces/<ns>/<kind>/<name>.yaml for
+// namespaced resources and cluster-scoped/<kind>/<name>.yaml for the others
+const DefaultPathTemplate = `{{if .Namespace}}namespaces/{{.Namespace}}{{else}}cluster-scoped{{end}}/{{lower .Kind}}/{{.Name}}.yaml`
+
+// GroupedPathTemplate is DefaultPathTemplate with kind directories qualified
+// by the API group, e.g. deployment.apps, leaving core kinds bare
+const GroupedPathTemplate = `{{if .Namespace}}namespaces/{{.Namespace}}{{else}}cluster-scoped{{end}}/{{lower (groupKind .Kind .Group)}}/{{.Name}}.yaml`
+
+// ErrPathCollision is wrapped by the errors of resources skipped because an
+// earlier resource renders to the same path
+var ErrPathCollision = errors.New("path collision")
+
+// DefaultLayout is the layout of DefaultPathTemplate
+var DefaultLayout = mustNewLayout(DefaultPathTemplate, "")
+
+// Layout maps resources to file paths relative to the root of a snapshot
+// using a text/template, see PathData for the fields available to it
+type Layout struct {
+	source   string // Text of the template
+	template *template.Template
+	cluster  string
+}
+
+// PathData is the data a path template is rendered with
+type PathData struct {
+	Cluster   string
+	Namespace string // Empty for cluster-scoped resources
+	Group     string // Empty for the core group
+	Version   string
+	Kind      string
+	Name      string
+}
+
+// layoutFuncs are the functions available to path templates
+var layoutFuncs = template.FuncMap{
+	"lower": strings.ToLower,
+	// groupKind qualifies a kind with its API group, e.g. Ingress.networking.k8s.io,
+	// and leaves kinds of the core group bare
+	"groupKind": func(kind, group string) string {
+		if group == "" {
+			return kind
+		}
+		return kind + "." + group
+	},
+}
+
+// NewLayout parses a path template, DefaultPathTemplate if empty, and checks
+// that it renders valid paths
+func NewLayout(pathTemplate, clusterName string) (*Layout, error) {
+	if pathTemplate == "" {
+		pathTemplate = DefaultPathTemplate
+	}
+
+	tmpl, err := template.New("path").Funcs(layoutFuncs).Option("missingkey=error").Parse(pathTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid path template: %w", err)
+	}
+	layout := &Layout{source: pathTemplate, template: tmpl, cluster: clusterName}
+
+	// Render a namespaced and a cluster-scoped resource to catch templates
+	// that fail at execution time or produce unusable paths
+	samples := []sanitizer.SanitizedResource{
+		{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "default", Name: "example"},
+		{APIVersion: "v1", Kind: "Namespace", Name: "example"},
+	}
+	if _, err := layout.Paths(samples); err != nil {
+		return nil, fmt.Errorf("invalid path template: %w", err)
+	}
+
+	return layout, nil
+}
+
+// LayoutFromConfig creates the layout selected by PATH_TEMPLATE or PATH_LAYOUT
+func LayoutFromConfig(cfg *config.Config) (*Layout, error) {
+	pathTemplate := cfg.PathTemplate
+	if cfg.PathLayout == config.PathLayoutGrouped {
+		pathTemplate = GroupedPathTemplate
+	}
+
+	layout, err := NewLayout(pathTemplate, cfg.ClusterName)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PATH_TEMPLATE: %w", err)
+	}
+	return layout, nil
+}
+
+// mustNewLayout is NewLayout for templates known to be valid
+func mustNewLayout(pathTemplate, clusterName string) *Layout {
+	layout, err := NewLayout(pathTemplate, clusterName)
+	if err != nil {
+		panic(err)
+	}
+	return layout
+}
+
+// Path returns the slash-separated path of a resource
+func (l *Layout) Path(resource sanitizer.SanitizedResource) (string, error) {
+	group, version := "", resource.APIVersion
+	if i := strings.LastIndex(resource.APIVersion, "/"); i >= 0 {
+		group, version = resource.APIVersion[:i], resource.APIVersion[i+1:]
+	}
+
+	var buf bytes.Buffer
+	err := l.template.Execute(&buf, PathData{
+		Cluster:   l.cluster,
+		Namespace: resource.Namespace,
+		Group:     group,
+		Version:   version,
+		Kind:      resource.Kind,
+		Name:      resource.Name,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to render path of %s: %w", describeResource(resource), err)
+	}
+
+	// Clean up the separators left by empty fields, e.g. the namespace of
+	// cluster-scoped resources
+	relPath := strings.TrimLeft(path.Clean(strings.TrimSpace(buf.String())), "/")
+	if err := checkPath(relPath); err != nil {
+		return "", fmt.Errorf("invalid path %q for %s: %w", relPath, describeResource(resource), err)
+	}
+
+	return relPath, nil
+}
+
+// Template returns the text of the path template
+func (l *Layout) Template() string {
+	return l.source
+}
+
+// Paths returns the paths of the resources, in order, and fails if two of
+// them render to the same path
+func (l *Layout) Paths(resources []sanitizer.SanitizedResource) ([]string, error) {
+	paths := make([]string, len(resources))
+	owners := make(map[string]sanitizer.SanitizedResource, len(resources))
+
+	for i, resource := range resources {
+		relPath, err := l.Path(resource)
+		if err != nil {
+			return nil, err
+		}
+		if owner, ok := owners[relPath]; ok {
+			return nil, fmt.Errorf("path collision: %s and %s both map to %s",
+				describeResource(owner), describeResource(resource), relPath)
+		}
+		owners[relPath] = resource
+		paths[i] = relPath
+	}
+
+	return paths, nil
+}
+
+// SkipCollisions returns the resources whose path is not taken by an earlier
+// resource, and the others as invalid resources, so that one collision, e.g.
+// of same-named kinds in different API groups, doesn't fail the whole snapshot
+func (l *Layout) SkipCollisions(resources []sanitizer.SanitizedResource) ([]sanitizer.SanitizedResource, []sanitizer.InvalidResource, error) {
+	kept := make([]sanitizer.SanitizedResource, 0, len(resources))
+	var skipped []sanitizer.InvalidResource
+	owners := make(map[string]sanitizer.SanitizedResource, len(resources))
+
+	for _, resource := range resources {
+		relPath, err := l.Path(resource)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		owner, ok := owners[relPath]
+		if !ok {
+			owners[relPath] = resource
+			kept = append(kept, resource)
+			continue
+		}
+
+		err = fmt.Errorf("%w: %s is the path of %s", ErrPathCollision, relPath, describeResource(owner))
+		if group, ownerGroup := apiGroup(resource.APIVersion), apiGroup(owner.APIVersion); group != ownerGroup {
+			err = fmt.Errorf("%w, kind %s is in groups %q and %q, set PATH_LAYOUT=grouped to qualify kinds by group",
+				err, resource.Kind, ownerGroup, group)
+		}
+		skipped = append(skipped, sanitizer.InvalidResource{
+			APIVersion: resource.APIVersion,
+			Kind:       resource.Kind,
+			Namespace:  resource.Namespace,
+			Name:       resource.Name,
+			Err:        err,
+		})
+	}
+
+	return kept, skipped, nil
+}
+
+// apiGroup returns the group of an apiVersion, empty for the core group
+func apiGroup(apiVersion string) string {
+	if i := strings.LastIndex(apiVersion, "/"); i >= 0 {
+		return apiVersion[:i]
+	}
+	return ""
+}
+
+// checkPath checks that a rendered path stays within the snapshot root and
+// is a YAML file outside hidden directories, which the sinks leave alone
+func checkPath(relPath string) error {
+	if relPath == "" || relPath == "." {
+		return fmt.Errorf("must not be empty")
+	}
+	if !strings.HasSuffix(relPath, ".yaml") {
+		return fmt.Errorf("must end with .yaml")
+	}
+	for _, part := range strings.Split(relPath, "/") {
+		if strings.HasPrefix(part, ".") {
+			return fmt.Errorf("must not contain hidden or parent directories")
+		}
+	}
+	return nil
+}
+
+// IsResourcePath reports whether a slash-separated path relative to a
+// snapshot root may be a resource file, i.e. a YAML file outside hidden
+// directories such as .git
+func IsResourcePath(relPath string) bool {
+	return strings.HasSuffix(relPath, ".yaml") && checkPath(relPath) == nil
+}
+
+// ListResourceFiles returns the slash-separated paths of the resource files
+// below dir, see IsResourcePath. A missing directory has no files.
+func ListResourceFiles(dir string) ([]string, error) {
+	var files []string
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+
+		if path != dir && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.IsDir() || !strings.HasSuffix(info.Name(), ".yaml") {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(relPath))
+		return nil
+	})
+
+	return files, err
+}
+
+// describeResource identifies a resource in error messages
+func describeResource(resource sanitizer.SanitizedResource) string {
+	if resource.Namespace == "" {
+		return fmt.Sprintf("%s %s/%s", resource.APIVersion, resource.Kind, resource.Name)
+	}
+	return fmt.Sprintf("%s %s/%s/%s", resource.APIVersion, resource.Namespace, resource.Kind, resource.Name)
+}
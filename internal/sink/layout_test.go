@@ -0,0 +1,172 @@
+package sink
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"kube-git-backup/internal/sanitizer"
+)
+
+func TestLayoutPath(t *testing.T) {
+	deployment := sanitizer.SanitizedResource{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "prod", Name: "web"}
+	storageClass := sanitizer.SanitizedResource{APIVersion: "storage.k8s.io/v1", Kind: "StorageClass", Name: "slow"}
+	configMap := sanitizer.SanitizedResource{APIVersion: "v1", Kind: "ConfigMap", Namespace: "prod", Name: "settings"}
+
+	tests := []struct {
+		name     string
+		template string
+		resource sanitizer.SanitizedResource
+		expected string
+	}{
+		{
+			name:     "default namespaced",
+			resource: deployment,
+			expected: "namespaces/prod/deployment/web.yaml",
+		},
+		{
+			name:     "default cluster-scoped",
+			resource: storageClass,
+			expected: "cluster-scoped/storageclass/slow.yaml",
+		},
+		{
+			name:     "cluster and group",
+			template: "{{.Cluster}}/{{.Namespace}}/{{.Group}}/{{.Kind}}/{{.Name}}.yaml",
+			resource: deployment,
+			expected: "production/prod/apps/Deployment/web.yaml",
+		},
+		{
+			name:     "empty fields are collapsed",
+			template: "{{.Cluster}}/{{.Namespace}}/{{.Group}}/{{.Kind}}/{{.Name}}.yaml",
+			resource: storageClass,
+			expected: "production/storage.k8s.io/StorageClass/slow.yaml",
+		},
+		{
+			name:     "group-qualified kind",
+			template: "{{.Namespace}}/{{lower (groupKind .Kind .Group)}}/{{.Name}}.yaml",
+			resource: deployment,
+			expected: "prod/deployment.apps/web.yaml",
+		},
+		{
+			name:     "core group stays bare",
+			template: "{{.Namespace}}/{{lower (groupKind .Kind .Group)}}/{{.Name}}.yaml",
+			resource: configMap,
+			expected: "prod/configmap/settings.yaml",
+		},
+		{
+			name:     "leading empty field",
+			template: "{{.Namespace}}/{{lower (groupKind .Kind .Group)}}/{{.Name}}.yaml",
+			resource: storageClass,
+			expected: "storageclass.storage.k8s.io/slow.yaml",
+		},
+		{
+			name:     "version",
+			template: "{{.Version}}/{{.Kind}}/{{.Name}}.yaml",
+			resource: configMap,
+			expected: "v1/ConfigMap/settings.yaml",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			layout, err := NewLayout(tt.template, "production")
+			if err != nil {
+				t.Fatalf("Failed to create layout: %v", err)
+			}
+			got, err := layout.Path(tt.resource)
+			if err != nil {
+				t.Fatalf("Failed to render path: %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestNewLayoutInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		errorMsg string
+	}{
+		{name: "parse error", template: "{{.Name", errorMsg: "invalid path template"},
+		{name: "unknown field", template: "{{.Owner}}/{{.Name}}.yaml", errorMsg: "can't evaluate field Owner"},
+		{name: "unknown function", template: "{{upper .Name}}.yaml", errorMsg: "function \"upper\" not defined"},
+		{name: "not yaml", template: "{{.Kind}}/{{.Name}}.json", errorMsg: "must end with .yaml"},
+		{name: "escapes root", template: "../{{.Kind}}/{{.Name}}.yaml", errorMsg: "must not contain hidden or parent directories"},
+		{name: "empty", template: "{{if false}}{{.Name}}.yaml{{end}}", errorMsg: "must not be empty"},
+		{name: "hidden directory", template: ".git/{{.Name}}.yaml", errorMsg: "must not contain hidden or parent directories"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewLayout(tt.template, "production")
+			if err == nil {
+				t.Fatalf("Expected error but got none")
+			}
+			if !strings.Contains(err.Error(), tt.errorMsg) {
+				t.Errorf("Expected error containing %q, got %q", tt.errorMsg, err.Error())
+			}
+		})
+	}
+}
+
+func TestLayoutCollision(t *testing.T) {
+	layout, err := NewLayout("{{lower .Kind}}/{{.Name}}.yaml", "production")
+	if err != nil {
+		t.Fatalf("Failed to create layout: %v", err)
+	}
+
+	resources := []sanitizer.SanitizedResource{
+		{APIVersion: "v1", Kind: "ConfigMap", Namespace: "prod", Name: "settings"},
+		{APIVersion: "v1", Kind: "ConfigMap", Namespace: "staging", Name: "settings"},
+	}
+	_, err = layout.Paths(resources)
+	if err == nil {
+		t.Fatal("Expected a collision error")
+	}
+	expected := "path collision: v1 prod/ConfigMap/settings and v1 staging/ConfigMap/settings both map to configmap/settings.yaml"
+	if err.Error() != expected {
+		t.Errorf("Expected %q, got %q", expected, err.Error())
+	}
+
+	// Distinct paths render in order
+	paths, err := DefaultLayout.Paths(resources)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if strings.Join(paths, ",") != "namespaces/prod/configmap/settings.yaml,namespaces/staging/configmap/settings.yaml" {
+		t.Errorf("Unexpected paths: %v", paths)
+	}
+}
+
+func TestLayoutSkipCollisions(t *testing.T) {
+	resources := []sanitizer.SanitizedResource{
+		{APIVersion: "monitoring.example.com/v1", Kind: "Alert", Namespace: "prod", Name: "disk"},
+		{APIVersion: "v1", Kind: "ConfigMap", Namespace: "prod", Name: "disk"},
+		{APIVersion: "alerts.example.org/v1beta1", Kind: "Alert", Namespace: "prod", Name: "disk"},
+	}
+
+	kept, skipped, err := DefaultLayout.SkipCollisions(resources)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(kept) != 2 || kept[0].APIVersion != resources[0].APIVersion || kept[1].Kind != "ConfigMap" {
+		t.Errorf("Expected the first Alert and the ConfigMap to be kept, got %+v", kept)
+	}
+	if len(skipped) != 1 || skipped[0].APIVersion != "alerts.example.org/v1beta1" || !errors.Is(skipped[0].Err, ErrPathCollision) {
+		t.Fatalf("Expected the second Alert to be skipped, got %+v", skipped)
+	}
+	for _, expected := range []string{"namespaces/prod/alert/disk.yaml", "monitoring.example.com", "alerts.example.org", "PATH_LAYOUT=grouped"} {
+		if !strings.Contains(skipped[0].Err.Error(), expected) {
+			t.Errorf("Expected %q in %q", expected, skipped[0].Err.Error())
+		}
+	}
+
+	// The grouped layout tells the kinds apart
+	grouped := mustNewLayout(GroupedPathTemplate, "")
+	if kept, skipped, err := grouped.SkipCollisions(resources); err != nil || len(kept) != 3 || len(skipped) != 0 {
+		t.Errorf("Expected no collisions with the grouped layout, got %+v, %v", skipped, err)
+	}
+}
@@ -0,0 +1,324 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"kube-git-backup/internal/logging"
+	"kube-git-backup/internal/metrics"
+	"kube-git-backup/internal/sanitizer"
+)
+
+// FileListName lists the resource files the local sink wrote to its
+// directory, one path per line. Only these files are ever removed, so that
+// a directory shared with other files, such as WORK_DIR, is safe to use.
+const FileListName = ".kube-git-backup-files"
+
+// Directory writes snapshots as loose YAML files to a local directory
+type Directory struct {
+	dir     string
+	layout  *Layout
+	changes map[string]ResourceChange // Changes staged since the last Finalize, by path
+}
+
+// NewDirectory creates a sink writing to dir
+func NewDirectory(dir string, layout *Layout) *Directory {
+	return &Directory{
+		dir:     dir,
+		layout:  layout,
+		changes: make(map[string]ResourceChange),
+	}
+}
+
+// Name returns the name of the sink
+func (d *Directory) Name() string {
+	return "local"
+}
+
+// WriteSnapshot writes the resources and removes the files that an earlier
+// snapshot wrote for resources that are no longer present. Other files are
+// left alone.
+func (d *Directory) WriteSnapshot(ctx context.Context, resources []sanitizer.SanitizedResource) error {
+	writeStart := time.Now()
+
+	paths, err := d.layout.Paths(resources)
+	if err != nil {
+		return err
+	}
+
+	moves, err := MigrateLayout(d.dir, d.layout)
+	if err != nil {
+		return fmt.Errorf("failed to migrate layout of %s: %w", d.dir, err)
+	}
+	if len(moves) > 0 {
+		logging.FromContext(ctx).Info("Migrated directory layout", "dir", d.dir, "moved", len(moves))
+	}
+
+	recorded, err := d.readFileList()
+	if err != nil {
+		return err
+	}
+	for _, move := range moves {
+		if recorded[move.From] {
+			delete(recorded, move.From)
+			recorded[move.To] = true
+		}
+	}
+
+	// Record the new files before writing them, so that they are pruned
+	// later even if this run is interrupted
+	current := make(map[string]bool, len(paths))
+	files := make(map[string]bool, len(paths))
+	for _, relPath := range paths {
+		current[relPath] = true
+		files[relPath] = true
+	}
+	for relPath := range recorded {
+		files[relPath] = true
+	}
+	if err := d.writeFileList(files); err != nil {
+		return err
+	}
+
+	for _, relPath := range sortedPaths(recorded) {
+		if current[relPath] {
+			continue
+		}
+		keep, err := KeepsFile(ctx, filepath.Join(d.dir, relPath))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to read %s: %w", relPath, err)
+		}
+		if keep {
+			logging.FromContext(ctx).Debug("Keeping file of a kind that failed to be collected", "path", relPath)
+			continue
+		}
+		logging.FromContext(ctx).Info("Removing old backup file", "path", relPath)
+		if err := d.removeFile(relPath); err != nil {
+			return err
+		}
+		delete(files, relPath)
+	}
+
+	if err := d.writeResources(resources, paths); err != nil {
+		return err
+	}
+	if err := d.writeFileList(files); err != nil {
+		return err
+	}
+	metrics.ObservePhase(d.layout.cluster, metrics.PhaseWrite, writeStart)
+
+	return nil
+}
+
+// WriteChanges writes the updated resources and removes the files of the
+// deleted ones that an earlier write recorded
+func (d *Directory) WriteChanges(ctx context.Context, updated, deleted []sanitizer.SanitizedResource) error {
+	writeStart := time.Now()
+
+	files, err := d.readFileList()
+	if err != nil {
+		return err
+	}
+
+	paths, err := d.layout.Paths(updated)
+	if err != nil {
+		return err
+	}
+	for _, relPath := range paths {
+		files[relPath] = true
+	}
+	if err := d.writeFileList(files); err != nil {
+		return err
+	}
+
+	for _, resource := range deleted {
+		relPath, err := d.layout.Path(resource)
+		if err != nil {
+			return err
+		}
+		if !files[relPath] {
+			continue
+		}
+		if err := d.removeFile(relPath); err != nil {
+			return err
+		}
+		delete(files, relPath)
+	}
+
+	if err := d.writeResources(updated, paths); err != nil {
+		return err
+	}
+	if err := d.writeFileList(files); err != nil {
+		return err
+	}
+	metrics.ObservePhase(d.layout.cluster, metrics.PhaseWrite, writeStart)
+
+	return nil
+}
+
+// Finalize reports the files changed since the last Finalize. The files are
+// already in place, so there is nothing left to persist.
+func (d *Directory) Finalize(ctx context.Context) (Result, error) {
+	result := Result{Reference: d.dir}
+	for _, change := range d.changes {
+		result.Changes = append(result.Changes, change)
+	}
+	sort.Slice(result.Changes, func(i, j int) bool {
+		return result.Changes[i].Path < result.Changes[j].Path
+	})
+	d.changes = make(map[string]ResourceChange)
+
+	if len(result.Changes) > 0 {
+		logging.FromContext(ctx).Info("Resources dumped to local directory", "dir", d.dir,
+			"changes", len(result.Changes))
+	}
+
+	return result, nil
+}
+
+// Close does nothing, the directory holds no resources
+func (d *Directory) Close() error {
+	return nil
+}
+
+// writeResources writes the resources whose content changed to their paths
+func (d *Directory) writeResources(resources []sanitizer.SanitizedResource, paths []string) error {
+	for i, resource := range resources {
+		relPath := paths[i]
+		resourcePath := filepath.Join(d.dir, relPath)
+
+		existing, err := os.ReadFile(resourcePath)
+		switch {
+		case err == nil && bytes.Equal(existing, resource.YAML):
+			continue
+		case err == nil && sanitizer.SameSecret(existing, resource.YAML):
+			// Keep Secrets encrypted by an earlier process while they are unchanged
+			continue
+		case err == nil:
+			d.recordChange(ChangeModified, relPath, resource)
+		case os.IsNotExist(err):
+			d.recordChange(ChangeAdded, relPath, resource)
+		default:
+			return fmt.Errorf("failed to read file %s: %w", resourcePath, err)
+		}
+
+		// Create directory if it doesn't exist
+		dir := filepath.Dir(resourcePath)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", dir, err)
+		}
+
+		// Write YAML content
+		if err := os.WriteFile(resourcePath, resource.YAML, 0644); err != nil {
+			return fmt.Errorf("failed to write file %s: %w", resourcePath, err)
+		}
+	}
+
+	return nil
+}
+
+// readFileList returns the paths recorded in FileListName, none if the
+// directory has no list yet
+func (d *Directory) readFileList() (map[string]bool, error) {
+	listPath := filepath.Join(d.dir, FileListName)
+	content, err := os.ReadFile(listPath)
+	if os.IsNotExist(err) {
+		return make(map[string]bool), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", listPath, err)
+	}
+
+	files := make(map[string]bool)
+	for _, line := range strings.Split(string(content), "\n") {
+		// Ignore entries that could point outside the directory
+		if relPath := strings.TrimSpace(line); IsResourcePath(relPath) {
+			files[relPath] = true
+		}
+	}
+	return files, nil
+}
+
+// writeFileList records the paths in FileListName
+func (d *Directory) writeFileList(files map[string]bool) error {
+	var content strings.Builder
+	for _, relPath := range sortedPaths(files) {
+		content.WriteString(relPath + "\n")
+	}
+
+	if err := os.MkdirAll(d.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", d.dir, err)
+	}
+	listPath := filepath.Join(d.dir, FileListName)
+	if err := os.WriteFile(listPath, []byte(content.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", listPath, err)
+	}
+	return nil
+}
+
+// sortedPaths returns the paths of a set in order
+func sortedPaths(paths map[string]bool) []string {
+	sorted := make([]string, 0, len(paths))
+	for relPath := range paths {
+		sorted = append(sorted, relPath)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// removeFile removes a resource file, recording the deletion if it existed
+func (d *Directory) removeFile(relPath string) error {
+	resourcePath := filepath.Join(d.dir, relPath)
+
+	content, err := os.ReadFile(resourcePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read file %s: %w", resourcePath, err)
+	}
+
+	if err := os.Remove(resourcePath); err != nil {
+		return fmt.Errorf("failed to remove file %s: %w", resourcePath, err)
+	}
+
+	change := ResourceChange{Type: ChangeDeleted, Path: filepath.ToSlash(relPath)}
+	Describe(&change, content)
+	d.addChange(change)
+
+	return nil
+}
+
+// recordChange records an added or modified resource
+func (d *Directory) recordChange(changeType, relPath string, resource sanitizer.SanitizedResource) {
+	d.addChange(ResourceChange{
+		Type:      changeType,
+		Path:      filepath.ToSlash(relPath),
+		Namespace: resource.Namespace,
+		Kind:      resource.Kind,
+		Name:      resource.Name,
+	})
+}
+
+// addChange merges a change with an earlier change of the same file since
+// the last Finalize
+func (d *Directory) addChange(change ResourceChange) {
+	if previous, ok := d.changes[change.Path]; ok {
+		switch {
+		case previous.Type == ChangeAdded && change.Type == ChangeDeleted:
+			delete(d.changes, change.Path)
+			return
+		case previous.Type == ChangeAdded:
+			change.Type = ChangeAdded
+		case previous.Type == ChangeDeleted && change.Type == ChangeAdded:
+			change.Type = ChangeModified
+		}
+	}
+	d.changes[change.Path] = change
+}
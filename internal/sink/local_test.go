@@ -0,0 +1,282 @@
+package sink
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"kube-git-backup/internal/sanitizer"
+)
+
+func TestDirectorySnapshot(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	d := NewDirectory(dir, DefaultLayout)
+
+	settings := sanitizer.SanitizedResource{APIVersion: "v1", Kind: "ConfigMap", Namespace: "prod", Name: "settings",
+		YAML: []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\n  namespace: prod\n")}
+	web := sanitizer.SanitizedResource{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "prod", Name: "web",
+		YAML: []byte("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: web\n  namespace: prod\n")}
+	slow := sanitizer.SanitizedResource{APIVersion: "storage.k8s.io/v1", Kind: "StorageClass", Name: "slow",
+		YAML: []byte("apiVersion: storage.k8s.io/v1\nkind: StorageClass\nmetadata:\n  name: slow\n")}
+
+	if err := d.WriteSnapshot(ctx, []sanitizer.SanitizedResource{settings, slow}); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+	result, err := d.Finalize(ctx)
+	if err != nil {
+		t.Fatalf("Failed to finalize: %v", err)
+	}
+	if added, modified, deleted := result.Counts(); added != 2 || modified != 0 || deleted != 0 {
+		t.Errorf("Expected 2 added, got %d added, %d modified, %d deleted", added, modified, deleted)
+	}
+
+	// Files of other tools are left alone
+	hidden := filepath.Join(dir, ".git", "config.yaml")
+	if err := os.MkdirAll(filepath.Dir(hidden), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(hidden, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	settings.YAML = append(settings.YAML, []byte("data:\n  key: value\n")...)
+	if err := d.WriteSnapshot(ctx, []sanitizer.SanitizedResource{settings, web}); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+	result, err = d.Finalize(ctx)
+	if err != nil {
+		t.Fatalf("Failed to finalize: %v", err)
+	}
+
+	var got []string
+	for _, change := range result.Changes {
+		got = append(got, change.Type+" "+change.String())
+	}
+	expected := []string{
+		"deleted StorageClass/slow",
+		"modified prod/ConfigMap/settings",
+		"added prod/Deployment/web",
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected changes:\n%s\nexpected:\n%s", strings.Join(got, "\n"), strings.Join(expected, "\n"))
+	}
+
+	for path, exists := range map[string]bool{
+		"namespaces/prod/configmap/settings.yaml": true,
+		"namespaces/prod/deployment/web.yaml":     true,
+		"cluster-scoped/storageclass/slow.yaml":   false,
+		".git/config.yaml":                        true,
+	} {
+		if _, err := os.Stat(filepath.Join(dir, path)); (err == nil) != exists {
+			t.Errorf("Expected %s to exist: %v, got error %v", path, exists, err)
+		}
+	}
+
+	// An unchanged snapshot reports no changes
+	if err := d.WriteSnapshot(ctx, []sanitizer.SanitizedResource{settings, web}); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+	result, err = d.Finalize(ctx)
+	if err != nil {
+		t.Fatalf("Failed to finalize: %v", err)
+	}
+	if len(result.Changes) != 0 {
+		t.Errorf("Expected no changes, got %v", result.Changes)
+	}
+}
+
+func TestDirectoryChanges(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	d := NewDirectory(dir, DefaultLayout)
+
+	web := sanitizer.SanitizedResource{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "prod", Name: "web",
+		YAML: []byte("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: web\n  namespace: prod\n")}
+	api := sanitizer.SanitizedResource{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "prod", Name: "api",
+		YAML: []byte("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: api\n  namespace: prod\n")}
+
+	if err := d.WriteChanges(ctx, []sanitizer.SanitizedResource{web, api}, nil); err != nil {
+		t.Fatalf("Failed to write changes: %v", err)
+	}
+	if _, err := d.Finalize(ctx); err != nil {
+		t.Fatalf("Failed to finalize: %v", err)
+	}
+
+	// A resource created and deleted before Finalize cancels out
+	db := sanitizer.SanitizedResource{APIVersion: "apps/v1", Kind: "StatefulSet", Namespace: "prod", Name: "db",
+		YAML: []byte("apiVersion: apps/v1\nkind: StatefulSet\nmetadata:\n  name: db\n  namespace: prod\n")}
+	if err := d.WriteChanges(ctx, []sanitizer.SanitizedResource{db}, []sanitizer.SanitizedResource{web}); err != nil {
+		t.Fatalf("Failed to write changes: %v", err)
+	}
+	if err := d.WriteChanges(ctx, nil, []sanitizer.SanitizedResource{db}); err != nil {
+		t.Fatalf("Failed to write changes: %v", err)
+	}
+	result, err := d.Finalize(ctx)
+	if err != nil {
+		t.Fatalf("Failed to finalize: %v", err)
+	}
+
+	if len(result.Changes) != 1 || result.Changes[0].Type != ChangeDeleted || result.Changes[0].String() != "prod/Deployment/web" {
+		t.Errorf("Expected only prod/Deployment/web to be deleted, got %v", result.Changes)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "namespaces/prod/deployment/api.yaml")); err != nil {
+		t.Errorf("Expected the untouched resource to be kept: %v", err)
+	}
+}
+
+func TestDirectoryKeepsFailedKinds(t *testing.T) {
+	dir := t.TempDir()
+	d := NewDirectory(dir, DefaultLayout)
+
+	settings := sanitizer.SanitizedResource{APIVersion: "v1", Kind: "ConfigMap", Namespace: "prod", Name: "settings",
+		YAML: []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\n  namespace: prod\n")}
+	credentials := sanitizer.SanitizedResource{APIVersion: "v1", Kind: "Secret", Namespace: "prod", Name: "credentials",
+		YAML: []byte("apiVersion: v1\nkind: Secret\nmetadata:\n  name: credentials\n  namespace: prod\n")}
+	web := sanitizer.SanitizedResource{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "prod", Name: "web",
+		YAML: []byte("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: web\n  namespace: prod\n")}
+
+	if err := d.WriteSnapshot(context.Background(), []sanitizer.SanitizedResource{settings, credentials, web}); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+	if _, err := d.Finalize(context.Background()); err != nil {
+		t.Fatalf("Failed to finalize: %v", err)
+	}
+
+	// Secrets failed to be collected, so their file is kept while the
+	// deleted Deployment is removed
+	ctx := WithCollection(context.Background(), Collection{
+		Errors: map[string]string{"secrets": "forbidden"},
+		Kept:   []KeptKind{{Kind: "Secret"}},
+	})
+	if err := d.WriteSnapshot(ctx, []sanitizer.SanitizedResource{settings}); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+	result, err := d.Finalize(ctx)
+	if err != nil {
+		t.Fatalf("Failed to finalize: %v", err)
+	}
+
+	if len(result.Changes) != 1 || result.Changes[0].String() != "prod/Deployment/web" {
+		t.Errorf("Expected only prod/Deployment/web to be deleted, got %v", result.Changes)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "namespaces/prod/secret/credentials.yaml")); err != nil {
+		t.Errorf("Expected the Secret to be kept: %v", err)
+	}
+}
+
+func TestDirectoryKeepsUnchangedSecrets(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	d := NewDirectory(dir, DefaultLayout)
+
+	secret := func(mac, fingerprint string) sanitizer.SanitizedResource {
+		return sanitizer.SanitizedResource{APIVersion: "v1", Kind: "Secret", Namespace: "prod", Name: "credentials",
+			YAML: []byte("apiVersion: v1\nkind: Secret\nmetadata:\n  name: credentials\n  namespace: prod\n" +
+				"sops:\n  mac: " + mac + "\n  kube_git_backup_fingerprint: " + fingerprint + "\n")}
+	}
+
+	if err := d.WriteSnapshot(ctx, []sanitizer.SanitizedResource{secret("first", "hmac-sha256:aa")}); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+	if _, err := d.Finalize(ctx); err != nil {
+		t.Fatalf("Failed to finalize: %v", err)
+	}
+
+	// New ciphertext of the same plaintext leaves the file alone
+	if err := d.WriteSnapshot(ctx, []sanitizer.SanitizedResource{secret("second", "hmac-sha256:aa")}); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+	result, err := d.Finalize(ctx)
+	if err != nil {
+		t.Fatalf("Failed to finalize: %v", err)
+	}
+	if len(result.Changes) != 0 {
+		t.Errorf("Expected no changes, got %v", result.Changes)
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "namespaces", "prod", "secret", "credentials.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "mac: first") {
+		t.Errorf("Expected the existing file to be kept, got\n%s", content)
+	}
+
+	if err := d.WriteSnapshot(ctx, []sanitizer.SanitizedResource{secret("third", "hmac-sha256:bb")}); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+	result, err = d.Finalize(ctx)
+	if err != nil {
+		t.Fatalf("Failed to finalize: %v", err)
+	}
+	if _, modified, _ := result.Counts(); modified != 1 {
+		t.Errorf("Expected the changed Secret to be rewritten, got %v", result.Changes)
+	}
+}
+
+func TestDirectoryOnlyPrunesRecordedFiles(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	d := NewDirectory(dir, DefaultLayout)
+
+	// Files that were there before, e.g. of other tools sharing WORK_DIR
+	foreign := map[string]string{
+		"values.yaml":                           "replicas: 3\n",
+		"namespaces/prod/configmap/legacy.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: legacy\n  namespace: prod\n",
+	}
+	for relPath, content := range foreign {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, relPath)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, relPath), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	web := sanitizer.SanitizedResource{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "prod", Name: "web",
+		YAML: []byte("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: web\n  namespace: prod\n")}
+	api := sanitizer.SanitizedResource{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "prod", Name: "api",
+		YAML: []byte("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: api\n  namespace: prod\n")}
+	db := sanitizer.SanitizedResource{APIVersion: "apps/v1", Kind: "StatefulSet", Namespace: "prod", Name: "db",
+		YAML: []byte("apiVersion: apps/v1\nkind: StatefulSet\nmetadata:\n  name: db\n  namespace: prod\n")}
+
+	if err := d.WriteSnapshot(ctx, []sanitizer.SanitizedResource{web, api}); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+	if err := d.WriteChanges(ctx, []sanitizer.SanitizedResource{db}, nil); err != nil {
+		t.Fatalf("Failed to write changes: %v", err)
+	}
+	// A deletion of a resource whose file the sink didn't write
+	legacy := sanitizer.SanitizedResource{APIVersion: "v1", Kind: "ConfigMap", Namespace: "prod", Name: "legacy"}
+	if err := d.WriteChanges(ctx, nil, []sanitizer.SanitizedResource{legacy}); err != nil {
+		t.Fatalf("Failed to write changes: %v", err)
+	}
+
+	// A new instance reads the list of the earlier ones
+	d = NewDirectory(dir, DefaultLayout)
+	if err := d.WriteSnapshot(ctx, []sanitizer.SanitizedResource{web}); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+
+	for relPath, exists := range map[string]bool{
+		"values.yaml":                           true,
+		"namespaces/prod/configmap/legacy.yaml": true,
+		"namespaces/prod/deployment/web.yaml":   true,
+		"namespaces/prod/deployment/api.yaml":   false,
+		"namespaces/prod/statefulset/db.yaml":   false,
+	} {
+		if _, err := os.Stat(filepath.Join(dir, relPath)); (err == nil) != exists {
+			t.Errorf("Expected %s to exist: %v, got error %v", relPath, exists, err)
+		}
+	}
+
+	list, err := os.ReadFile(filepath.Join(dir, FileListName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(list) != "namespaces/prod/deployment/web.yaml\n" {
+		t.Errorf("Expected only the current file to be recorded, got %q", list)
+	}
+}
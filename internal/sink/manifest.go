@@ -0,0 +1,237 @@
+package sink
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"kube-git-backup/internal/sanitizer"
+
+	"sigs.k8s.io/yaml"
+)
+
+// ManifestFileName is the name of the manifest in a snapshot
+const ManifestFileName = "manifest.json"
+
+// Manifest describes a snapshot and lists every file in it with its checksum
+type Manifest struct {
+	Cluster         string          `json:"cluster"`
+	CreatedAt       time.Time       `json:"createdAt"`
+	Location        string          `json:"location,omitempty"` // Where the snapshot was written, e.g. an object key prefix
+	Resources       int             `json:"resources"`
+	ResourcesByKind map[string]int  `json:"resourcesByKind"`
+	Collection      *Collection     `json:"collection,omitempty"`
+	Files           []ManifestEntry `json:"files"`
+}
+
+// Collection describes how the resources of a snapshot were collected
+type Collection struct {
+	RunID           string            `json:"runId,omitempty"`
+	StartedAt       time.Time         `json:"startedAt"`
+	DurationSeconds float64           `json:"durationSeconds"`
+	Errors          map[string]string `json:"errors,omitempty"` // Resource types that failed to be collected
+
+	// Kept lists the kinds of the failed types whose last-known files the git
+	// and local sinks keep. Snapshot sinks require the abort policy, so their
+	// manifests never describe a partial snapshot, neither for failed types
+	// nor for resources with an invalid manifest.
+	Kept []KeptKind `json:"-"`
+
+	// KeptResources lists the resources left out because of an invalid
+	// manifest, whose last-known files are kept like those of Kept
+	KeptResources []KeptResource `json:"-"`
+}
+
+// KeptKind is a kind that failed to be collected, whose files of the
+// previous run are kept instead of being deleted
+type KeptKind struct {
+	Group string
+	Kind  string // Empty for every kind of a group that failed to be discovered
+}
+
+// KeptResource is a single resource whose file of the previous run is kept
+type KeptResource struct {
+	APIVersion string
+	Kind       string
+	Namespace  string
+	Name       string
+}
+
+type collectionKey struct{}
+
+// WithCollection returns a context carrying the collection metadata that
+// sinks record in their manifests
+func WithCollection(ctx context.Context, collection Collection) context.Context {
+	return context.WithValue(ctx, collectionKey{}, &collection)
+}
+
+// collectionFromContext returns the collection metadata carried by ctx, or nil
+func collectionFromContext(ctx context.Context) *Collection {
+	collection, _ := ctx.Value(collectionKey{}).(*Collection)
+	return collection
+}
+
+// keeps reports whether the resource of apiVersion, kind, namespace and name is kept
+func (c *Collection) keeps(apiVersion, kind, namespace, name string) bool {
+	if c == nil {
+		return false
+	}
+
+	group, _, found := strings.Cut(apiVersion, "/")
+	if !found {
+		group = ""
+	}
+	for _, kept := range c.Kept {
+		if kept.Group == group && (kept.Kind == "" || kept.Kind == kind) {
+			return true
+		}
+	}
+	for _, kept := range c.KeptResources {
+		if kept == (KeptResource{APIVersion: apiVersion, Kind: kind, Namespace: namespace, Name: name}) {
+			return true
+		}
+	}
+	return false
+}
+
+// KeepsFile reports whether a file left over from the previous snapshot must
+// be kept, because its resource is of a kind that failed to be collected or
+// had an invalid manifest in the run of ctx. Files that can't be parsed are
+// not kept.
+func KeepsFile(ctx context.Context, path string) (bool, error) {
+	collection := collectionFromContext(ctx)
+	if collection == nil || len(collection.Kept)+len(collection.KeptResources) == 0 {
+		return false, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	var obj struct {
+		APIVersion string `json:"apiVersion"`
+		Kind       string `json:"kind"`
+		Metadata   struct {
+			Namespace string `json:"namespace"`
+			Name      string `json:"name"`
+		} `json:"metadata"`
+	}
+	if err := yaml.Unmarshal(content, &obj); err != nil {
+		return false, nil
+	}
+	return collection.keeps(obj.APIVersion, obj.Kind, obj.Metadata.Namespace, obj.Metadata.Name), nil
+}
+
+// ManifestEntry describes a single resource file in a snapshot
+type ManifestEntry struct {
+	Path       string `json:"path"` // Relative to the snapshot root
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+	Size       int    `json:"size"`
+	SHA256     string `json:"sha256"`
+}
+
+// snapshotFile is a resource file of a snapshot
+type snapshotFile struct {
+	path     string
+	resource sanitizer.SanitizedResource
+}
+
+// snapshotFiles returns the files of a snapshot in path order
+func snapshotFiles(layout *Layout, resources []sanitizer.SanitizedResource) ([]snapshotFile, error) {
+	paths, err := layout.Paths(resources)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]snapshotFile, 0, len(resources))
+	for i, resource := range resources {
+		files = append(files, snapshotFile{path: paths[i], resource: resource})
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].path < files[j].path
+	})
+
+	return files, nil
+}
+
+// newManifest builds the manifest of a snapshot
+func newManifest(ctx context.Context, cluster string, createdAt time.Time, files []snapshotFile) *Manifest {
+	manifest := &Manifest{
+		Cluster:         cluster,
+		CreatedAt:       createdAt.UTC(),
+		Resources:       len(files),
+		ResourcesByKind: make(map[string]int),
+		Collection:      collectionFromContext(ctx),
+		Files:           make([]ManifestEntry, 0, len(files)),
+	}
+
+	for _, file := range files {
+		checksum := sha256.Sum256(file.resource.YAML)
+		manifest.ResourcesByKind[file.resource.Kind]++
+		manifest.Files = append(manifest.Files, ManifestEntry{
+			Path:       file.path,
+			APIVersion: file.resource.APIVersion,
+			Kind:       file.resource.Kind,
+			Namespace:  file.resource.Namespace,
+			Name:       file.resource.Name,
+			Size:       len(file.resource.YAML),
+			SHA256:     hex.EncodeToString(checksum[:]),
+		})
+	}
+
+	return manifest
+}
+
+// diffManifests returns the resources changed between two snapshots. Every
+// file is added if there is no previous snapshot.
+func diffManifests(previous, current *Manifest) []ResourceChange {
+	var changes []ResourceChange
+
+	before := make(map[string]ManifestEntry)
+	if previous != nil {
+		for _, entry := range previous.Files {
+			before[entry.Path] = entry
+		}
+	}
+
+	for _, entry := range current.Files {
+		old, existed := before[entry.Path]
+		delete(before, entry.Path)
+
+		switch {
+		case !existed:
+			changes = append(changes, entry.change(ChangeAdded))
+		case old.SHA256 != entry.SHA256:
+			changes = append(changes, entry.change(ChangeModified))
+		}
+	}
+
+	for _, entry := range before {
+		changes = append(changes, entry.change(ChangeDeleted))
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+
+	return changes
+}
+
+// change describes the entry as a changed resource
+func (e ManifestEntry) change(changeType string) ResourceChange {
+	return ResourceChange{
+		Type:      changeType,
+		Path:      e.Path,
+		Namespace: e.Namespace,
+		Kind:      e.Kind,
+		Name:      e.Name,
+	}
+}
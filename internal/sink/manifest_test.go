@@ -0,0 +1,38 @@
+package sink
+
+import "testing"
+
+func TestCollectionKeeps(t *testing.T) {
+	collection := &Collection{Kept: []KeptKind{
+		{Kind: "Secret"},
+		{Group: "metrics.k8s.io"}, // Failed to be discovered
+	}, KeptResources: []KeptResource{
+		{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "prod", Name: "web"},
+	}}
+
+	tests := []struct {
+		apiVersion string
+		kind       string
+		name       string
+		expected   bool
+	}{
+		{"v1", "Secret", "credentials", true},
+		{"v1", "ConfigMap", "settings", false},
+		{"apps/v1", "Secret", "credentials", false},
+		{"metrics.k8s.io/v1beta1", "PodMetrics", "web", true},
+		{"metrics.k8s.io/v1beta1", "NodeMetrics", "web", true},
+		{"apps/v1", "Deployment", "web", true},
+		{"apps/v1", "Deployment", "api", false},
+	}
+
+	for _, tt := range tests {
+		if got := collection.keeps(tt.apiVersion, tt.kind, "prod", tt.name); got != tt.expected {
+			t.Errorf("keeps(%s, %s, %s) = %v, expected %v", tt.apiVersion, tt.kind, tt.name, got, tt.expected)
+		}
+	}
+
+	var none *Collection
+	if none.keeps("v1", "Secret", "prod", "credentials") {
+		t.Errorf("Expected nothing to be kept without a collection")
+	}
+}
@@ -0,0 +1,169 @@
+package sink
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"kube-git-backup/internal/sanitizer"
+
+	"sigs.k8s.io/yaml"
+)
+
+// LayoutFileName records the path template a backup tree was written with
+const LayoutFileName = ".kube-git-backup-layout"
+
+// Move is a resource file moved to a new path by MigrateLayout
+type Move struct {
+	From string
+	To   string
+}
+
+// MigrateLayout moves the resource files below dir to their paths in layout
+// when the tree was written with a different path template, and records
+// the template in LayoutFileName. Trees without a record use
+// DefaultPathTemplate, so the record is only written for other templates.
+// Files that can't be decoded are left in place for the next backup to
+// replace.
+func MigrateLayout(dir string, layout *Layout) ([]Move, error) {
+	recordPath := filepath.Join(dir, LayoutFileName)
+	previous := DefaultPathTemplate
+	record, err := os.ReadFile(recordPath)
+	switch {
+	case err == nil:
+		previous = strings.TrimSpace(string(record))
+	case os.IsNotExist(err):
+		record = []byte(DefaultPathTemplate + "\n")
+	default:
+		return nil, fmt.Errorf("failed to read %s: %w", recordPath, err)
+	}
+
+	var moves []Move
+	if previous != layout.Template() {
+		moves, err = planMoves(dir, layout)
+		if err != nil {
+			return nil, fmt.Errorf("failed to plan layout migration: %w", err)
+		}
+		if err := applyMoves(dir, moves); err != nil {
+			return nil, err
+		}
+	}
+
+	if string(record) != layout.Template()+"\n" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create directory %s: %w", dir, err)
+		}
+		if err := os.WriteFile(recordPath, []byte(layout.Template()+"\n"), 0644); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", recordPath, err)
+		}
+	}
+
+	return moves, nil
+}
+
+// planMoves returns the files whose path differs in layout, checking that
+// no two files end up at the same path
+func planMoves(dir string, layout *Layout) ([]Move, error) {
+	files, err := ListResourceFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var moves []Move
+	targets := make(map[string]string, len(files)) // Final path of every file to its current path
+	for _, relPath := range files {
+		target := relPath
+
+		content, err := os.ReadFile(filepath.Join(dir, relPath))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", relPath, err)
+		}
+		if resource, ok := identify(content); ok {
+			if target, err = layout.Path(resource); err != nil {
+				return nil, err
+			}
+		}
+
+		if other, ok := targets[target]; ok {
+			return nil, fmt.Errorf("path collision: %s and %s both map to %s", other, relPath, target)
+		}
+		targets[target] = relPath
+		if target != relPath {
+			moves = append(moves, Move{From: relPath, To: target})
+		}
+	}
+
+	sort.Slice(moves, func(i, j int) bool {
+		return moves[i].From < moves[j].From
+	})
+
+	return moves, nil
+}
+
+// applyMoves moves the files in two steps, so that a file may take the old
+// path of another moved file, and removes the directories left empty
+func applyMoves(dir string, moves []Move) error {
+	contents := make([][]byte, len(moves))
+	for i, move := range moves {
+		from := filepath.Join(dir, move.From)
+		content, err := os.ReadFile(from)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", from, err)
+		}
+		contents[i] = content
+		if err := os.Remove(from); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", from, err)
+		}
+	}
+
+	for i, move := range moves {
+		to := filepath.Join(dir, move.To)
+		if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", filepath.Dir(to), err)
+		}
+		if err := os.WriteFile(to, contents[i], 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", to, err)
+		}
+	}
+
+	for _, move := range moves {
+		removeEmptyParents(dir, filepath.Dir(filepath.Join(dir, move.From)))
+	}
+
+	return nil
+}
+
+// removeEmptyParents removes empty directories from path up to, but not
+// including, root
+func removeEmptyParents(root, path string) {
+	for path != root && strings.HasPrefix(path, root) {
+		if err := os.Remove(path); err != nil {
+			return
+		}
+		path = filepath.Dir(path)
+	}
+}
+
+// identify decodes the identity of the resource in a file
+func identify(content []byte) (sanitizer.SanitizedResource, bool) {
+	var obj struct {
+		APIVersion string `json:"apiVersion"`
+		Kind       string `json:"kind"`
+		Metadata   struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"metadata"`
+	}
+	if err := yaml.Unmarshal(content, &obj); err != nil || obj.Kind == "" || obj.Metadata.Name == "" {
+		return sanitizer.SanitizedResource{}, false
+	}
+
+	return sanitizer.SanitizedResource{
+		APIVersion: obj.APIVersion,
+		Kind:       obj.Kind,
+		Namespace:  obj.Metadata.Namespace,
+		Name:       obj.Metadata.Name,
+	}, true
+}
@@ -0,0 +1,131 @@
+package sink
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMigrateLayout(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"namespaces/prod/deployment/web.yaml":     "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: web\n  namespace: prod\n",
+		"namespaces/prod/configmap/settings.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\n  namespace: prod\n",
+		"cluster-scoped/storageclass/slow.yaml":   "apiVersion: storage.k8s.io/v1\nkind: StorageClass\nmetadata:\n  name: slow\n",
+		"notes/broken.yaml":                       "not: [valid",
+	})
+
+	// Trees without a record use the default layout and are left untouched
+	moves, err := MigrateLayout(dir, DefaultLayout)
+	if err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	if len(moves) != 0 {
+		t.Errorf("Expected no moves, got %v", moves)
+	}
+	if _, err := os.Stat(filepath.Join(dir, LayoutFileName)); !os.IsNotExist(err) {
+		t.Errorf("Expected no layout record for the default layout, got %v", err)
+	}
+
+	grouped := mustNewLayout(GroupedPathTemplate, "production")
+	moves, err = MigrateLayout(dir, grouped)
+	if err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	var got []string
+	for _, move := range moves {
+		got = append(got, move.From+" -> "+move.To)
+	}
+	expected := []string{
+		"cluster-scoped/storageclass/slow.yaml -> cluster-scoped/storageclass.storage.k8s.io/slow.yaml",
+		"namespaces/prod/deployment/web.yaml -> namespaces/prod/deployment.apps/web.yaml",
+	}
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected moves %v, got %v", expected, got)
+	}
+
+	files, err := ListResourceFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedFiles := []string{
+		"cluster-scoped/storageclass.storage.k8s.io/slow.yaml",
+		"namespaces/prod/configmap/settings.yaml",
+		"namespaces/prod/deployment.apps/web.yaml",
+		"notes/broken.yaml",
+	}
+	if strings.Join(files, ",") != strings.Join(expectedFiles, ",") {
+		t.Errorf("Expected files %v, got %v", expectedFiles, files)
+	}
+	for _, emptied := range []string{"namespaces/prod/deployment", "cluster-scoped/storageclass"} {
+		if _, err := os.Stat(filepath.Join(dir, emptied)); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed, got %v", emptied, err)
+		}
+	}
+
+	record, err := os.ReadFile(filepath.Join(dir, LayoutFileName))
+	if err != nil {
+		t.Fatalf("Expected a layout record: %v", err)
+	}
+	if string(record) != GroupedPathTemplate+"\n" {
+		t.Errorf("Unexpected layout record %q", record)
+	}
+
+	// Running again is a no-op
+	moves, err = MigrateLayout(dir, grouped)
+	if err != nil || len(moves) != 0 {
+		t.Errorf("Expected no moves on the second run, got %v, %v", moves, err)
+	}
+
+	// Switching back restores the original paths
+	moves, err = MigrateLayout(dir, DefaultLayout)
+	if err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	if len(moves) != 2 {
+		t.Errorf("Expected 2 moves, got %v", moves)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "namespaces/prod/deployment/web.yaml")); err != nil {
+		t.Errorf("Expected web to be moved back: %v", err)
+	}
+}
+
+func TestMigrateLayoutCollision(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"namespaces/prod/configmap/settings.yaml":    "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\n  namespace: prod\n",
+		"namespaces/staging/configmap/settings.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\n  namespace: staging\n",
+	})
+
+	flat := mustNewLayout("{{lower .Kind}}/{{.Name}}.yaml", "production")
+	_, err := MigrateLayout(dir, flat)
+	if err == nil || !strings.Contains(err.Error(), "path collision") {
+		t.Fatalf("Expected a path collision error, got %v", err)
+	}
+
+	// Nothing is moved or recorded when the migration is rejected
+	for _, relPath := range []string{"namespaces/prod/configmap/settings.yaml", "namespaces/staging/configmap/settings.yaml"} {
+		if _, err := os.Stat(filepath.Join(dir, relPath)); err != nil {
+			t.Errorf("Expected %s to be kept: %v", relPath, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, LayoutFileName)); !os.IsNotExist(err) {
+		t.Errorf("Expected no layout record, got %v", err)
+	}
+}
+
+// writeTestFiles writes files, keyed by slash-separated path, below dir
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for relPath, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(relPath))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package sink
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"kube-git-backup/internal/config"
+	"kube-git-backup/internal/logging"
+	"kube-git-backup/internal/metrics"
+	"kube-git-backup/internal/sanitizer"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/content/file"
+	"oras.land/oras-go/v2/errdef"
+	"oras.land/oras-go/v2/registry/remote"
+	"oras.land/oras-go/v2/registry/remote/auth"
+	"oras.land/oras-go/v2/registry/remote/retry"
+)
+
+// Media types and annotations of the snapshot artifacts
+const (
+	ArtifactType         = "application/vnd.kube-git-backup.snapshot.v1"
+	ManifestMediaType    = "application/vnd.kube-git-backup.manifest.v1+json"
+	AnnotationCluster    = "io.kube-git-backup.cluster"
+	AnnotationResources  = "io.kube-git-backup.resources"
+	annotationKindPrefix = AnnotationResources + "."
+	latestTag            = "latest"
+)
+
+// OCI pushes every snapshot to a container registry as an OCI artifact
+// tagged with its timestamp and latest. The config blob is the snapshot's
+// manifest.json, which indexes the files, and the files are packed into a
+// single gzipped tar layer titled with the cluster name, which `oras pull`
+// unpacks into a directory with the repository layout. The layer is
+// reproducible, so an unchanged snapshot is stored only once.
+type OCI struct {
+	target     oras.Target
+	repository string
+	cluster    string
+	layout     *Layout
+	previous   *Manifest // Manifest of the latest artifact, loaded on first use
+	pending    *ociSnapshot
+	now        func() time.Time
+}
+
+// ociSnapshot is a snapshot staged by WriteSnapshot
+type ociSnapshot struct {
+	files    []snapshotFile
+	manifest *Manifest
+}
+
+// NewOCI creates an OCI sink pushing to cfg.Repository
+func NewOCI(cfg config.OCIConfig, clusterName string, layout *Layout) (*OCI, error) {
+	repository, err := remote.NewRepository(cfg.Repository)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OCI_REPOSITORY: %w", err)
+	}
+	if repository.Reference.Reference != "" {
+		return nil, fmt.Errorf("OCI_REPOSITORY must not include a tag or digest")
+	}
+
+	client := &auth.Client{
+		Client: retry.DefaultClient,
+		Cache:  auth.NewCache(),
+	}
+	if cfg.Username != "" {
+		client.Credential = auth.StaticCredential(repository.Reference.Registry, auth.Credential{
+			Username: cfg.Username,
+			Password: cfg.Password,
+		})
+	}
+	repository.Client = client
+	repository.PlainHTTP = cfg.Insecure
+
+	return newOCI(repository, cfg.Repository, clusterName, layout), nil
+}
+
+// newOCI creates an OCI sink on top of target
+func newOCI(target oras.Target, repository, clusterName string, layout *Layout) *OCI {
+	return &OCI{
+		target:     target,
+		repository: repository,
+		cluster:    clusterName,
+		layout:     layout,
+		now:        time.Now,
+	}
+}
+
+// Name returns the name of the sink
+func (o *OCI) Name() string {
+	return "oci"
+}
+
+// WriteSnapshot stages a snapshot, the artifact is pushed by Finalize
+func (o *OCI) WriteSnapshot(ctx context.Context, resources []sanitizer.SanitizedResource) error {
+	files, err := snapshotFiles(o.layout, resources)
+	if err != nil {
+		return err
+	}
+	o.pending = &ociSnapshot{
+		files:    files,
+		manifest: newManifest(ctx, o.cluster, o.now(), files),
+	}
+	return nil
+}
+
+// Finalize pushes the staged snapshot, tags it and reports the changes since
+// the latest artifact
+func (o *OCI) Finalize(ctx context.Context) (Result, error) {
+	snapshot := o.pending
+	if snapshot == nil {
+		return Result{}, nil
+	}
+	o.pending = nil
+
+	previous, err := o.previousManifest(ctx)
+	if err != nil {
+		return Result{}, err
+	}
+
+	pushStart := time.Now()
+	tag := snapshot.manifest.CreatedAt.Format(snapshotTimeFormat)
+	snapshot.manifest.Location = o.repository + ":" + tag
+
+	layer, layerDesc, err := o.packLayer(snapshot.files)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to pack snapshot: %w", err)
+	}
+	if err := o.pushBlob(ctx, layerDesc, layer); err != nil {
+		return Result{}, fmt.Errorf("failed to push snapshot layer: %w", err)
+	}
+
+	configContent, err := json.MarshalIndent(snapshot.manifest, "", "  ")
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	configDesc := content.NewDescriptorFromBytes(ManifestMediaType, configContent)
+	if err := o.pushBlob(ctx, configDesc, configContent); err != nil {
+		return Result{}, fmt.Errorf("failed to push %s: %w", ManifestFileName, err)
+	}
+
+	manifestDesc, err := oras.PackManifest(ctx, o.target, oras.PackManifestVersion1_1, ArtifactType,
+		oras.PackManifestOptions{
+			Layers:              []ocispec.Descriptor{layerDesc},
+			ConfigDescriptor:    &configDesc,
+			ManifestAnnotations: snapshotAnnotations(snapshot.manifest),
+		})
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to push artifact manifest: %w", err)
+	}
+
+	for _, reference := range []string{tag, latestTag} {
+		if err := o.target.Tag(ctx, manifestDesc, reference); err != nil {
+			return Result{}, fmt.Errorf("failed to tag artifact as %s: %w", reference, err)
+		}
+	}
+	metrics.ObservePhase(o.cluster, metrics.PhasePush, pushStart)
+
+	o.previous = snapshot.manifest
+	logging.FromContext(ctx).Info("Pushed artifact", "reference", snapshot.manifest.Location,
+		"digest", manifestDesc.Digest.String(), "resources", snapshot.manifest.Resources)
+
+	return Result{
+		Reference: snapshot.manifest.Location,
+		Changes:   diffManifests(previous, snapshot.manifest),
+	}, nil
+}
+
+// Close does nothing, the client holds no resources that need releasing
+func (o *OCI) Close() error {
+	return nil
+}
+
+// packLayer packs the files of a snapshot into a gzipped tar below a
+// directory named after the cluster, annotated the way `oras push` annotates
+// directories so that `oras pull` unpacks it. Entries carry no timestamps, so
+// the same files always produce the same layer.
+func (o *OCI) packLayer(files []snapshotFile) ([]byte, ocispec.Descriptor, error) {
+	var tarBuffer bytes.Buffer
+	tarWriter := tar.NewWriter(&tarBuffer)
+	written := make(map[string]bool)
+	for _, file := range files {
+		name := path.Join(o.cluster, file.path)
+
+		// oras only creates the directories listed in the tar
+		var dirs []string
+		for dir := path.Dir(name); dir != "." && !written[dir]; dir = path.Dir(dir) {
+			dirs = append(dirs, dir)
+			written[dir] = true
+		}
+		for i := len(dirs) - 1; i >= 0; i-- {
+			header := &tar.Header{Typeflag: tar.TypeDir, Name: dirs[i] + "/", Mode: 0755,
+				ModTime: time.Unix(0, 0), Format: tar.FormatPAX}
+			if err := tarWriter.WriteHeader(header); err != nil {
+				return nil, ocispec.Descriptor{}, fmt.Errorf("failed to write archive header for %s: %w", dirs[i], err)
+			}
+		}
+
+		if err := writeTarFile(tarWriter, name, file.resource.YAML, time.Unix(0, 0)); err != nil {
+			return nil, ocispec.Descriptor{}, err
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		return nil, ocispec.Descriptor{}, err
+	}
+
+	var layer bytes.Buffer
+	gzipWriter := gzip.NewWriter(&layer)
+	if _, err := gzipWriter.Write(tarBuffer.Bytes()); err != nil {
+		return nil, ocispec.Descriptor{}, err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return nil, ocispec.Descriptor{}, err
+	}
+
+	desc := content.NewDescriptorFromBytes(ocispec.MediaTypeImageLayerGzip, layer.Bytes())
+	desc.Annotations = map[string]string{
+		ocispec.AnnotationTitle: o.cluster,
+		file.AnnotationDigest:   digest.FromBytes(tarBuffer.Bytes()).String(),
+		file.AnnotationUnpack:   "true",
+	}
+	return layer.Bytes(), desc, nil
+}
+
+// pushBlob pushes a blob unless the registry already has it
+func (o *OCI) pushBlob(ctx context.Context, desc ocispec.Descriptor, data []byte) error {
+	exists, err := o.target.Exists(ctx, desc)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	err = o.target.Push(ctx, desc, bytes.NewReader(data))
+	if errors.Is(err, errdef.ErrAlreadyExists) {
+		return nil
+	}
+	return err
+}
+
+// previousManifest returns the manifest of the artifact tagged latest, or
+// nil if there is none
+func (o *OCI) previousManifest(ctx context.Context) (*Manifest, error) {
+	if o.previous != nil {
+		return o.previous, nil
+	}
+
+	desc, err := o.target.Resolve(ctx, latestTag)
+	if errors.Is(err, errdef.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s:%s: %w", o.repository, latestTag, err)
+	}
+
+	manifestContent, err := content.FetchAll(ctx, o.target, desc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s:%s: %w", o.repository, latestTag, err)
+	}
+	var artifact ocispec.Manifest
+	if err := json.Unmarshal(manifestContent, &artifact); err != nil {
+		return nil, fmt.Errorf("failed to decode %s:%s: %w", o.repository, latestTag, err)
+	}
+	if artifact.ArtifactType != ArtifactType || artifact.Config.MediaType != ManifestMediaType {
+		return nil, fmt.Errorf("%s:%s is not a kube-git-backup snapshot", o.repository, latestTag)
+	}
+
+	configContent, err := content.FetchAll(ctx, o.target, artifact.Config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch manifest of %s:%s: %w", o.repository, latestTag, err)
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(configContent, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest of %s:%s: %w", o.repository, latestTag, err)
+	}
+	return &manifest, nil
+}
+
+// snapshotAnnotations returns the annotations of a snapshot artifact: its
+// creation time, cluster name, and resource counts in total and per kind
+func snapshotAnnotations(manifest *Manifest) map[string]string {
+	annotations := map[string]string{
+		ocispec.AnnotationCreated: manifest.CreatedAt.Format(time.RFC3339),
+		ocispec.AnnotationTitle:   manifest.Cluster + " " + manifest.CreatedAt.Format(snapshotTimeFormat),
+		AnnotationCluster:         manifest.Cluster,
+		AnnotationResources:       strconv.Itoa(manifest.Resources),
+	}
+	for kind, count := range manifest.ResourcesByKind {
+		annotations[annotationKindPrefix+strings.ToLower(kind)] = strconv.Itoa(count)
+	}
+	return annotations
+}
@@ -0,0 +1,191 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"kube-git-backup/internal/config"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/content/file"
+	"oras.land/oras-go/v2/content/memory"
+)
+
+func TestOCI(t *testing.T) {
+	store := memory.New()
+	o := newOCI(store, "localhost:5000/backups/production", "production", DefaultLayout)
+	o.now = func() time.Time { return time.Date(2024, 6, 1, 2, 30, 0, 0, time.UTC) }
+	ctx := context.Background()
+
+	if err := o.WriteSnapshot(ctx, testResources()); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+	result, err := o.Finalize(ctx)
+	if err != nil {
+		t.Fatalf("Failed to finalize: %v", err)
+	}
+
+	if result.Reference != "localhost:5000/backups/production:20240601T023000Z" {
+		t.Errorf("Unexpected reference %s", result.Reference)
+	}
+	if added, _, _ := result.Counts(); added != 2 {
+		t.Errorf("Expected 2 added resources, got %d", added)
+	}
+
+	artifact := fetchArtifact(t, store, "20240601T023000Z")
+	latest := fetchArtifact(t, store, latestTag)
+	if latest.Config.Digest != artifact.Config.Digest {
+		t.Errorf("Expected latest to point at the new artifact")
+	}
+
+	if artifact.ArtifactType != ArtifactType || artifact.Config.MediaType != ManifestMediaType {
+		t.Errorf("Unexpected artifact type %s with config %s", artifact.ArtifactType, artifact.Config.MediaType)
+	}
+	expectedAnnotations := map[string]string{
+		AnnotationCluster:                     "production",
+		AnnotationResources:                   "2",
+		AnnotationResources + ".configmap":    "1",
+		AnnotationResources + ".storageclass": "1",
+		ocispec.AnnotationCreated:             "2024-06-01T02:30:00Z",
+	}
+	for key, value := range expectedAnnotations {
+		if artifact.Annotations[key] != value {
+			t.Errorf("Expected annotation %s=%s, got %q", key, value, artifact.Annotations[key])
+		}
+	}
+
+	// The files are packed into a single layer that `oras pull` unpacks
+	// into a directory named after the cluster
+	if len(artifact.Layers) != 1 || artifact.Layers[0].MediaType != ocispec.MediaTypeImageLayerGzip ||
+		artifact.Layers[0].Annotations[ocispec.AnnotationTitle] != "production" {
+		t.Fatalf("Expected a single gzipped tar layer titled production, got %+v", artifact.Layers)
+	}
+	pullDir := t.TempDir()
+	fileStore, err := file.New(pullDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fileStore.Close()
+	if _, err := oras.Copy(ctx, store, "20240601T023000Z", fileStore, "", oras.DefaultCopyOptions); err != nil {
+		t.Fatalf("Failed to pull artifact: %v", err)
+	}
+	for _, resource := range testResources() {
+		relPath, err := DefaultLayout.Path(resource)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pulled, err := os.ReadFile(filepath.Join(pullDir, "production", relPath))
+		if err != nil {
+			t.Errorf("Expected %s to be pulled: %v", relPath, err)
+		} else if string(pulled) != string(resource.YAML) {
+			t.Errorf("Unexpected content of %s:\n%s", relPath, pulled)
+		}
+	}
+
+	// An unchanged snapshot reuses the layer
+	o.now = func() time.Time { return time.Date(2024, 6, 1, 2, 45, 0, 0, time.UTC) }
+	if err := o.WriteSnapshot(ctx, testResources()); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+	if _, err := o.Finalize(ctx); err != nil {
+		t.Fatalf("Failed to finalize: %v", err)
+	}
+	if unchanged := fetchArtifact(t, store, "20240601T024500Z"); unchanged.Layers[0].Digest != artifact.Layers[0].Digest {
+		t.Errorf("Expected an unchanged snapshot to reuse layer %s, got %s", artifact.Layers[0].Digest,
+			unchanged.Layers[0].Digest)
+	}
+
+	// A new sink picks up the previous snapshot from the latest tag
+	o = newOCI(store, "localhost:5000/backups/production", "production", DefaultLayout)
+	o.now = func() time.Time { return time.Date(2024, 6, 1, 3, 30, 0, 0, time.UTC) }
+
+	resources := testResources()[:1]
+	resources[0].YAML = append(resources[0].YAML, []byte("data:\n  key: value\n")...)
+	if err := o.WriteSnapshot(ctx, resources); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+	result, err = o.Finalize(ctx)
+	if err != nil {
+		t.Fatalf("Failed to finalize: %v", err)
+	}
+
+	var got []string
+	for _, change := range result.Changes {
+		got = append(got, change.Type+" "+change.String())
+	}
+	if strings.Join(got, ",") != "deleted StorageClass/slow,modified prod/ConfigMap/settings" {
+		t.Errorf("Unexpected changes: %v", got)
+	}
+
+	// Earlier snapshots keep their tag
+	if _, err := store.Resolve(ctx, "20240601T023000Z"); err != nil {
+		t.Errorf("Expected the first snapshot to be kept: %v", err)
+	}
+}
+
+// TestOCIRegistry runs the OCI sink against a real registry, e.g.
+//
+//	docker run -p 5000:5000 registry:2
+//	OCI_TEST_REPOSITORY=localhost:5000/kube-git-backup-test go test ./internal/sink/ -run OCIRegistry
+func TestOCIRegistry(t *testing.T) {
+	repository := os.Getenv("OCI_TEST_REPOSITORY")
+	if repository == "" {
+		t.Skip("OCI_TEST_REPOSITORY not set")
+	}
+
+	o, err := NewOCI(config.OCIConfig{
+		Repository: repository,
+		Username:   os.Getenv("OCI_TEST_USERNAME"),
+		Password:   os.Getenv("OCI_TEST_PASSWORD"),
+		Insecure:   true,
+	}, "test", DefaultLayout)
+	if err != nil {
+		t.Fatalf("Failed to create OCI sink: %v", err)
+	}
+	defer o.Close()
+
+	ctx := context.Background()
+	if err := o.WriteSnapshot(ctx, testResources()); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+	if _, err := o.Finalize(ctx); err != nil {
+		t.Fatalf("Failed to finalize: %v", err)
+	}
+
+	// A fresh sink reads the manifest back from the registry
+	o.previous = nil
+	manifest, err := o.previousManifest(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	if manifest == nil || manifest.Resources != 2 {
+		t.Errorf("Unexpected manifest: %+v", manifest)
+	}
+}
+
+// fetchArtifact returns the image manifest tagged reference
+func fetchArtifact(t *testing.T, target oras.ReadOnlyTarget, reference string) ocispec.Manifest {
+	t.Helper()
+
+	desc, err := target.Resolve(context.Background(), reference)
+	if err != nil {
+		t.Fatalf("Failed to resolve %s: %v", reference, err)
+	}
+	data, err := content.FetchAll(context.Background(), target, desc)
+	if err != nil {
+		t.Fatalf("Failed to fetch %s: %v", reference, err)
+	}
+
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatalf("Failed to decode %s: %v", reference, err)
+	}
+	return manifest
+}
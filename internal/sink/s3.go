@@ -0,0 +1,242 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"kube-git-backup/internal/config"
+	"kube-git-backup/internal/logging"
+	"kube-git-backup/internal/metrics"
+	"kube-git-backup/internal/sanitizer"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// snapshotTimeFormat names the snapshots of a cluster so that they sort by time
+const snapshotTimeFormat = "20060102T150405Z"
+
+// errObjectNotFound is returned by objectStore.Get for missing objects
+var errObjectNotFound = errors.New("object not found")
+
+// objectStore is the subset of an S3 client used by the S3 sink
+type objectStore interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// S3 uploads every snapshot to an S3-compatible bucket under
+// <prefix>/<timestamp>/, either as individual objects using the repository
+// layout or as a single snapshot.tar.gz, along with a manifest.json index of
+// the snapshot. <prefix>/latest.json is a copy of the newest manifest.
+type S3 struct {
+	store    objectStore
+	bucket   string
+	prefix   string
+	format   string
+	cluster  string
+	layout   *Layout
+	previous *Manifest // Manifest of the last uploaded snapshot, loaded on first use
+	pending  *s3Snapshot
+	now      func() time.Time
+}
+
+// s3Snapshot is a snapshot staged by WriteSnapshot
+type s3Snapshot struct {
+	location string // Key prefix of the snapshot
+	files    []snapshotFile
+	manifest *Manifest
+}
+
+// NewS3 creates an S3 sink and checks that the bucket exists
+func NewS3(cfg config.S3Config, clusterName string, layout *Layout) (*S3, error) {
+	creds := credentials.NewStaticV4(cfg.AccessKeyID, cfg.SecretAccessKey, "")
+	if cfg.AccessKeyID == "" {
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+			&credentials.IAM{},
+		})
+	}
+
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: !cfg.Insecure,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	exists, err := client.BucketExists(ctx, cfg.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check bucket %s: %w", cfg.Bucket, err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("bucket %s does not exist", cfg.Bucket)
+	}
+
+	return newS3(&minioStore{client: client, bucket: cfg.Bucket}, cfg, clusterName, layout), nil
+}
+
+// newS3 creates an S3 sink on top of store
+func newS3(store objectStore, cfg config.S3Config, clusterName string, layout *Layout) *S3 {
+	return &S3{
+		store:   store,
+		bucket:  cfg.Bucket,
+		prefix:  cfg.Prefix,
+		format:  cfg.Format,
+		cluster: clusterName,
+		layout:  layout,
+		now:     time.Now,
+	}
+}
+
+// Name returns the name of the sink
+func (s *S3) Name() string {
+	return "s3"
+}
+
+// WriteSnapshot stages a new versioned snapshot. In the objects format the
+// resource objects are uploaded right away; they only become part of the
+// snapshot index once Finalize uploads the manifest.
+func (s *S3) WriteSnapshot(ctx context.Context, resources []sanitizer.SanitizedResource) error {
+	writeStart := time.Now()
+	createdAt := s.now().UTC()
+	location := path.Join(s.prefix, createdAt.Format(snapshotTimeFormat))
+
+	files, err := snapshotFiles(s.layout, resources)
+	if err != nil {
+		return err
+	}
+	manifest := newManifest(ctx, s.cluster, createdAt, files)
+	manifest.Location = fmt.Sprintf("s3://%s/%s/", s.bucket, location)
+
+	if s.format == config.S3FormatObjects {
+		for _, file := range files {
+			key := path.Join(location, file.path)
+			if err := s.store.Put(ctx, key, file.resource.YAML, "application/yaml"); err != nil {
+				return fmt.Errorf("failed to upload %s: %w", key, err)
+			}
+		}
+	}
+	metrics.ObservePhase(s.cluster, metrics.PhaseWrite, writeStart)
+
+	s.pending = &s3Snapshot{location: location, files: files, manifest: manifest}
+	return nil
+}
+
+// Finalize uploads the archive (in the tarball format) and the manifest of
+// the staged snapshot, and reports the changes since the previous snapshot
+func (s *S3) Finalize(ctx context.Context) (Result, error) {
+	snapshot := s.pending
+	if snapshot == nil {
+		return Result{}, nil
+	}
+	s.pending = nil
+
+	previous, err := s.previousManifest(ctx)
+	if err != nil {
+		return Result{}, err
+	}
+
+	pushStart := time.Now()
+	if s.format == config.S3FormatTarball {
+		var archive bytes.Buffer
+		if err := writeArchive(&archive, config.ArchiveCompressionGzip, snapshot.files, snapshot.manifest); err != nil {
+			return Result{}, fmt.Errorf("failed to create archive: %w", err)
+		}
+
+		key := path.Join(snapshot.location, "snapshot.tar.gz")
+		if err := s.store.Put(ctx, key, archive.Bytes(), "application/gzip"); err != nil {
+			return Result{}, fmt.Errorf("failed to upload %s: %w", key, err)
+		}
+	}
+
+	content, err := json.MarshalIndent(snapshot.manifest, "", "  ")
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	for _, key := range []string{path.Join(snapshot.location, ManifestFileName), path.Join(s.prefix, "latest.json")} {
+		if err := s.store.Put(ctx, key, content, "application/json"); err != nil {
+			return Result{}, fmt.Errorf("failed to upload %s: %w", key, err)
+		}
+	}
+	metrics.ObservePhase(s.cluster, metrics.PhasePush, pushStart)
+
+	s.previous = snapshot.manifest
+	logging.FromContext(ctx).Info("Uploaded snapshot", "location", snapshot.manifest.Location,
+		"resources", snapshot.manifest.Resources)
+
+	return Result{
+		Reference: snapshot.manifest.Location,
+		Changes:   diffManifests(previous, snapshot.manifest),
+	}, nil
+}
+
+// Close does nothing, the client holds no resources that need releasing
+func (s *S3) Close() error {
+	return nil
+}
+
+// previousManifest returns the manifest of the last uploaded snapshot, or
+// nil if there is none
+func (s *S3) previousManifest(ctx context.Context) (*Manifest, error) {
+	if s.previous != nil {
+		return s.previous, nil
+	}
+
+	key := path.Join(s.prefix, "latest.json")
+	content, err := s.store.Get(ctx, key)
+	if errors.Is(err, errObjectNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", key, err)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", key, err)
+	}
+	return &manifest, nil
+}
+
+// minioStore implements objectStore with the MinIO client
+type minioStore struct {
+	client *minio.Client
+	bucket string
+}
+
+// Put uploads an object
+func (m *minioStore) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	_, err := m.client.PutObject(ctx, m.bucket, key, bytes.NewReader(data), int64(len(data)),
+		minio.PutObjectOptions{ContentType: contentType})
+	return err
+}
+
+// Get downloads an object
+func (m *minioStore) Get(ctx context.Context, key string) ([]byte, error) {
+	object, err := m.client.GetObject(ctx, m.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+
+	data, err := io.ReadAll(object)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, errObjectNotFound
+		}
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,233 @@
+package sink
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"kube-git-backup/internal/config"
+	"kube-git-backup/internal/sanitizer"
+)
+
+// memoryStore is an in-memory objectStore
+type memoryStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{objects: make(map[string][]byte)}
+}
+
+func (m *memoryStore) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[key] = append([]byte(nil), data...)
+	return nil
+}
+
+func (m *memoryStore) Get(ctx context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.objects[key]
+	if !ok {
+		return nil, errObjectNotFound
+	}
+	return data, nil
+}
+
+func (m *memoryStore) keys() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var keys []string
+	for key := range m.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func testResources() []sanitizer.SanitizedResource {
+	return []sanitizer.SanitizedResource{
+		{APIVersion: "v1", Kind: "ConfigMap", Namespace: "prod", Name: "settings",
+			YAML: []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\n  namespace: prod\n")},
+		{APIVersion: "storage.k8s.io/v1", Kind: "StorageClass", Name: "slow",
+			YAML: []byte("apiVersion: storage.k8s.io/v1\nkind: StorageClass\nmetadata:\n  name: slow\n")},
+	}
+}
+
+func TestS3Objects(t *testing.T) {
+	store := newMemoryStore()
+	s := newS3(store, config.S3Config{Bucket: "backups", Prefix: "production", Format: config.S3FormatObjects}, "production", DefaultLayout)
+	s.now = func() time.Time { return time.Date(2024, 6, 1, 2, 30, 0, 0, time.UTC) }
+	ctx := context.Background()
+
+	if err := s.WriteSnapshot(ctx, testResources()); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+	result, err := s.Finalize(ctx)
+	if err != nil {
+		t.Fatalf("Failed to finalize: %v", err)
+	}
+
+	if result.Reference != "s3://backups/production/20240601T023000Z/" {
+		t.Errorf("Unexpected reference %s", result.Reference)
+	}
+	if added, _, _ := result.Counts(); added != 2 {
+		t.Errorf("Expected 2 added resources, got %d", added)
+	}
+
+	expected := []string{
+		"production/20240601T023000Z/cluster-scoped/storageclass/slow.yaml",
+		"production/20240601T023000Z/manifest.json",
+		"production/20240601T023000Z/namespaces/prod/configmap/settings.yaml",
+		"production/latest.json",
+	}
+	if got := store.keys(); strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected objects:\n%s\nexpected:\n%s", strings.Join(got, "\n"), strings.Join(expected, "\n"))
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(store.objects["production/latest.json"], &manifest); err != nil {
+		t.Fatalf("Failed to decode manifest: %v", err)
+	}
+	if manifest.Cluster != "production" || manifest.Resources != 2 || len(manifest.Files) != 2 {
+		t.Errorf("Unexpected manifest: %+v", manifest)
+	}
+	if manifest.Files[0].SHA256 == "" || manifest.Files[0].Path != "cluster-scoped/storageclass/slow.yaml" {
+		t.Errorf("Unexpected manifest entry: %+v", manifest.Files[0])
+	}
+
+	// A new sink picks up the previous snapshot from latest.json
+	s = newS3(store, config.S3Config{Bucket: "backups", Prefix: "production", Format: config.S3FormatObjects}, "production", DefaultLayout)
+	s.now = func() time.Time { return time.Date(2024, 6, 1, 3, 30, 0, 0, time.UTC) }
+
+	resources := testResources()[:1]
+	resources[0].YAML = append(resources[0].YAML, []byte("data:\n  key: value\n")...)
+	if err := s.WriteSnapshot(ctx, resources); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+	result, err = s.Finalize(ctx)
+	if err != nil {
+		t.Fatalf("Failed to finalize: %v", err)
+	}
+
+	var got []string
+	for _, change := range result.Changes {
+		got = append(got, change.Type+" "+change.String())
+	}
+	if strings.Join(got, ",") != "deleted StorageClass/slow,modified prod/ConfigMap/settings" {
+		t.Errorf("Unexpected changes: %v", got)
+	}
+
+	// Earlier snapshots are kept
+	if _, err := store.Get(ctx, "production/20240601T023000Z/manifest.json"); err != nil {
+		t.Errorf("Expected the first snapshot to be kept: %v", err)
+	}
+}
+
+func TestS3Tarball(t *testing.T) {
+	store := newMemoryStore()
+	s := newS3(store, config.S3Config{Bucket: "backups", Prefix: "staging", Format: config.S3FormatTarball}, "staging", DefaultLayout)
+	s.now = func() time.Time { return time.Date(2024, 6, 1, 2, 30, 0, 0, time.UTC) }
+	ctx := context.Background()
+
+	if err := s.WriteSnapshot(ctx, testResources()); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+	if _, err := s.Finalize(ctx); err != nil {
+		t.Fatalf("Failed to finalize: %v", err)
+	}
+
+	expected := []string{
+		"staging/20240601T023000Z/manifest.json",
+		"staging/20240601T023000Z/snapshot.tar.gz",
+		"staging/latest.json",
+	}
+	if got := store.keys(); strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected objects:\n%s\nexpected:\n%s", strings.Join(got, "\n"), strings.Join(expected, "\n"))
+	}
+
+	gzipReader, err := gzip.NewReader(bytes.NewReader(store.objects["staging/20240601T023000Z/snapshot.tar.gz"]))
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	tarReader := tar.NewReader(gzipReader)
+
+	var names []string
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read archive: %v", err)
+		}
+		names = append(names, header.Name)
+	}
+
+	expectedNames := "manifest.json,cluster-scoped/storageclass/slow.yaml,namespaces/prod/configmap/settings.yaml"
+	if strings.Join(names, ",") != expectedNames {
+		t.Errorf("Unexpected archive contents: %v", names)
+	}
+}
+
+// TestS3MinIO runs the S3 sink against a real S3-compatible service, e.g.
+//
+//	docker run -p 9000:9000 minio/minio server /data
+//	S3_TEST_ENDPOINT=localhost:9000 S3_TEST_BUCKET=backups go test ./internal/sink/ -run MinIO
+//
+// The bucket must exist. Credentials default to minioadmin/minioadmin.
+func TestS3MinIO(t *testing.T) {
+	endpoint := os.Getenv("S3_TEST_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("S3_TEST_ENDPOINT not set")
+	}
+
+	cfg := config.S3Config{
+		Endpoint:        endpoint,
+		Bucket:          os.Getenv("S3_TEST_BUCKET"),
+		AccessKeyID:     "minioadmin",
+		SecretAccessKey: "minioadmin",
+		Prefix:          "kube-git-backup-test/" + time.Now().UTC().Format(snapshotTimeFormat),
+		Format:          config.S3FormatTarball,
+		Insecure:        true,
+	}
+	if accessKey := os.Getenv("S3_TEST_ACCESS_KEY_ID"); accessKey != "" {
+		cfg.AccessKeyID = accessKey
+		cfg.SecretAccessKey = os.Getenv("S3_TEST_SECRET_ACCESS_KEY")
+	}
+
+	s, err := NewS3(cfg, "test", DefaultLayout)
+	if err != nil {
+		t.Fatalf("Failed to create S3 sink: %v", err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	if err := s.WriteSnapshot(ctx, testResources()); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+	if _, err := s.Finalize(ctx); err != nil {
+		t.Fatalf("Failed to finalize: %v", err)
+	}
+
+	// A fresh sink reads the manifest back from the bucket
+	s.previous = nil
+	manifest, err := s.previousManifest(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	if manifest == nil || manifest.Resources != 2 {
+		t.Errorf("Unexpected manifest: %+v", manifest)
+	}
+}
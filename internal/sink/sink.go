@@ -0,0 +1,119 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"kube-git-backup/internal/sanitizer"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Change types reported for backed-up resources
+const (
+	ChangeAdded    = "added"
+	ChangeModified = "modified"
+	ChangeDeleted  = "deleted"
+)
+
+// Sink is a destination for backup snapshots. A backup run calls
+// WriteSnapshot with every collected resource and then Finalize.
+type Sink interface {
+	// Name identifies the sink in logs and summaries
+	Name() string
+
+	// WriteSnapshot stages a complete snapshot of the cluster, replacing
+	// the previous one
+	WriteSnapshot(ctx context.Context, resources []sanitizer.SanitizedResource) error
+
+	// Finalize persists what was staged since the last Finalize (e.g. commits
+	// and pushes it) and reports the resources that changed
+	Finalize(ctx context.Context) (Result, error)
+
+	// Close releases the resources held by the sink
+	Close() error
+}
+
+// IncrementalSink is a Sink that can also stage individual changes, as
+// reported in watch mode, without a complete snapshot
+type IncrementalSink interface {
+	Sink
+
+	// WriteChanges stages the updated and deleted resources, leaving every
+	// other resource untouched
+	WriteChanges(ctx context.Context, updated, deleted []sanitizer.SanitizedResource) error
+}
+
+// Result describes what a sink persisted
+type Result struct {
+	Reference string // Where the snapshot was persisted, e.g. a commit hash; empty if nothing changed
+	Changes   []ResourceChange
+}
+
+// Counts returns the number of added, modified and deleted resources
+func (r Result) Counts() (added, modified, deleted int) {
+	for _, change := range r.Changes {
+		switch change.Type {
+		case ChangeAdded:
+			added++
+		case ChangeModified:
+			modified++
+		case ChangeDeleted:
+			deleted++
+		}
+	}
+	return added, modified, deleted
+}
+
+// ResourceChange describes a single resource file changed by a backup
+type ResourceChange struct {
+	Type      string
+	Path      string
+	Namespace string
+	Kind      string
+	Name      string
+}
+
+// String returns the namespace/kind/name identifier of the changed resource
+func (c ResourceChange) String() string {
+	if c.Namespace == "" {
+		return fmt.Sprintf("%s/%s", c.Kind, c.Name)
+	}
+	return fmt.Sprintf("%s/%s/%s", c.Namespace, c.Kind, c.Name)
+}
+
+// Describe fills in the resource identity of a change from the content the
+// file had, falling back to the path layout
+func Describe(change *ResourceChange, content []byte) {
+	var obj struct {
+		Kind     string `json:"kind"`
+		Metadata struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"metadata"`
+	}
+	if err := yaml.Unmarshal(content, &obj); err == nil && obj.Kind != "" {
+		change.Namespace = obj.Metadata.Namespace
+		change.Kind = obj.Kind
+		change.Name = obj.Metadata.Name
+		return
+	}
+
+	DescribePath(change)
+}
+
+// DescribePath fills in the resource identity of a change from its path,
+// for files whose content is no longer available. The path is assumed to
+// follow the default layout.
+func DescribePath(change *ResourceChange) {
+	parts := strings.Split(filepath.ToSlash(change.Path), "/")
+	change.Name = strings.TrimSuffix(parts[len(parts)-1], ".yaml")
+	if len(parts) >= 2 {
+		change.Kind = parts[len(parts)-2]
+	}
+	if len(parts) == 4 && parts[0] == "namespaces" {
+		change.Namespace = parts[1]
+	}
+}